@@ -1,10 +1,194 @@
 package backends
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/jarcoal/ego"
+	"net/mail"
+	"reflect"
 )
 
-// Backend is the interface that all backends must implement to send emails
+// Backend is the interface that all backends must implement to send emails.
+// The context carries deadlines and tracing information through to the provider's API.
 type Backend interface {
-	SendEmail(*ego.Email) error
+	SendEmail(context.Context, *ego.Email) (*Result, error)
+}
+
+// Result describes what the provider reported back about a send.  Backends return
+// a result whenever the provider responded, even if the send failed.
+type Result struct {
+	// Identifier the provider assigned to the message, if it reports one.
+	MessageID string
+
+	// Message-ID header the message was sent with, without angle brackets.
+	HeaderMessageID string
+
+	// Status code of the provider's HTTP response, for backends that speak HTTP.
+	StatusCode int
+
+	// Recipients that were dropped by middleware before sending, because they're on a
+	// suppression list.
+	Suppressed []*mail.Address
+
+	// Outcome for each recipient, when the email was split up into several sends.
+	Recipients []*RecipientResult
+}
+
+// RecipientResult is the outcome of a send for a single recipient.
+type RecipientResult struct {
+	Recipient *mail.Address
+	MessageID string
+	Err       error
+}
+
+// PartialError is returned when an email was split up into several sends and some of
+// them failed.  The result returned alongside it says which recipients were affected.
+type PartialError struct {
+	Failed, Total int
+
+	// The errors from the failed sends.
+	Errs []error
+}
+
+func (p *PartialError) Error() string {
+	return fmt.Sprintf("%d of %d recipients failed: %s", p.Failed, p.Total, p.Errs[0])
+}
+
+// Unwrap allows errors.Is and errors.As to look at the individual errors.
+func (p *PartialError) Unwrap() []error {
+	return p.Errs
+}
+
+// UnsupportedError is returned by backends in strict mode when an email uses something
+// their provider can't express, rather than sending it in some approximate form.
+type UnsupportedError struct {
+	Backend string
+
+	// Feature is what the provider lacks, such as "cc".
+	Feature string
+}
+
+func (u *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s doesn't support %s", u.Backend, u.Feature)
+}
+
+// Is reports that the email wasn't sent, see ErrNotSent.
+func (u *UnsupportedError) Is(target error) bool {
+	return target == ErrNotSent
+}
+
+// ErrNotSent is matched by errors.Is for errors returned before anything was handed to
+// the provider, such as an email that can't be rendered or an attachment that can't be
+// read, so that callers know the email certainly didn't go out and can retry it.
+var ErrNotSent = errors.New("email not sent")
+
+// NotSent marks err as having happened before anything was handed to the provider, so
+// that it matches ErrNotSent.  Its message is unchanged.
+func NotSent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &notSentError{err}
+}
+
+type notSentError struct {
+	err error
+}
+
+func (n *notSentError) Error() string {
+	return n.err.Error()
+}
+
+func (n *notSentError) Unwrap() error {
+	return n.err
+}
+
+func (n *notSentError) Is(target error) bool {
+	return target == ErrNotSent
+}
+
+// BackendFunc adapts an ordinary function to the Backend interface.
+type BackendFunc func(context.Context, *ego.Email) (*Result, error)
+
+// SendEmail calls f(ctx, e).
+func (f BackendFunc) SendEmail(ctx context.Context, e *ego.Email) (*Result, error) {
+	return f(ctx, e)
+}
+
+// Middleware wraps a Backend with additional behavior, such as logging or metrics.
+type Middleware func(Backend) Backend
+
+// Chain wraps the backend with the given middleware.  The first middleware is the
+// outermost one, so it is the first to see an email being sent.
+func Chain(b Backend, middleware ...Middleware) Backend {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		b = middleware[i](b)
+	}
+	return b
+}
+
+// Namer is implemented by backends that have a short, stable name such as "mandrill".
+type Namer interface {
+	Name() string
+}
+
+// Unwrapper is implemented by backends that wrap another backend.
+type Unwrapper interface {
+	Unwrap() Backend
+}
+
+// Wrap returns a Backend that sends emails with fn, but unwraps to next so that
+// lookups like Name can see through the middleware.
+func Wrap(next Backend, fn BackendFunc) Backend {
+	return &wrappedBackend{next, fn}
+}
+
+type wrappedBackend struct {
+	next Backend
+	fn   BackendFunc
+}
+
+func (w *wrappedBackend) SendEmail(ctx context.Context, e *ego.Email) (*Result, error) {
+	return w.fn(ctx, e)
+}
+
+func (w *wrappedBackend) Unwrap() Backend {
+	return w.next
+}
+
+// As finds the first backend in b's chain of middleware that can be assigned to target,
+// which must be a non-nil pointer to an interface or backend type, and sets target to it.
+// It works like errors.As, following Unwrap.
+func As(b Backend, target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		panic("backends: target must be a non-nil pointer")
+	}
+	targetType := val.Type().Elem()
+
+	for b != nil {
+		if reflect.TypeOf(b).AssignableTo(targetType) {
+			val.Elem().Set(reflect.ValueOf(b))
+			return true
+		}
+
+		u, ok := b.(Unwrapper)
+		if !ok {
+			break
+		}
+		b = u.Unwrap()
+	}
+
+	return false
+}
+
+// Name returns the name of the backend, looking through any middleware wrapped around it.
+// Backends that don't implement Namer are reported as "unknown".
+func Name(b Backend) string {
+	var n Namer
+	if As(b, &n) {
+		return n.Name()
+	}
+	return "unknown"
 }
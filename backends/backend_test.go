@@ -0,0 +1,98 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"testing"
+)
+
+type namedBackend struct{}
+
+func (n *namedBackend) Name() string {
+	return "named"
+}
+
+func (n *namedBackend) SendEmail(context.Context, *ego.Email) (*Result, error) {
+	return &Result{}, nil
+}
+
+// TestChain checks that middleware is applied outermost-first.
+func TestChain(t *testing.T) {
+	order := ""
+
+	middleware := func(name string) Middleware {
+		return func(next Backend) Backend {
+			return Wrap(next, func(ctx context.Context, e *ego.Email) (*Result, error) {
+				order += name
+				return next.SendEmail(ctx, e)
+			})
+		}
+	}
+
+	b := Chain(&namedBackend{}, middleware("a"), middleware("b"))
+	if _, err := b.SendEmail(context.Background(), ego.NewEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	if order != "ab" {
+		t.FailNow()
+	}
+}
+
+// TestName checks that Name looks through middleware.
+func TestName(t *testing.T) {
+	noop := func(context.Context, *ego.Email) (*Result, error) { return nil, nil }
+
+	wrapped := Wrap(&namedBackend{}, noop)
+
+	if Name(wrapped) != "named" {
+		t.FailNow()
+	}
+
+	if Name(BackendFunc(noop)) != "unknown" {
+		t.FailNow()
+	}
+}
+
+// TestAs checks that As finds backends behind middleware.
+func TestAs(t *testing.T) {
+	inner := &namedBackend{}
+	wrapped := Wrap(inner, func(context.Context, *ego.Email) (*Result, error) { return nil, nil })
+
+	var found *namedBackend
+	if !As(wrapped, &found) || found != inner {
+		t.FailNow()
+	}
+
+	var overrider RecipientOverrider
+	if As(wrapped, &overrider) {
+		t.FailNow()
+	}
+}
+
+// TestSendAll checks that results are combined per recipient.
+func TestSendAll(t *testing.T) {
+	b := BackendFunc(func(ctx context.Context, e *ego.Email) (*Result, error) {
+		if e.To[0].Email.Address == "fail@test.com" {
+			return nil, errors.New("boom")
+		}
+		return &Result{MessageID: e.To[0].Email.Address}, nil
+	})
+
+	emails := []*ego.Email{ego.NewEmail(), ego.NewEmail()}
+	emails[0].AddRecipient("", "ok@test.com", nil)
+	emails[0].AddRecipient("", "ok2@test.com", nil)
+	emails[1].AddRecipient("", "fail@test.com", nil)
+
+	result, err := SendAll(context.Background(), b, emails, 2)
+
+	var partial *PartialError
+	if !errors.As(err, &partial) || partial.Failed != 1 || partial.Total != 3 {
+		t.Fatal(err)
+	}
+
+	if len(result.Recipients) != 3 || result.Recipients[1].MessageID != "ok@test.com" {
+		t.FailNow()
+	}
+}
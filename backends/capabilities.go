@@ -0,0 +1,60 @@
+package backends
+
+import (
+	"context"
+)
+
+// RecipientOverrider is implemented by backends whose provider can redirect every
+// recipient of an email to a single address on its end, while still rendering the
+// email as it would have been for the original recipients.
+type RecipientOverrider interface {
+	Backend
+	SupportsRecipientOverride() bool
+}
+
+// Personalizer is implemented by backends whose provider can render a template with each
+// recipient's own TemplateContext in a single send.
+type Personalizer interface {
+	Backend
+	SupportsPersonalization() bool
+}
+
+// HeaderPersonalizer is implemented by backends whose provider fills in merge tags in an
+// email's headers, such as its List-Unsubscribe header, with each recipient's own
+// TemplateContext in a single send.
+type HeaderPersonalizer interface {
+	Backend
+
+	// HeaderMergeTag returns the merge tag for the variable as it's written in headers,
+	// or "" if the backend can't personalize headers.
+	HeaderMergeTag(name string) string
+}
+
+// EnvelopeSender is implemented by backends that deliver emails with their whole
+// ReturnPath as the SMTP envelope sender.  Providers that only take its domain, or that
+// handle bounces themselves, don't.
+type EnvelopeSender interface {
+	Backend
+	SupportsEnvelopeSender() bool
+}
+
+// RecipientLimiter is implemented by backends whose provider caps the number of
+// recipients (To, Cc and Bcc combined) in a single send.
+type RecipientLimiter interface {
+	Backend
+	MaxRecipients() int
+}
+
+type recipientOverrideKey struct{}
+
+// WithRecipientOverride returns a context that asks a RecipientOverrider to deliver
+// the email to address instead of its recipients.
+func WithRecipientOverride(ctx context.Context, address string) context.Context {
+	return context.WithValue(ctx, recipientOverrideKey{}, address)
+}
+
+// RecipientOverride returns the override address set on the context, if any.
+func RecipientOverride(ctx context.Context) string {
+	address, _ := ctx.Value(recipientOverrideKey{}).(string)
+	return address
+}
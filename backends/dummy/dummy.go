@@ -5,6 +5,7 @@
 package dummy
 
 import (
+	"context"
 	"github.com/jarcoal/ego"
 	"github.com/jarcoal/ego/backends"
 	"strings"
@@ -24,20 +25,49 @@ type dummyBackend struct {
 	log logger
 }
 
-func (d *dummyBackend) SendEmail(e *ego.Email) error {
+func (d *dummyBackend) Name() string {
+	return "dummy"
+}
+
+func (d *dummyBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	messageID, threading := e.ThreadingHeaders()
+	result := &backends.Result{HeaderMessageID: messageID}
+
 	if d.log == nil {
-		return nil
+		return result, nil
 	}
 
-	recipients := []string{}
+	d.log("To: %s", formatRecipients(e.To))
 
-	for _, recip := range e.To {
-		recipients = append(recipients, recip.Email.String())
+	if len(e.Cc) > 0 {
+		d.log("Cc: %s", formatRecipients(e.Cc))
+	}
+
+	if len(e.Bcc) > 0 {
+		d.log("Bcc: %s", formatRecipients(e.Bcc))
 	}
 
-	d.log("To: %s", strings.Join(recipients, ", "))
 	d.log("From: %s", e.From)
+
+	if e.ReturnPath != "" {
+		d.log("ReturnPath: %s", e.ReturnPath)
+	}
+
 	d.log("Subject: %s", e.Subject)
+	d.log("Message-ID: %s", threading["Message-ID"])
+
+	if e.InReplyTo != "" {
+		d.log("In-Reply-To: %s", threading["In-Reply-To"])
+	}
+
+	if len(e.References) > 0 {
+		d.log("References: %s", threading["References"])
+	}
+
+	if list := e.ListHeaders(); len(list) > 0 {
+		d.log("List-Unsubscribe: %s", list["List-Unsubscribe"])
+	}
+
 	d.log("TrackClicks: %t", e.TrackClicks)
 	d.log("TrackOpens: %t", e.TrackOpens)
 	d.log("VisibleRecipients: %t", e.VisibleRecipients)
@@ -59,11 +89,19 @@ func (d *dummyBackend) SendEmail(e *ego.Email) error {
 		d.log("DeliveryTime: %s", e.DeliveryTime)
 	}
 
+	if e.Event != nil {
+		d.log("Event: %s %s (%s, %s - %s)", e.Event.Method, e.Event.UID, e.Event.Summary, e.Event.Start, e.Event.End)
+	}
+
 	if len(e.Attachments) > 0 {
 		attachmentNames := []string{}
 
 		for _, attachment := range e.Attachments {
-			attachmentNames = append(attachmentNames, attachment.Name)
+			if attachment.Inline() {
+				attachmentNames = append(attachmentNames, attachment.Name+" (cid:"+attachment.ContentID+")")
+			} else {
+				attachmentNames = append(attachmentNames, attachment.Name)
+			}
 		}
 
 		d.log("Attachments: %s", strings.Join(attachmentNames, ", "))
@@ -72,5 +110,15 @@ func (d *dummyBackend) SendEmail(e *ego.Email) error {
 	d.log("TextBody: %s", e.TextBody)
 	d.log("HTMLBody: %s", e.HTMLBody)
 
-	return nil
+	return result, nil
+}
+
+func formatRecipients(recipients []*ego.Recipient) string {
+	addresses := []string{}
+
+	for _, recip := range recipients {
+		addresses = append(addresses, recip.Email.String())
+	}
+
+	return strings.Join(addresses, ", ")
 }
@@ -1,8 +1,10 @@
 package dummy
 
 import (
+	"context"
 	"fmt"
 	"github.com/jarcoal/ego/testutils"
+	"strings"
 	"testing"
 )
 
@@ -17,9 +19,29 @@ func TestDummyLogging(t *testing.T) {
 	b := NewBackend(logger)
 	e := testutils.TestEmail()
 
-	b.SendEmail(e)
+	b.SendEmail(context.Background(), e)
 
 	if len(logs) == 0 {
 		t.FailNow()
 	}
 }
+
+// TestDummyRecipients checks that cc and bcc recipients are logged
+func TestDummyRecipients(t *testing.T) {
+	logs := make([]string, 0)
+
+	logger := func(format string, vars ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, vars...))
+	}
+
+	e := testutils.TestEmail()
+	recipients := e.To
+	e.To, e.Cc, e.Bcc = recipients[:1], recipients[1:3], recipients[3:4]
+
+	NewBackend(logger).SendEmail(context.Background(), e)
+
+	if logs[1] != "Cc: "+e.Cc[0].Email.String()+", "+e.Cc[1].Email.String() ||
+		!strings.HasPrefix(logs[2], "Bcc: ") {
+		t.Fatal(logs)
+	}
+}
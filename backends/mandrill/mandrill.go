@@ -7,40 +7,85 @@ package mandrill
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/jarcoal/ego"
 	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/mergetag"
 	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 const deliveryTimeFmt = "2006-01-02T15:04:05"
-const apiURLFmt = "https://mandrillapp.com/api/1.0/messages/%s.json"
 
-var _ backends.Backend = (*mandrillBackend)(nil)
+var apiURLFmt = "https://mandrillapp.com/api/1.0/messages/%s.json"
+
+var _ backends.Personalizer = (*mandrillBackend)(nil)
+var _ backends.HeaderPersonalizer = (*mandrillBackend)(nil)
 
 // NewBackend returns a Mandrill backend bound to the API key
-func NewBackend(apiKey string) backends.Backend {
-	return &mandrillBackend{apiKey}
+func NewBackend(apiKey string, opts ...Option) backends.Backend {
+	m := &mandrillBackend{apiKey: apiKey, mergeTags: mergetag.Mandrill}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Option configures the backend.
+type Option func(*mandrillBackend)
+
+// MergeTags has the backend translate merge tags in the subject and bodies of emails
+// from the given syntax to mandrill's.
+func MergeTags(from mergetag.Syntax) Option {
+	return func(m *mandrillBackend) {
+		m.mergeTags = from
+	}
 }
 
 type mandrillBackend struct {
 	apiKey string
+
+	// syntax of the merge tags in the emails we're given
+	mergeTags mergetag.Syntax
+}
+
+// SupportsPersonalization reports that each recipient's template context is sent along
+// with the email.
+func (m *mandrillBackend) SupportsPersonalization() bool {
+	return true
+}
+
+// HeaderMergeTag returns mandrill's merge tag for the variable, which mandrill fills in
+// within headers too.  Headers aren't translated from the MergeTags syntax.
+func (m *mandrillBackend) HeaderMergeTag(name string) string {
+	return mergetag.Format(mergetag.Mandrill, name)
+}
+
+func (m *mandrillBackend) Name() string {
+	return "mandrill"
 }
 
-func (m *mandrillBackend) SendEmail(e *ego.Email) error {
+func (m *mandrillBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	// decide on the Message-ID here, so that it can be reported back
+	if e.MessageID == "" {
+		e = e.Clone()
+		e.MessageID = ego.NewMessageID(e.From)
+	}
+
 	// convert the email to a mandrillEmail struct that will be json-serialized and sent out
 	wrapper, err := m.mandrillWrapperForEmail(e)
 	if err != nil {
-		return fmt.Errorf("failed to build mandrill email: %s", err)
+		return nil, backends.NotSent(fmt.Errorf("failed to build mandrill email: %s", err))
 	}
 
 	// wrap the mandrill email and encode it
 	body, err := json.Marshal(wrapper)
 	if err != nil {
-		return fmt.Errorf("failed to encode mandrill payload: %s", err)
+		return nil, backends.NotSent(fmt.Errorf("failed to encode mandrill payload: %s", err))
 	}
 
 	// mandrill uses different endpoints if you're sending a templated email
@@ -52,37 +97,54 @@ func (m *mandrillBackend) SendEmail(e *ego.Email) error {
 	}
 
 	// make the request to mandrill's api
-	resp, err := http.Post(apiURL, "application/json", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to post to mandrill: %s", err)
+		return nil, backends.NotSent(fmt.Errorf("failed to build mandrill request: %s", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to post to mandrill: %s", err)
 	}
 	defer resp.Body.Close()
 
+	result := &backends.Result{StatusCode: resp.StatusCode, HeaderMessageID: e.MessageID}
+
 	// if we got a bad status code, read out the error body
 	if resp.StatusCode != 200 {
 		mandrillErr := &mandrillError{}
 
 		if err := json.NewDecoder(resp.Body).Decode(mandrillErr); err != nil {
-			return fmt.Errorf("received %s from mandrill and couldn't decode error payload: %s",
+			return result, fmt.Errorf("received %s from mandrill and couldn't decode error payload: %s",
 				resp.Status, err)
 		}
 
-		return mandrillErr
+		return result, mandrillErr
 	}
 
-	return nil
+	// mandrill reports a status for every recipient
+	sendResults := []*mandrillSendResult{}
+	if err := json.NewDecoder(resp.Body).Decode(&sendResults); err != nil {
+		return result, fmt.Errorf("failed to decode mandrill response: %s", err)
+	}
+
+	if len(sendResults) > 0 {
+		result.MessageID = sendResults[0].ID
+	}
+
+	return result, nil
 }
 
 func (m *mandrillBackend) mandrillWrapperForEmail(e *ego.Email) (*mandrillWrapper, error) {
 	me := &mandrillEmail{
-		To:                 make([]*mandrillRecipient, 0, len(e.To)),
+		To:                 make([]*mandrillRecipient, 0, len(e.To)+len(e.Cc)+len(e.Bcc)),
 		Attachments:        make([]*mandrillAttachment, 0, len(e.Attachments)),
 		GlobalMergeVars:    make([]*mandrillTemplateContext, 0),
 		MergeVars:          make([]*mandrillRecipientContext, 0),
-		Headers:            make(map[string]string),
-		HTML:               e.HTMLBody,
-		Text:               e.TextBody,
-		Subject:            e.Subject,
+		HTML:               mergetag.Translate(e.HTMLBody, m.mergeTags, mergetag.Mandrill),
+		Text:               mergetag.Translate(e.TextBody, m.mergeTags, mergetag.Mandrill),
+		Subject:            mergetag.Translate(e.Subject, m.mergeTags, mergetag.Mandrill),
 		FromEmail:          e.From.Address,
 		FromName:           e.From.Name,
 		TrackOpens:         e.TrackOpens,
@@ -92,43 +154,74 @@ func (m *mandrillBackend) mandrillWrapperForEmail(e *ego.Email) (*mandrillWrappe
 		PreserveRecipients: e.VisibleRecipients,
 	}
 
-	if len(e.Headers) > 0 {
-		for header := range e.Headers {
-			me.Headers[header] = e.Headers.Get(header)
-		}
+	// mandrill handles bounces itself, but can use a return path in our domain
+	if at := strings.LastIndex(e.ReturnPath, "@"); at >= 0 {
+		me.ReturnPathDomain = e.ReturnPath[at+1:]
+	}
+
+	// headers that thread the email and its unsubscribe links, which the email's own
+	// headers can override
+	_, me.Headers = e.ThreadingHeaders()
+	for header, value := range e.ListHeaders() {
+		me.Headers[header] = value
+	}
+
+	for header := range e.Headers {
+		me.Headers[header] = e.Headers.Get(header)
 	}
 
 	if e.ReplyTo != nil {
 		me.Headers["Reply-To"] = e.ReplyTo.Address
 	}
 
-	// assign the recipients
-	for _, to := range e.To {
-		me.To = append(me.To, &mandrillRecipient{"to", to.Email.Name,
-			to.Email.Address})
-
-		// if there is template context associate with the recipient,
-		// append it to the merge var slice.
-		if to.TemplateContext != nil {
-			me.MergeVars = append(me.MergeVars, &mandrillRecipientContext{
-				Recipient: to.Email.Address,
-				Vars:      emailCtxToMandrillCtx(to.TemplateContext),
-			})
+	// assign the recipients.  mandrill takes them all in "to", with their type.
+	recipientTypes := []struct {
+		name       string
+		recipients []*ego.Recipient
+	}{{"to", e.To}, {"cc", e.Cc}, {"bcc", e.Bcc}}
+
+	for _, rt := range recipientTypes {
+		for _, r := range rt.recipients {
+			me.To = append(me.To, &mandrillRecipient{rt.name, r.Email.Name,
+				r.Email.Address})
+
+			// if there is template context associate with the recipient,
+			// append it to the merge var slice.
+			if r.TemplateContext != nil {
+				me.MergeVars = append(me.MergeVars, &mandrillRecipientContext{
+					Recipient: r.Email.Address,
+					Vars:      emailCtxToMandrillCtx(r.TemplateContext),
+				})
+			}
 		}
 	}
 
-	// add attachments
-	for _, attachment := range e.Attachments {
+	// add attachments, including the invitation for an event
+	attachments, err := e.AllAttachments()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range attachments {
 		attachmentBytes, err := ioutil.ReadAll(attachment.Data)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read %s attachment: %s", attachment.Name, err)
 		}
 
-		me.Attachments = append(me.Attachments, &mandrillAttachment{
+		encoded := &mandrillAttachment{
 			attachment.Mimetype,
 			attachment.Name,
 			base64.StdEncoding.EncodeToString(attachmentBytes),
-		})
+		}
+
+		// inline images go in their own list, named by their content id
+		if attachment.Inline() && strings.HasPrefix(attachment.Mimetype, "image/") {
+			encoded.Name = attachment.ContentID
+			me.Images = append(me.Images, encoded)
+			continue
+		}
+
+		me.Attachments = append(me.Attachments, encoded)
 	}
 
 	// assign template context, if any
@@ -171,6 +264,7 @@ type mandrillWrapper struct {
 type mandrillEmail struct {
 	To                 []*mandrillRecipient        `json:"to"`
 	Attachments        []*mandrillAttachment       `json:"attachments,omitempty"`
+	Images             []*mandrillAttachment       `json:"images,omitempty"`
 	HTML               string                      `json:"html,omitempty"`
 	Text               string                      `json:"text,omitempty"`
 	Subject            string                      `json:"subject"`
@@ -181,6 +275,7 @@ type mandrillEmail struct {
 	Tags               []string                    `json:"tags,omitempty"`
 	Subaccount         string                      `json:"subaccount,omitempty"`
 	PreserveRecipients bool                        `json:"preserve_recipients"`
+	ReturnPathDomain   string                      `json:"return_path_domain,omitempty"`
 	Headers            map[string]string           `json:"headers,omitempty"`
 	GlobalMergeVars    []*mandrillTemplateContext  `json:"global_merge_vars,omitempty"`
 	MergeVars          []*mandrillRecipientContext `json:"merge_vars,omitempty"`
@@ -201,7 +296,7 @@ type mandrillTemplateContext struct {
 
 // mandrillRecipient represents a single recipient in a mandrill email
 type mandrillRecipient struct {
-	Type  string `json:"type"`  // always set to 'to'
+	Type  string `json:"type"`  // 'to', 'cc' or 'bcc'
 	Name  string `json:"name"`  // recipient's name
 	Email string `json:"email"` // recipient's address
 }
@@ -209,10 +304,18 @@ type mandrillRecipient struct {
 // mandrillAttachment represents a single attachment in a mandrill email
 type mandrillAttachment struct {
 	Type    string `json:"type"`    // mimetype of the attachment
-	Name    string `json:"name"`    // file name of the attachment
+	Name    string `json:"name"`    // file name of the attachment, or content id of an image
 	Content string `json:"content"` // base64-encoded version of the file
 }
 
+// mandrillSendResult is the status of a single recipient, as returned by mandrill after a send
+type mandrillSendResult struct {
+	Email        string `json:"email"`
+	Status       string `json:"status"`
+	RejectReason string `json:"reject_reason"`
+	ID           string `json:"_id"`
+}
+
 // mandrillError represents a json-encoded error returned by mandrill from an api call
 type mandrillError struct {
 	Status  string `json:"status"`
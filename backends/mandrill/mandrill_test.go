@@ -1,15 +1,22 @@
 package mandrill
 
 import (
+	"context"
 	"encoding/base64"
+	"encoding/json"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/mergetag"
 	"github.com/jarcoal/ego/testutils"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
 
-var b = mandrillBackend{"abc123"}
+var b = mandrillBackend{apiKey: "abc123", mergeTags: mergetag.Mandrill}
 
 // TestWrapper checks the JSON wrapper we send to Mandrill
 func TestWrapper(t *testing.T) {
@@ -184,3 +191,165 @@ func TestAttachments(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// TestInlineImages checks that inline images are sent as mandrill images
+func TestInlineImages(t *testing.T) {
+	e := testutils.TestEmail()
+	e.AddAttachment("report.pdf", "application/pdf", strings.NewReader("%PDF"))
+	e.AddInlineAttachment("logo", "logo.png", "image/png", strings.NewReader("png"))
+
+	wrapper, err := b.mandrillWrapperForEmail(e)
+	if err != nil {
+		t.FailNow()
+	}
+
+	me := wrapper.Message
+
+	if len(me.Attachments) != 1 || me.Attachments[0].Name != "report.pdf" {
+		t.FailNow()
+	}
+
+	if len(me.Images) != 1 || me.Images[0].Name != "logo" || me.Images[0].Type != "image/png" ||
+		me.Images[0].Content != base64.StdEncoding.EncodeToString([]byte("png")) {
+		t.FailNow()
+	}
+}
+
+// TestEvent checks that an event's invitation is attached
+func TestEvent(t *testing.T) {
+	e := testutils.TestEmail()
+	e.Event = &ego.Event{
+		UID:       "launch@example.com",
+		Start:     time.Date(2026, 10, 31, 13, 0, 0, 0, time.UTC),
+		End:       time.Date(2026, 10, 31, 14, 0, 0, 0, time.UTC),
+		Organizer: e.From,
+	}
+
+	wrapper, err := b.mandrillWrapperForEmail(e)
+	if err != nil {
+		t.FailNow()
+	}
+
+	attachments := wrapper.Message.Attachments
+	if len(attachments) != 1 || attachments[0].Name != "invite.ics" || attachments[0].Type != e.Event.ContentType() {
+		t.FailNow()
+	}
+
+	e.Event.UID = ""
+	if _, err := b.mandrillWrapperForEmail(e); err == nil {
+		t.FailNow()
+	}
+}
+
+// TestSendEmail checks that the provider's response is reported in the result
+func TestSendEmail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"email":"zane@anastacio.co.uk","status":"sent","_id":"abc123"}]`))
+	}))
+	defer server.Close()
+
+	defer func(orig string) { apiURLFmt = orig }(apiURLFmt)
+	apiURLFmt = server.URL + "/%s.json"
+
+	result, err := b.SendEmail(context.Background(), testutils.TestEmail())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.MessageID != "abc123" || result.StatusCode != 200 {
+		t.FailNow()
+	}
+}
+
+// TestMergeTags checks that merge tags are translated to mandrill's syntax
+func TestMergeTags(t *testing.T) {
+	b := NewBackend("abc123", MergeTags(mergetag.Neutral)).(*mandrillBackend)
+
+	e := testutils.TestEmail()
+	e.Subject = "Hello ${name}"
+
+	wrapper, err := b.mandrillWrapperForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wrapper.Message.Subject != "Hello *|name|*" {
+		t.FailNow()
+	}
+}
+
+// TestCcBcc checks that cc and bcc recipients are sent with their type
+func TestCcBcc(t *testing.T) {
+	e := testutils.TestEmail()
+	recipients := e.To
+	e.To, e.Cc, e.Bcc = recipients[:1], recipients[1:3], recipients[3:4]
+
+	wrapper, err := b.mandrillWrapperForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := wrapper.Message.To
+	if len(to) != 4 || len(wrapper.Message.MergeVars) != 4 {
+		t.FailNow()
+	}
+
+	for i, typ := range []string{"to", "cc", "cc", "bcc"} {
+		if to[i].Type != typ || to[i].Email != recipients[i].Email.Address {
+			t.Fatal(to[i])
+		}
+	}
+}
+
+// TestThreading checks that the threading headers are sent and the Message-ID reported back
+func TestThreading(t *testing.T) {
+	var headers map[string]string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wrapper := &mandrillWrapper{}
+		json.NewDecoder(r.Body).Decode(wrapper)
+		headers = wrapper.Message.Headers
+
+		w.Write([]byte(`[{"email":"zane@anastacio.co.uk","status":"sent","_id":"abc123"}]`))
+	}))
+	defer server.Close()
+
+	defer func(orig string) { apiURLFmt = orig }(apiURLFmt)
+	apiURLFmt = server.URL + "/%s.json"
+
+	e := testutils.TestEmail()
+	e.MessageID = "reply@austen.name"
+	e.SetInReplyTo("first@austen.name", nil)
+
+	result, err := b.SendEmail(context.Background(), e)
+	if err != nil || result.HeaderMessageID != e.MessageID {
+		t.Fatal(result, err)
+	}
+
+	if headers["Message-ID"] != "<reply@austen.name>" || headers["In-Reply-To"] != "<first@austen.name>" ||
+		headers["References"] != "<first@austen.name>" {
+		t.Fatal(headers)
+	}
+
+	// without one, a Message-ID is generated for the send
+	result, err = b.SendEmail(context.Background(), testutils.TestEmail())
+	if err != nil || !strings.HasSuffix(result.HeaderMessageID, "@austen.name") ||
+		headers["Message-ID"] != "<"+result.HeaderMessageID+">" {
+		t.Fatal(result, err)
+	}
+}
+
+// TestReturnPath checks that the domain of the return path is sent
+func TestReturnPath(t *testing.T) {
+	e := testutils.TestEmail()
+	e.ReturnPath = "bounces+zane=anastacio.co.uk@mail.austen.name"
+
+	wrapper, err := b.mandrillWrapperForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wrapper.Message.ReturnPathDomain != "mail.austen.name" {
+		t.Fatal(wrapper.Message.ReturnPathDomain)
+	}
+}
@@ -7,75 +7,161 @@ package postageapp
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/jarcoal/ego"
 	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/mergetag"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
-const apiURL = "https://api.postageapp.com/v.1.0/send_message.json"
+var apiURL = "https://api.postageapp.com/v.1.0/send_message.json"
 
-var _ backends.Backend = (*postageAppBackend)(nil)
+var _ backends.RecipientOverrider = (*postageAppBackend)(nil)
+var _ backends.Personalizer = (*postageAppBackend)(nil)
 
 // NewBackend returns a Postageapp backend bound to the API key
-func NewBackend(apiKey string) backends.Backend {
-	return &postageAppBackend{apiKey}
+func NewBackend(apiKey string, opts ...Option) backends.Backend {
+	p := &postageAppBackend{apiKey: apiKey, mergeTags: mergetag.PostageApp}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Option configures the backend.
+type Option func(*postageAppBackend)
+
+// MergeTags has the backend translate merge tags in the subject and bodies of emails
+// from the given syntax to postageapp's.
+func MergeTags(from mergetag.Syntax) Option {
+	return func(p *postageAppBackend) {
+		p.mergeTags = from
+	}
+}
+
+// Strict has the backend refuse emails that postageapp can't express, with a
+// *backends.UnsupportedError: those with Cc recipients or a ReturnPath.  Otherwise Cc
+// recipients are sent a copy like the other recipients, without being shown as Cc'd,
+// and the ReturnPath is ignored.
+func Strict() Option {
+	return func(p *postageAppBackend) {
+		p.strict = true
+	}
 }
 
 type postageAppBackend struct {
 	apiKey string
+	strict bool
+
+	// syntax of the merge tags in the emails we're given
+	mergeTags mergetag.Syntax
+}
+
+// SupportsRecipientOverride reports that PostageApp can redirect an email's recipients,
+// see backends.WithRecipientOverride.
+func (p *postageAppBackend) SupportsRecipientOverride() bool {
+	return true
+}
+
+// SupportsPersonalization reports that each recipient's template context is sent along
+// with the email.
+func (p *postageAppBackend) SupportsPersonalization() bool {
+	return true
+}
+
+func (p *postageAppBackend) Name() string {
+	return "postageapp"
 }
 
-func (p *postageAppBackend) SendEmail(e *ego.Email) error {
+func (p *postageAppBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	// decide on the Message-ID here, so that it can be reported back
+	if e.MessageID == "" {
+		e = e.Clone()
+		e.MessageID = ego.NewMessageID(e.From)
+	}
+
 	wrapper, err := p.wrapperForEmail(e)
 	if err != nil {
-		return fmt.Errorf("failed to build postageapp wrapper: %s", err)
+		return nil, backends.NotSent(fmt.Errorf("failed to build postageapp wrapper: %s", err))
 	}
 
+	// postageapp will deliver everything to this address instead, if set
+	wrapper.Arguments.RecipientOverride = backends.RecipientOverride(ctx)
+
 	body, err := json.Marshal(wrapper)
 	if err != nil {
-		return fmt.Errorf("failed to encode postageapp payload: %s", err)
+		return nil, backends.NotSent(fmt.Errorf("failed to encode postageapp payload: %s", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, backends.NotSent(fmt.Errorf("failed to build postageapp request: %s", err))
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.Post(apiURL, "application/json", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to post to postageapp: %s", err)
+		return nil, fmt.Errorf("failed to post to postageapp: %s", err)
 	}
 	defer resp.Body.Close()
 
+	result := &backends.Result{StatusCode: resp.StatusCode, HeaderMessageID: e.MessageID}
+
 	if resp.StatusCode != 200 {
 		postageAppErr := &postageAppError{}
 
 		if err := json.NewDecoder(resp.Body).Decode(postageAppErr); err != nil {
-			return fmt.Errorf("received %s from postageapp and couldn't decode error payload: %s",
+			return result, fmt.Errorf("received %s from postageapp and couldn't decode error payload: %s",
 				resp.Status, err)
 		}
 
-		return postageAppErr
+		return result, postageAppErr
+	}
+
+	paResp := &postageAppResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(paResp); err != nil {
+		return result, fmt.Errorf("failed to decode postageapp response: %s", err)
+	}
+
+	if paResp.Data.Message.ID != 0 {
+		result.MessageID = strconv.FormatInt(paResp.Data.Message.ID, 10)
 	}
 
-	return nil
+	return result, nil
 }
 
 func (p *postageAppBackend) wrapperForEmail(e *ego.Email) (*postageAppWrapper, error) {
 	pa := &postageAppArguments{
 		Recipients: make(map[string]map[string]string),
 		Headers: map[string]string{
-			"subject": e.Subject,
+			"subject": mergetag.Translate(e.Subject, p.mergeTags, mergetag.PostageApp),
 			"from":    e.From.String(),
 		},
 		Content: map[string]string{
-			"text/plain": e.TextBody,
-			"text/html":  e.HTMLBody,
+			"text/plain": mergetag.Translate(e.TextBody, p.mergeTags, mergetag.PostageApp),
+			"text/html":  mergetag.Translate(e.HTMLBody, p.mergeTags, mergetag.PostageApp),
 		},
 	}
 
-	// recipients
-	for _, to := range e.To {
-		pa.Recipients[to.Email.String()] = to.TemplateContext
+	if len(e.Cc) > 0 && p.strict {
+		return nil, &backends.UnsupportedError{Backend: p.Name(), Feature: "cc"}
+	}
+
+	if e.ReturnPath != "" && p.strict {
+		return nil, &backends.UnsupportedError{Backend: p.Name(), Feature: "return path"}
+	}
+
+	// recipients.  postageapp sends each of them their own copy, addressed only to them,
+	// so bcc comes for free and cc can only be approximated.
+	for _, recipients := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+		for _, r := range recipients {
+			pa.Recipients[r.Email.String()] = r.TemplateContext
+		}
 	}
 
 	// reply to
@@ -83,18 +169,31 @@ func (p *postageAppBackend) wrapperForEmail(e *ego.Email) (*postageAppWrapper, e
 		pa.Headers["reply-to"] = e.ReplyTo.String()
 	}
 
-	// headers
-	if len(e.Headers) > 0 {
-		for header := range e.Headers {
-			pa.Headers[header] = e.Headers.Get(header)
-		}
+	// headers, along with the ones that thread the email and its unsubscribe links
+	_, threading := e.ThreadingHeaders()
+	for header, value := range threading {
+		pa.Headers[header] = value
+	}
+	for header, value := range e.ListHeaders() {
+		pa.Headers[header] = value
+	}
+
+	for header := range e.Headers {
+		pa.Headers[header] = e.Headers.Get(header)
 	}
 
-	// attachments
-	if len(e.Attachments) > 0 {
+	// attachments, including the invitation for an event.  postageapp has no notion of
+	// content ids, so inline attachments are sent like any other and cid: references to
+	// them won't resolve.
+	attachments, err := e.AllAttachments()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attachments) > 0 {
 		pa.Attachments = make(map[string]*postageAppAttachment)
 
-		for _, attachment := range e.Attachments {
+		for _, attachment := range attachments {
 			data, err := ioutil.ReadAll(attachment.Data)
 			if err != nil {
 				return nil, fmt.Errorf("failed to read %s attachment: %s", attachment.Name, err)
@@ -114,8 +213,10 @@ func (p *postageAppBackend) wrapperForEmail(e *ego.Email) (*postageAppWrapper, e
 	}
 
 	// put it in the wrapper
+	// postageapp won't send the same uid twice
 	wrapper := &postageAppWrapper{
 		APIKey:    p.apiKey,
+		UID:       e.IdempotencyKey,
 		Arguments: pa,
 	}
 
@@ -143,6 +244,15 @@ type postageAppAttachment struct {
 	Content     string `json:"content"`
 }
 
+// postageAppResponse is the body PostageApp returns after a successful send
+type postageAppResponse struct {
+	Data struct {
+		Message struct {
+			ID int64 `json:"id"`
+		} `json:"message"`
+	} `json:"data"`
+}
+
 // postageAppError is a representation of an error response from PostageApp's API
 type postageAppError struct {
 	UID     string `json:"uid"`
@@ -1,16 +1,23 @@
 package postageapp
 
 import (
+	"context"
 	"encoding/base64"
+	"encoding/json"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/mergetag"
 	"github.com/jarcoal/ego/testutils"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
 const apiKey = "abc123"
 
-var b = postageAppBackend{apiKey}
+var b = postageAppBackend{apiKey: apiKey, mergeTags: mergetag.PostageApp}
 
 // TestWrapper checks the JSON wrapper
 func TestWrapper(t *testing.T) {
@@ -25,6 +32,10 @@ func TestWrapper(t *testing.T) {
 		t.FailNow()
 	}
 
+	if wrapper.UID != "" {
+		t.FailNow()
+	}
+
 	if len(wrapper.Arguments.Recipients) != len(e.To) {
 		t.FailNow()
 	}
@@ -50,6 +61,21 @@ func TestWrapper(t *testing.T) {
 	}
 }
 
+// TestIdempotencyKey checks that the idempotency key is sent as the uid
+func TestIdempotencyKey(t *testing.T) {
+	e := testutils.TestEmail()
+	e.IdempotencyKey = "order-1234-receipt"
+
+	wrapper, err := b.wrapperForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wrapper.UID != e.IdempotencyKey {
+		t.FailNow()
+	}
+}
+
 // TestHeaders checks that headers are set correctly
 func TestHeaders(t *testing.T) {
 	e := testutils.TestEmail()
@@ -132,3 +158,133 @@ func TestAttachments(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// TestSendEmail checks that the provider's response is reported in the result
+func TestSendEmail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok"},"data":{"message":{"id":1234}}}`))
+	}))
+	defer server.Close()
+
+	defer func(orig string) { apiURL = orig }(apiURL)
+	apiURL = server.URL
+
+	result, err := b.SendEmail(context.Background(), testutils.TestEmail())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.MessageID != "1234" || result.StatusCode != 200 {
+		t.FailNow()
+	}
+}
+
+// TestRecipientOverride checks that a recipient override on the context is sent to postageapp
+func TestRecipientOverride(t *testing.T) {
+	var payload postageAppWrapper
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Write([]byte(`{"response":{"status":"ok"}}`))
+	}))
+	defer server.Close()
+
+	defer func(orig string) { apiURL = orig }(apiURL)
+	apiURL = server.URL
+
+	ctx := backends.WithRecipientOverride(context.Background(), "qa@example.com")
+	if _, err := b.SendEmail(ctx, testutils.TestEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.Arguments.RecipientOverride != "qa@example.com" {
+		t.FailNow()
+	}
+}
+
+// TestMergeTags checks that merge tags are translated to postageapp's syntax
+func TestMergeTags(t *testing.T) {
+	b := NewBackend(apiKey, MergeTags(mergetag.Mandrill)).(*postageAppBackend)
+
+	e := testutils.TestEmail()
+	e.TextBody = "Hello *|name|*"
+
+	wrapper, err := b.wrapperForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wrapper.Arguments.Content["text/plain"] != "Hello {{name}}" {
+		t.FailNow()
+	}
+}
+
+// TestCcBcc checks that cc and bcc recipients get their own copy, and that cc (like a
+// return path) is refused in strict mode
+func TestCcBcc(t *testing.T) {
+	e := testutils.TestEmail()
+	recipients := e.To
+	e.To, e.Cc, e.Bcc = recipients[:1], recipients[1:3], recipients[3:4]
+
+	wrapper, err := b.wrapperForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wrapper.Arguments.Recipients) != 4 {
+		t.FailNow()
+	}
+
+	if wrapper.Arguments.Recipients[recipients[3].Email.String()]["name"] != "Garland" {
+		t.FailNow()
+	}
+
+	strict := NewBackend(apiKey, Strict()).(*postageAppBackend)
+
+	_, err = strict.wrapperForEmail(e)
+	if unsupported, ok := err.(*backends.UnsupportedError); !ok || unsupported.Feature != "cc" {
+		t.Fatal(err)
+	}
+
+	e.Cc = nil
+	if _, err := strict.wrapperForEmail(e); err != nil {
+		t.Fatal(err)
+	}
+
+	e.ReturnPath = "bounces@austen.name"
+	if _, err := strict.wrapperForEmail(e); err == nil {
+		t.FailNow()
+	}
+}
+
+// TestThreading checks that the threading headers are sent and the Message-ID reported back
+func TestThreading(t *testing.T) {
+	e := testutils.TestEmail()
+	e.SetInReplyTo("second@austen.name", []string{"first@austen.name"})
+
+	wrapper, err := b.wrapperForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := wrapper.Arguments.Headers
+	if !strings.HasSuffix(headers["Message-ID"], "@austen.name>") || headers["In-Reply-To"] != "<second@austen.name>" ||
+		headers["References"] != "<first@austen.name> <second@austen.name>" {
+		t.Fatal(headers)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"status":"ok"},"data":{"message":{"id":1234}}}`))
+	}))
+	defer server.Close()
+
+	defer func(orig string) { apiURL = orig }(apiURL)
+	apiURL = server.URL
+
+	e.MessageID = "reply@austen.name"
+
+	result, err := b.SendEmail(context.Background(), e)
+	if err != nil || result.HeaderMessageID != e.MessageID {
+		t.Fatal(result, err)
+	}
+}
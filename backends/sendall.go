@@ -0,0 +1,84 @@
+package backends
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"sync"
+)
+
+// SendAll sends the emails through b, with at most concurrency of them in flight, and
+// combines their results into one with an entry for every recipient.  If any recipient
+// failed, a *PartialError is returned along with the result.
+//
+// It's for middleware that splits an email up into several sends.
+func SendAll(ctx context.Context, b Backend, emails []*ego.Email, concurrency int) (*Result, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*Result, len(emails))
+	errs := make([]error, len(emails))
+
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+
+	for i, e := range emails {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, e *ego.Email) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i], errs[i] = b.SendEmail(ctx, e)
+		}(i, e)
+	}
+
+	wg.Wait()
+
+	combined := &Result{}
+	partial := &PartialError{}
+
+	for i, e := range emails {
+		result := results[i]
+		if result == nil {
+			result = &Result{}
+		}
+
+		combined.Suppressed = append(combined.Suppressed, result.Suppressed...)
+
+		recipResults := result.Recipients
+
+		// the backend didn't report on each recipient, so they all share its outcome
+		if len(recipResults) == 0 {
+			for _, recipients := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+				for _, recip := range recipients {
+					recipResults = append(recipResults, &RecipientResult{
+						Recipient: recip.Email,
+						MessageID: result.MessageID,
+						Err:       errs[i],
+					})
+				}
+			}
+		}
+
+		for _, recipResult := range recipResults {
+			partial.Total++
+			if recipResult.Err != nil {
+				partial.Failed++
+			}
+		}
+
+		combined.Recipients = append(combined.Recipients, recipResults...)
+
+		if errs[i] != nil {
+			partial.Errs = append(partial.Errs, errs[i])
+		}
+	}
+
+	if len(partial.Errs) > 0 {
+		return combined, partial
+	}
+
+	return combined, nil
+}
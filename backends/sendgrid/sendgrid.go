@@ -6,61 +6,134 @@
 package sendgrid
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jarcoal/ego"
 	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/mergetag"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
-const apiURL = "https://sendgrid.com/api/mail.send.json"
+var apiURL = "https://sendgrid.com/api/mail.send.json"
 
-var _ backends.Backend = (*sendGridBackend)(nil)
+// sendgrid rejects requests with more recipients than this
+const maxRecipients = 1000
+
+var _ backends.RecipientLimiter = (*sendGridBackend)(nil)
 
 // NewBackend creates a new SendGrid backend that is bound to the given credentials.
-func NewBackend(username, password string) backends.Backend {
-	return &sendGridBackend{username, password}
+func NewBackend(username, password string, opts ...Option) backends.Backend {
+	s := &sendGridBackend{username: username, password: password, mergeTags: mergetag.SendGrid}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Option configures the backend.
+type Option func(*sendGridBackend)
+
+// MergeTags has the backend translate merge tags in the subject and bodies of emails
+// from the given syntax to sendgrid's.
+func MergeTags(from mergetag.Syntax) Option {
+	return func(s *sendGridBackend) {
+		s.mergeTags = from
+	}
+}
+
+// Strict has the backend refuse emails that sendgrid can't express, with a
+// *backends.UnsupportedError: those with a ReturnPath, as sendgrid always handles bounces
+// itself.  Otherwise the ReturnPath is ignored.
+func Strict() Option {
+	return func(s *sendGridBackend) {
+		s.strict = true
+	}
 }
 
 type sendGridBackend struct {
 	username, password string
+	strict             bool
+
+	// syntax of the merge tags in the emails we're given
+	mergeTags mergetag.Syntax
+}
+
+// MaxRecipients reports the most recipients sendgrid accepts in a single send.
+func (s *sendGridBackend) MaxRecipients() int {
+	return maxRecipients
 }
 
-func (s *sendGridBackend) SendEmail(e *ego.Email) error {
+func (s *sendGridBackend) Name() string {
+	return "sendgrid"
+}
+
+func (s *sendGridBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	// decide on the Message-ID here, so that it can be reported back
+	if e.MessageID == "" {
+		e = e.Clone()
+		e.MessageID = ego.NewMessageID(e.From)
+	}
+
 	// get the parameters we're going to be posting to sendgrid
 	params, err := s.paramsForEmail(e)
 	if err != nil {
-		return err
+		return nil, backends.NotSent(err)
 	}
 
 	// perform the request
-	resp, err := http.PostForm(apiURL, params)
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, strings.NewReader(params.Encode()))
 	if err != nil {
-		return err
+		return nil, backends.NotSent(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	// sendgrid doesn't report a message id, only the status of the request
+	result := &backends.Result{StatusCode: resp.StatusCode, HeaderMessageID: e.MessageID}
+
 	if resp.StatusCode != 200 {
-		return errors.New("received bad status code from sendgrid: " + resp.Status)
+		return result, errors.New("received bad status code from sendgrid: " + resp.Status)
 	}
 
-	return nil
+	return result, nil
 }
 
 func (s *sendGridBackend) paramsForEmail(e *ego.Email) (url.Values, error) {
+	if e.ReturnPath != "" && s.strict {
+		return nil, &backends.UnsupportedError{Backend: s.Name(), Feature: "return path"}
+	}
+
 	params := url.Values{}
 
 	// apply our credentials
 	params.Set("api_user", s.username)
 	params.Set("api_key", s.password)
 
+	// merge tags are filled in on our end when everyone shares the same values, and by
+	// sendgrid from the substitutions otherwise
+	subject := mergetag.Translate(e.Subject, s.mergeTags, mergetag.SendGrid)
+	text := mergetag.Translate(e.TextBody, s.mergeTags, mergetag.SendGrid)
+	html := mergetag.Translate(e.HTMLBody, s.mergeTags, mergetag.SendGrid)
+
+	values, personalized := substitutionValues(e, subject+"\n"+text+"\n"+html)
+	if !personalized {
+		subject, text, html = substitute(subject, values), substitute(text, values), substitute(html, values)
+	}
+
 	// general information
-	params.Set("subject", e.Subject)
-	params.Set("text", e.TextBody)
-	params.Set("html", e.HTMLBody)
+	params.Set("subject", subject)
+	params.Set("text", text)
+	params.Set("html", html)
 	params.Set("from", e.From.Address)
 	params.Set("fromname", e.From.Name)
 
@@ -73,33 +146,54 @@ func (s *sendGridBackend) paramsForEmail(e *ego.Email) (url.Values, error) {
 		params.Add("toname[]", to.Email.Name)
 	}
 
-	for _, bcc := range e.Bcc {
-		params.Add("bcc[]", bcc.Email.Address)
+	// when the email is personalized, cc and bcc recipients are sent their own copies
+	// along with the to recipients instead
+	if !personalized {
+		for _, cc := range e.Cc {
+			params.Add("cc[]", cc.Email.Address)
+			params.Add("ccname[]", cc.Email.Name)
+		}
+
+		for _, bcc := range e.Bcc {
+			params.Add("bcc[]", bcc.Email.Address)
+			params.Add("bccname[]", bcc.Email.Name)
+		}
 	}
 
-	// add any headers
-	if len(e.Headers) > 0 {
-		headerMap := make(map[string]string)
+	// add any headers, along with the ones that thread the email and its unsubscribe links
+	_, headerMap := e.ThreadingHeaders()
+	for header, value := range e.ListHeaders() {
+		headerMap[header] = value
+	}
 
-		for header := range e.Headers {
-			headerMap[header] = e.Headers.Get(header)
-		}
+	for header := range e.Headers {
+		headerMap[header] = e.Headers.Get(header)
+	}
 
-		headerMapEncoded, err := json.Marshal(headerMap)
-		if err != nil {
-			return nil, fmt.Errorf("failed to encode headers: %s", err)
-		}
+	headerMapEncoded, err := json.Marshal(headerMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode headers: %s", err)
+	}
 
-		params.Set("headers", string(headerMapEncoded))
+	params.Set("headers", string(headerMapEncoded))
+
+	// add any attachments, including the invitation for an event
+	attachments, err := e.AllAttachments()
+	if err != nil {
+		return nil, err
 	}
 
-	// add any attachments
-	for _, attachment := range e.Attachments {
+	for _, attachment := range attachments {
 		attachmentBytes, err := ioutil.ReadAll(attachment.Data)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read attachment %s", attachment.Name)
 		}
 		params.Set(fmt.Sprintf("files[%v]", attachment.Name), string(attachmentBytes))
+
+		// inline attachments are tied to their file by content id
+		if attachment.Inline() {
+			params.Set(fmt.Sprintf("content[%v]", attachment.Name), attachment.ContentID)
+		}
 	}
 
 	// these are misc parameters that get fed into the smtp api
@@ -110,6 +204,29 @@ func (s *sendGridBackend) paramsForEmail(e *ego.Email) (url.Values, error) {
 		xSMTPApiParams["category"] = e.Tags
 	}
 
+	// each recipient's values for the merge tags, in the order of the recipients
+	if personalized {
+		to := []string{}
+		sub := make(map[string][]string, len(values))
+
+		for _, recipients := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+			for _, recip := range recipients {
+				to = append(to, recip.Email.String())
+
+				for name, value := range values {
+					if recipValue, ok := recip.TemplateContext[name]; ok {
+						value = recipValue
+					}
+					tag := mergetag.Format(mergetag.SendGrid, name)
+					sub[tag] = append(sub[tag], value)
+				}
+			}
+		}
+
+		xSMTPApiParams["to"] = to
+		xSMTPApiParams["sub"] = sub
+	}
+
 	// template filter properties
 	if e.TemplateID != "" {
 		// there should be only one template context variable named 'body'
@@ -143,3 +260,58 @@ func (s *sendGridBackend) paramsForEmail(e *ego.Email) (url.Values, error) {
 
 	return params, nil
 }
+
+// substitutionValues returns the values of the merge tags in content: the email's
+// TemplateContext, with its recipient's own on top when it has just one.  It also reports
+// whether several recipients need values of their own for the tags, which sendgrid can
+// only substitute by sending each of them a copy addressed to them alone, as the fanout
+// middleware would.  Emails sent with a sendgrid template take their context as the
+// template's body instead.
+func substitutionValues(e *ego.Email, content string) (map[string]string, bool) {
+	if e.TemplateID != "" {
+		return nil, false
+	}
+
+	used := make(map[string]bool)
+	for _, name := range mergetag.Names(content, mergetag.SendGrid) {
+		used[name] = true
+	}
+
+	single := len(e.To)+len(e.Cc)+len(e.Bcc) == 1
+	values := make(map[string]string, len(used))
+	personalized := false
+
+	for k, v := range e.TemplateContext {
+		if used[k] {
+			values[k] = v
+		}
+	}
+
+	for _, recipients := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+		for _, recip := range recipients {
+			for k, v := range recip.TemplateContext {
+				if !used[k] {
+					continue
+				}
+
+				if single {
+					values[k] = v
+				} else {
+					// recipients without a value of their own get the email's, or nothing
+					values[k] = e.TemplateContext[k]
+					personalized = true
+				}
+			}
+		}
+	}
+
+	return values, personalized
+}
+
+// substitute replaces the merge tags in text with their values, as sendgrid would.
+func substitute(text string, values map[string]string) string {
+	return mergetag.Replace(text, mergetag.SendGrid, func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	})
+}
@@ -1,16 +1,21 @@
 package sendgrid
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/jarcoal/ego/mergetag"
 	"github.com/jarcoal/ego/testutils"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 )
 
-var b = sendGridBackend{"test-username", "test-password"}
+var b = sendGridBackend{username: "test-username", password: "test-password", mergeTags: mergetag.SendGrid}
 
 // TestGeneral tests that the basic email params like subject and body are populated correctly.
 func TestGeneral(t *testing.T) {
@@ -178,6 +183,26 @@ func TestAttachments(t *testing.T) {
 	}
 }
 
+// TestInlineAttachments checks that inline attachments are given their content ids
+func TestInlineAttachments(t *testing.T) {
+	e := testutils.TestEmail()
+	e.AddAttachment("report.pdf", "application/pdf", strings.NewReader("%PDF"))
+	e.AddInlineAttachment("logo", "logo.png", "image/png", strings.NewReader("png"))
+
+	params, err := b.paramsForEmail(e)
+	if err != nil {
+		t.FailNow()
+	}
+
+	if params.Get("files[logo.png]") != "png" || params.Get("content[logo.png]") != "logo" {
+		t.FailNow()
+	}
+
+	if _, ok := params["content[report.pdf]"]; ok {
+		t.FailNow()
+	}
+}
+
 func decodeXSMTPAPI(t *testing.T, params url.Values) map[string]interface{} {
 	xSMTPAPI := make(map[string]interface{})
 
@@ -187,3 +212,178 @@ func decodeXSMTPAPI(t *testing.T, params url.Values) map[string]interface{} {
 
 	return xSMTPAPI
 }
+
+// TestSendEmail checks that the provider's response is reported in the result
+func TestSendEmail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	defer func(orig string) { apiURL = orig }(apiURL)
+	apiURL = server.URL
+
+	result, err := b.SendEmail(context.Background(), testutils.TestEmail())
+	if err == nil {
+		t.FailNow()
+	}
+
+	if result.StatusCode != http.StatusBadRequest {
+		t.FailNow()
+	}
+}
+
+// TestMergeTags checks that merge tags are translated to sendgrid's syntax
+func TestMergeTags(t *testing.T) {
+	b := NewBackend("test-username", "test-password", MergeTags(mergetag.PostageApp)).(*sendGridBackend)
+
+	e := testutils.TestEmail()
+	e.HTMLBody = "<p>Hello {{name}}</p>"
+
+	params, err := b.paramsForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.Get("html") != "<p>Hello -name-</p>" {
+		t.FailNow()
+	}
+}
+
+// TestCcBcc checks that cc and bcc recipients are sent along with their names
+func TestCcBcc(t *testing.T) {
+	e := testutils.TestEmail()
+	recipients := e.To
+	e.To, e.Cc, e.Bcc = recipients[:1], recipients[1:3], recipients[3:4]
+
+	params, err := b.paramsForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(params["to[]"]) != 1 || len(params["cc[]"]) != 2 || len(params["bcc[]"]) != 1 {
+		t.Fatal(params)
+	}
+
+	if params["cc[]"][1] != recipients[2].Email.Address || params["ccname[]"][1] != recipients[2].Email.Name {
+		t.Fatal(params)
+	}
+
+	if params.Get("bcc[]") != recipients[3].Email.Address || params.Get("bccname[]") != recipients[3].Email.Name {
+		t.Fatal(params)
+	}
+}
+
+// TestThreading checks that the threading headers are sent and the Message-ID reported back
+func TestThreading(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := map[string]string{}
+		json.Unmarshal([]byte(r.FormValue("headers")), &headers)
+
+		if headers["Message-ID"] != "<reply@austen.name>" || headers["In-Reply-To"] != "<first@austen.name>" {
+			t.Error(headers)
+		}
+	}))
+	defer server.Close()
+
+	defer func(orig string) { apiURL = orig }(apiURL)
+	apiURL = server.URL
+
+	e := testutils.TestEmail()
+	e.MessageID = "reply@austen.name"
+	e.SetInReplyTo("first@austen.name", nil)
+
+	result, err := b.SendEmail(context.Background(), e)
+	if err != nil || result.HeaderMessageID != e.MessageID {
+		t.Fatal(result, err)
+	}
+}
+
+// TestStrict checks that a return path is refused in strict mode only
+func TestStrict(t *testing.T) {
+	e := testutils.TestEmail()
+	e.ReturnPath = "bounces@austen.name"
+
+	if _, err := b.paramsForEmail(e); err != nil {
+		t.Fatal(err)
+	}
+
+	strict := NewBackend("test-username", "test-password", Strict()).(*sendGridBackend)
+	if _, err := strict.paramsForEmail(e); err == nil {
+		t.FailNow()
+	}
+}
+
+// TestSubstitutions checks that merge tags are filled in from the template contexts
+func TestSubstitutions(t *testing.T) {
+	e := testutils.TestEmail()
+	e.To = e.To[:1]
+	e.Subject = "Hello -name-"
+	e.TextBody = "Welcome to -site-, -name-. Keep -unknown-"
+	e.TemplateContext["site"] = "ego"
+
+	// a single recipient's values are filled in on our end
+	params, err := b.paramsForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.Get("subject") != "Hello Sandy" || params.Get("text") != "Welcome to ego, Sandy. Keep -unknown-" {
+		t.Fatal(params)
+	}
+
+	// several recipients with their own values are each sent a copy with substitutions
+	recipients := testutils.TestRecipients()
+	e.To, e.Cc = recipients[:2], recipients[2:3]
+	delete(e.To[1].TemplateContext, "name")
+
+	params, err = b.paramsForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.Get("subject") != "Hello -name-" || len(params["cc[]"]) != 0 {
+		t.Fatal(params)
+	}
+
+	xSMTPAPI := decodeXSMTPAPI(t, params)
+	to := xSMTPAPI["to"].([]interface{})
+	sub := xSMTPAPI["sub"].(map[string]interface{})
+
+	if len(to) != 3 || to[2] != recipients[2].Email.String() {
+		t.Fatal(to)
+	}
+
+	if fmt.Sprint(sub["-name-"]) != "[Sandy  Abigale]" || fmt.Sprint(sub["-site-"]) != "[ego ego ego]" {
+		t.Fatal(sub)
+	}
+
+	// hyphenated words aren't merge tags
+	e.To, e.Cc = e.To[:1], nil
+	e.To[0].TemplateContext = map[string]string{"known": "X"}
+	e.Subject = "A well-known-brand"
+	e.TextBody = "-known-"
+
+	params, err = b.paramsForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.Get("subject") != "A well-known-brand" || params.Get("text") != "X" {
+		t.Fatal(params)
+	}
+
+	// nor do they make several recipients need their own copy
+	e.To = recipients[:2]
+	e.To[0].TemplateContext = map[string]string{"known": "X"}
+	e.TextBody = "Hi"
+
+	params, err = b.paramsForEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := decodeXSMTPAPI(t, params)["sub"]; ok || len(params["to[]"]) != 2 {
+		t.Fatal(params)
+	}
+}
@@ -0,0 +1,120 @@
+// Batching middleware
+//
+// Splits emails with more recipients than the provider accepts in one call into several
+// sends, and reports the outcome of each recipient.
+
+package batch
+
+import (
+	"context"
+	"fmt"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"strconv"
+)
+
+// DefaultConcurrency is how many batches are sent at once when Config.Concurrency isn't set.
+const DefaultConcurrency = 4
+
+// Config describes how emails are batched.
+type Config struct {
+	// Most recipients per send.  If zero, the backend's own limit is used (see
+	// backends.RecipientLimiter), and backends without one aren't batched.
+	MaxRecipients int
+
+	// Maximum number of batches in flight at once for a single email.
+	Concurrency int
+}
+
+// NewMiddleware returns a middleware that splits the To recipients of large emails into
+// batches before they reach the wrapped backend.
+//
+// Cc and Bcc recipients are only sent the first batch, so they receive the email once;
+// the first batch has correspondingly fewer To recipients, but always at least one, as
+// some providers refuse sends without any.  Every batch keeps the email's
+// VisibleRecipients setting, though recipients can then only see the others in their batch.
+//
+// The result lists the outcome for each recipient, and a *backends.PartialError is
+// returned if any of them failed.
+func NewMiddleware(c Config) backends.Middleware {
+	if c.Concurrency <= 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+
+	return func(next backends.Backend) backends.Backend {
+		max := c.MaxRecipients
+
+		var limiter backends.RecipientLimiter
+		if max <= 0 && backends.As(next, &limiter) {
+			max = limiter.MaxRecipients()
+		}
+
+		if max <= 0 {
+			return next
+		}
+
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if len(e.To)+len(e.Cc)+len(e.Bcc) <= max {
+				return next.SendEmail(ctx, e)
+			}
+
+			emails, err := Split(e, max)
+			if err != nil {
+				return nil, err
+			}
+
+			return backends.SendAll(ctx, next, emails, c.Concurrency)
+		})
+	}
+}
+
+// Split breaks the email up into copies with at most max recipients each, as described
+// on NewMiddleware.  The attachments are read into memory so that every copy can send them.
+// Each copy's IdempotencyKey is the original's followed by "/" and the copy's index, so that
+// a middleware deduplicating sends treats the copies apart but still recognizes a retry.
+func Split(e *ego.Email, max int) ([]*ego.Email, error) {
+	// the cc and bcc recipients need room for a to recipient alongside them
+	room := max
+	if len(e.To) > 0 {
+		room--
+	}
+	if len(e.Cc)+len(e.Bcc) > room {
+		return nil, backends.NotSent(fmt.Errorf("email has %d cc and bcc recipients, more than the %d allowed in a send",
+			len(e.Cc)+len(e.Bcc), room))
+	}
+
+	e = e.Clone()
+	if err := e.BufferAttachments(); err != nil {
+		return nil, backends.NotSent(err)
+	}
+
+	emails := []*ego.Email{}
+	to := e.To
+
+	// the first batch carries the cc and bcc recipients
+	size := max - len(e.Cc) - len(e.Bcc)
+
+	for len(to) > 0 || len(emails) == 0 {
+		if size > len(to) {
+			size = len(to)
+		}
+
+		chunk := e.Clone()
+		chunk.To = to[:size:size]
+		to = to[size:]
+
+		if len(emails) > 0 {
+			chunk.Cc = []*ego.Recipient{}
+			chunk.Bcc = []*ego.Recipient{}
+		}
+
+		if e.IdempotencyKey != "" {
+			chunk.IdempotencyKey = e.IdempotencyKey + "/" + strconv.Itoa(len(emails))
+		}
+
+		emails = append(emails, chunk)
+		size = max
+	}
+
+	return emails, nil
+}
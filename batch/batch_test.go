@@ -0,0 +1,142 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type captureBackend struct {
+	mu   sync.Mutex
+	sent []*ego.Email
+	fail string
+	max  int
+}
+
+func (c *captureBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent = append(c.sent, e)
+
+	for _, recip := range e.To {
+		if recip.Email.Address == c.fail {
+			return &backends.Result{StatusCode: 500}, errors.New("boom")
+		}
+	}
+
+	return &backends.Result{MessageID: "batch"}, nil
+}
+
+func (c *captureBackend) MaxRecipients() int {
+	return c.max
+}
+
+// TestSplit checks how recipients are spread over the batches.
+func TestSplit(t *testing.T) {
+	e := testutils.TestEmail()
+	e.Cc = e.To[:1]
+	e.Bcc = e.To[1:2]
+	e.To = e.To[2:]
+	e.VisibleRecipients = true
+	e.IdempotencyKey = "newsletter-42"
+
+	emails, err := Split(e, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 6 to recipients: 1 in the first batch alongside the cc and bcc, then 3 and 2
+	if len(emails) != 3 {
+		t.FailNow()
+	}
+
+	for i, expected := range []int{1, 3, 2} {
+		if len(emails[i].To) != expected || !emails[i].VisibleRecipients {
+			t.FailNow()
+		}
+
+		if emails[i].IdempotencyKey != "newsletter-42/"+strconv.Itoa(i) {
+			t.Fatal(emails[i].IdempotencyKey)
+		}
+	}
+
+	if len(emails[0].Cc) != 1 || len(emails[0].Bcc) != 1 {
+		t.FailNow()
+	}
+
+	if len(emails[1].Cc)+len(emails[1].Bcc)+len(emails[2].Cc)+len(emails[2].Bcc) != 0 {
+		t.FailNow()
+	}
+
+	// too many cc recipients to fit in a batch
+	if _, err := Split(e, 1); err == nil {
+		t.FailNow()
+	}
+
+	// or to fit alongside a to recipient
+	if _, err := Split(e, 2); err == nil {
+		t.FailNow()
+	}
+
+	// which isn't needed without any
+	e.To = nil
+	if emails, err := Split(e, 2); err != nil || len(emails) != 1 {
+		t.Fatal(emails, err)
+	}
+}
+
+// TestMiddleware checks that the backend's declared limit is used and results are combined.
+func TestMiddleware(t *testing.T) {
+	e := testutils.TestEmail()
+
+	capture := &captureBackend{max: 3, fail: e.To[7].Email.Address}
+	b := backends.Chain(capture, NewMiddleware(Config{}))
+
+	result, err := b.SendEmail(context.Background(), e)
+
+	var partial *backends.PartialError
+	if !errors.As(err, &partial) {
+		t.Fatal(err)
+	}
+
+	if len(capture.sent) != 3 {
+		t.FailNow()
+	}
+
+	// the last batch of 2 failed
+	if partial.Failed != 2 || partial.Total != len(e.To) {
+		t.FailNow()
+	}
+
+	if len(result.Recipients) != len(e.To) {
+		t.FailNow()
+	}
+
+	for i, recipResult := range result.Recipients {
+		if recipResult.Recipient != e.To[i].Email {
+			t.FailNow()
+		}
+
+		if (i >= 6) != (recipResult.Err != nil) {
+			t.FailNow()
+		}
+	}
+}
+
+// TestPassthrough checks that backends without a limit aren't batched.
+func TestPassthrough(t *testing.T) {
+	capture := &captureBackend{}
+
+	b := backends.Chain(backends.BackendFunc(capture.SendEmail), NewMiddleware(Config{}))
+	b.SendEmail(context.Background(), testutils.TestEmail())
+
+	if len(capture.sent) != 1 {
+		t.FailNow()
+	}
+}
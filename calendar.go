@@ -0,0 +1,280 @@
+package ego
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Calendar methods, which say what an invitation is for.
+const (
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+	MethodReply   = "REPLY"
+)
+
+// Attendee participation statuses.
+const (
+	StatusNeedsAction = "NEEDS-ACTION"
+	StatusAccepted    = "ACCEPTED"
+	StatusDeclined    = "DECLINED"
+	StatusTentative   = "TENTATIVE"
+)
+
+// Event is a meeting that an email invites its recipients to, or cancels, or replies to.
+// It's sent as an iCalendar (RFC 5545) invitation that calendar clients can act on.
+type Event struct {
+	// UID identifies the event across every invitation about it.  Sequence must be
+	// increased whenever an invitation changes the event, including to cancel it.
+	UID      string
+	Sequence int
+
+	// Method is MethodRequest (the default when empty), MethodCancel or MethodReply.
+	Method string
+
+	Summary, Description, Location string
+
+	// Start and End are shown in their time's location, which should be one from the
+	// IANA database (as returned by time.LoadLocation) or UTC.
+	Start, End time.Time
+
+	Organizer *mail.Address
+	Attendees []*Attendee
+}
+
+// Attendee is someone invited to an event.
+type Attendee struct {
+	Address  *mail.Address
+	Optional bool
+
+	// Status is the attendee's response, StatusNeedsAction when empty.  Replies set it
+	// for the attendee replying.
+	Status string
+}
+
+func (ev *Event) method() string {
+	if ev.Method == "" {
+		return MethodRequest
+	}
+	return ev.Method
+}
+
+// ContentType returns the media type of the event's invitation.
+func (ev *Event) ContentType() string {
+	return "text/calendar; charset=utf-8; method=" + ev.method()
+}
+
+// Attachment returns the event's invitation as an attachment named invite.ics.
+func (ev *Event) Attachment() (*Attachment, error) {
+	ics, err := ev.ICS()
+	if err != nil {
+		return nil, err
+	}
+	return ev.NewAttachment(ics), nil
+}
+
+// NewAttachment returns an invitation already rendered by ICS as an attachment named
+// invite.ics, so that it can be attached as well as shown without rendering it twice.
+// Each rendering is stamped with the time, so two of them don't match.
+func (ev *Event) NewAttachment(ics []byte) *Attachment {
+	return &Attachment{Name: "invite.ics", Mimetype: ev.ContentType(), Data: bytes.NewReader(ics)}
+}
+
+// ICS renders the event as an iCalendar object.
+func (ev *Event) ICS() ([]byte, error) {
+	if ev.UID == "" {
+		return nil, errors.New("calendar event needs a UID")
+	}
+	if ev.Organizer == nil {
+		return nil, errors.New("calendar event needs an organizer")
+	}
+	if ev.Start.IsZero() || ev.End.Before(ev.Start) {
+		return nil, errors.New("calendar event needs a start, and an end that isn't before it")
+	}
+	for i, a := range ev.Attendees {
+		if a == nil || a.Address == nil {
+			return nil, fmt.Errorf("calendar event attendee %d needs an address", i+1)
+		}
+	}
+
+	b := &icsBuilder{}
+	b.line("BEGIN:VCALENDAR")
+	b.line("PRODID:-//jarcoal//ego//EN")
+	b.line("VERSION:2.0")
+	b.line("CALSCALE:GREGORIAN")
+	b.line("METHOD:" + ev.method())
+	b.timezones(ev.Start, ev.End)
+
+	b.line("BEGIN:VEVENT")
+	b.line("UID:" + escapeText(ev.UID))
+	b.line(fmt.Sprintf("SEQUENCE:%d", ev.Sequence))
+	b.line("DTSTAMP:" + time.Now().UTC().Format(icsUTC))
+	b.line(formatTime("DTSTART", ev.Start))
+	b.line(formatTime("DTEND", ev.End))
+
+	if ev.Summary != "" {
+		b.line("SUMMARY:" + escapeText(ev.Summary))
+	}
+	if ev.Description != "" {
+		b.line("DESCRIPTION:" + escapeText(ev.Description))
+	}
+	if ev.Location != "" {
+		b.line("LOCATION:" + escapeText(ev.Location))
+	}
+
+	b.line("ORGANIZER" + commonName(ev.Organizer) + ":mailto:" + ev.Organizer.Address)
+
+	for _, a := range ev.Attendees {
+		role := "REQ-PARTICIPANT"
+		if a.Optional {
+			role = "OPT-PARTICIPANT"
+		}
+		status := a.Status
+		if status == "" {
+			status = StatusNeedsAction
+		}
+
+		line := "ATTENDEE" + commonName(a.Address) + ";ROLE=" + role + ";PARTSTAT=" + status
+		if ev.method() == MethodRequest {
+			line += ";RSVP=TRUE"
+		}
+		b.line(line + ":mailto:" + a.Address.Address)
+	}
+
+	if ev.method() == MethodCancel {
+		b.line("STATUS:CANCELLED")
+	} else {
+		b.line("STATUS:CONFIRMED")
+	}
+
+	b.line("END:VEVENT")
+	b.line("END:VCALENDAR")
+
+	return b.Bytes(), nil
+}
+
+const (
+	icsLocal = "20060102T150405"
+	icsUTC   = "20060102T150405Z"
+)
+
+// formatTime formats a date-time property in its time's zone, which is described by a
+// VTIMEZONE in the same object.
+func formatTime(property string, t time.Time) string {
+	if name := zoneName(t); name != "" {
+		return property + ";TZID=" + name + ":" + t.Format(icsLocal)
+	}
+	return property + ":" + t.UTC().Format(icsUTC)
+}
+
+// zoneName returns the TZID for a time, or "" if it's to be given in UTC.
+func zoneName(t time.Time) string {
+	name := t.Location().String()
+	if name == "UTC" || name == "Local" || name == "" {
+		return ""
+	}
+	return name
+}
+
+type icsBuilder struct {
+	bytes.Buffer
+}
+
+// line writes a content line, folded at 75 octets without splitting characters.
+func (b *icsBuilder) line(s string) {
+	for limit := 75; len(s) > limit; limit = 74 {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+	}
+	b.WriteString(s + "\r\n")
+}
+
+// timezones writes a VTIMEZONE for each zone the times are in, with an observance for
+// each of the offsets in effect at those times.
+func (b *icsBuilder) timezones(times ...time.Time) {
+	observances := map[string][]time.Time{}
+	names := []string{}
+
+	for _, t := range times {
+		name := zoneName(t)
+		if name == "" {
+			continue
+		}
+		if _, ok := observances[name]; !ok {
+			names = append(names, name)
+		}
+
+		start, _ := t.ZoneBounds()
+		seen := false
+		for _, o := range observances[name] {
+			oStart, _ := o.ZoneBounds()
+			seen = seen || oStart.Equal(start)
+		}
+		if !seen {
+			observances[name] = append(observances[name], t)
+		}
+	}
+
+	for _, name := range names {
+		b.line("BEGIN:VTIMEZONE")
+		b.line("TZID:" + name)
+
+		for _, t := range observances[name] {
+			abbreviation, offset := t.Zone()
+			start, _ := t.ZoneBounds()
+
+			// the offset before the observance started, which is the same for zones
+			// that never change
+			from := offset
+			if start.IsZero() {
+				start = time.Date(1970, 1, 1, 0, 0, 0, 0, t.Location())
+			} else {
+				_, from = start.Add(-time.Second).Zone()
+			}
+
+			kind := "STANDARD"
+			if t.IsDST() {
+				kind = "DAYLIGHT"
+			}
+
+			b.line("BEGIN:" + kind)
+			b.line("DTSTART:" + start.UTC().Add(time.Duration(from)*time.Second).Format(icsLocal))
+			b.line("TZOFFSETFROM:" + formatOffset(from))
+			b.line("TZOFFSETTO:" + formatOffset(offset))
+			b.line("TZNAME:" + escapeText(abbreviation))
+			b.line("END:" + kind)
+		}
+
+		b.line("END:VTIMEZONE")
+	}
+}
+
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// commonName returns the CN parameter for an address with a name.
+func commonName(a *mail.Address) string {
+	if a.Name == "" {
+		return ""
+	}
+	return `;CN="` + strings.NewReplacer(`"`, "", "\r", "", "\n", " ").Replace(a.Name) + `"`
+}
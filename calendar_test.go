@@ -0,0 +1,145 @@
+package ego
+
+import (
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func testEvent(t *testing.T) *Event {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Event{
+		UID:         "launch-2026@example.com",
+		Sequence:    1,
+		Summary:     "Launch review; all hands",
+		Description: "Agenda:\n1. Demo\n2. Questions",
+		Location:    "Room 1, Floor 2",
+		Start:       time.Date(2026, 10, 31, 9, 0, 0, 0, newYork),
+		End:         time.Date(2026, 11, 2, 17, 30, 0, 0, newYork),
+		Organizer:   &mail.Address{Name: "Jade Block", Address: "jade@example.com"},
+		Attendees: []*Attendee{
+			{Address: &mail.Address{Name: "Sandy", Address: "sandy@example.com"}},
+			{Address: &mail.Address{Address: "rocio@example.com"}, Optional: true},
+		},
+	}
+}
+
+// TestEventICS checks the iCalendar rendering of an event.
+func TestEventICS(t *testing.T) {
+	ics, err := testEvent(t).ICS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := string(ics)
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\nPRODID:-//jarcoal//ego//EN\r\nVERSION:2.0\r\n",
+		"METHOD:REQUEST\r\n",
+		"UID:launch-2026@example.com\r\nSEQUENCE:1\r\n",
+		"DTSTART;TZID=America/New_York:20261031T090000\r\n",
+		"DTEND;TZID=America/New_York:20261102T173000\r\n",
+		"SUMMARY:Launch review\\; all hands\r\n",
+		"DESCRIPTION:Agenda:\\n1. Demo\\n2. Questions\r\n",
+		"LOCATION:Room 1\\, Floor 2\r\n",
+		`ORGANIZER;CN="Jade Block":mailto:jade@example.com` + "\r\n",
+		`ATTENDEE;CN="Sandy";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:ma` + "\r\n ilto:sandy@example.com\r\n",
+		"ATTENDEE;ROLE=OPT-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:rocio@\r\n example.com\r\n",
+		"STATUS:CONFIRMED\r\n",
+
+		// the event spans the end of daylight saving time
+		"BEGIN:VTIMEZONE\r\nTZID:America/New_York\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20260308T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\nTZNAME:EDT\r\nEND:DAYLIGHT\r\n",
+		"BEGIN:STANDARD\r\nDTSTART:20261101T020000\r\nTZOFFSETFROM:-0400\r\nTZOFFSETTO:-0500\r\nTZNAME:EST\r\nEND:STANDARD\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("missing %q in:\n%s", expected, text)
+		}
+	}
+
+	for _, line := range strings.Split(text, "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("unfolded line %q", line)
+		}
+	}
+}
+
+// TestEventCancel checks the rendering of a cancellation in UTC.
+func TestEventCancel(t *testing.T) {
+	ev := testEvent(t)
+	ev.Method = MethodCancel
+	ev.Sequence = 2
+	ev.Start, ev.End = ev.Start.UTC(), ev.End.UTC()
+
+	ics, err := ev.ICS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := string(ics)
+	for _, expected := range []string{"METHOD:CANCEL\r\n", "SEQUENCE:2\r\n", "STATUS:CANCELLED\r\n", "DTSTART:20261031T130000Z\r\n"} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("missing %q in:\n%s", expected, text)
+		}
+	}
+
+	if strings.Contains(text, "VTIMEZONE") || strings.Contains(text, "RSVP") {
+		t.Fatal(text)
+	}
+
+	if ev.ContentType() != "text/calendar; charset=utf-8; method=CANCEL" {
+		t.Fatal(ev.ContentType())
+	}
+}
+
+// TestEventInvalid checks that incomplete events are refused.
+func TestEventInvalid(t *testing.T) {
+	for _, change := range []func(*Event){
+		func(ev *Event) { ev.UID = "" },
+		func(ev *Event) { ev.Organizer = nil },
+		func(ev *Event) { ev.Start = time.Time{} },
+		func(ev *Event) { ev.End = ev.Start.Add(-time.Hour) },
+		func(ev *Event) { ev.Attendees = append(ev.Attendees, &Attendee{}) },
+		func(ev *Event) { ev.Attendees = append(ev.Attendees, nil) },
+	} {
+		ev := testEvent(t)
+		change(ev)
+
+		if _, err := ev.ICS(); err == nil {
+			t.FailNow()
+		}
+	}
+}
+
+// TestEmailAllAttachments checks that an email's event is attached as an invitation.
+func TestEmailAllAttachments(t *testing.T) {
+	e := NewEmail()
+	e.AddAttachment("test-attachment", "text/plain", nil)
+
+	attachments, err := e.AllAttachments()
+	if err != nil || len(attachments) != 1 {
+		t.FailNow()
+	}
+
+	e.Event = testEvent(t)
+
+	attachments, err = e.AllAttachments()
+	if err != nil || len(attachments) != 2 || len(e.Attachments) != 1 {
+		t.FailNow()
+	}
+
+	invite := attachments[1]
+	data, _ := ioutil.ReadAll(invite.Data)
+
+	if invite.Name != "invite.ics" || invite.Mimetype != "text/calendar; charset=utf-8; method=REQUEST" ||
+		!strings.HasPrefix(string(data), "BEGIN:VCALENDAR") {
+		t.Fatal(invite)
+	}
+}
@@ -0,0 +1,375 @@
+package cssinline
+
+import (
+	"regexp"
+	"strings"
+)
+
+var comments = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// rule is a parsed style rule with a single selector.
+type rule struct {
+	selector     *selector
+	declarations []declaration
+	order        int
+}
+
+type declaration struct {
+	property, value string
+	important       bool
+}
+
+// parseCSS splits a stylesheet into the rules that can be inlined, and the CSS that has
+// to be kept in a <style> element: at-rules such as @media, and rules with selectors we
+// can't apply inline, such as :hover.
+func parseCSS(css string, order int) ([]*rule, string) {
+	css = comments.ReplaceAllString(css, "")
+
+	rules := []*rule{}
+	retained := &strings.Builder{}
+
+	for i := 0; i < len(css); {
+		rest := strings.TrimLeft(css[i:], " \t\r\n\f")
+		i = len(css) - len(rest)
+		if rest == "" {
+			break
+		}
+
+		// at-rules are kept whole, with any block they have
+		if rest[0] == '@' {
+			end := atRuleEnd(rest)
+			retained.WriteString(strings.TrimSpace(rest[:end]) + "\n")
+			i += end
+			continue
+		}
+
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		close := strings.IndexByte(rest[open:], '}')
+		if close < 0 {
+			close = len(rest) - open
+		}
+
+		selectorText := strings.TrimSpace(rest[:open])
+		body := rest[open+1 : open+close]
+		i += open + close + 1
+
+		declarations := parseDeclarations(body)
+		kept := []string{}
+
+		for _, text := range strings.Split(selectorText, ",") {
+			text = strings.TrimSpace(text)
+			if text == "" {
+				continue
+			}
+
+			sel, ok := parseSelector(text)
+			if !ok {
+				kept = append(kept, text)
+				continue
+			}
+
+			order++
+			rules = append(rules, &rule{sel, declarations, order})
+		}
+
+		if len(kept) > 0 {
+			retained.WriteString(strings.Join(kept, ", ") + " {" + body + "}\n")
+		}
+	}
+
+	return rules, retained.String()
+}
+
+// atRuleEnd finds the end of the at-rule at the start of css, matching up braces.
+func atRuleEnd(css string) int {
+	depth := 0
+
+	for i := 0; i < len(css); i++ {
+		switch css[i] {
+		case ';':
+			if depth == 0 {
+				return i + 1
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return len(css)
+}
+
+// parseDeclarations parses the body of a rule or a style attribute.
+func parseDeclarations(body string) []declaration {
+	declarations := []declaration{}
+
+	for _, decl := range strings.Split(body, ";") {
+		colon := strings.IndexByte(decl, ':')
+		if colon < 0 {
+			continue
+		}
+
+		property := strings.ToLower(strings.TrimSpace(decl[:colon]))
+		value := strings.TrimSpace(decl[colon+1:])
+		important := false
+
+		if idx := strings.Index(strings.ToLower(value), "!important"); idx >= 0 {
+			important = true
+			value = strings.TrimSpace(value[:idx])
+		}
+
+		if property != "" && value != "" {
+			declarations = append(declarations, declaration{property, value, important})
+		}
+	}
+
+	return declarations
+}
+
+// specificity is the (ids, classes, types) triple used to order rules.
+type specificity [3]int
+
+func (s specificity) less(o specificity) bool {
+	for i := range s {
+		if s[i] != o[i] {
+			return s[i] < o[i]
+		}
+	}
+	return false
+}
+
+// compound is a run of simple selectors, eg a.button#go[target]
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+type attrSelector struct {
+	name, op, value string
+}
+
+// selector is a chain of compounds, rightmost first, with the combinator (' ' or '>')
+// between each one and the next.
+type selector struct {
+	compounds   []*compound
+	combinators []byte
+	specificity specificity
+}
+
+// parseSelector parses the supported subset of selectors: type, class, id, universal and
+// attribute selectors, with descendant and child combinators.
+func parseSelector(text string) (*selector, bool) {
+	sel := &selector{}
+	parts := []*compound{}
+	combinators := []byte{}
+
+	i := 0
+	for i < len(text) {
+		c, next, ok := parseCompound(text, i, sel)
+		if !ok {
+			return nil, false
+		}
+		parts = append(parts, c)
+		i = next
+
+		// work out the combinator to the next compound
+		combinator := byte(0)
+	combinators:
+		for ; i < len(text); i++ {
+			switch text[i] {
+			case ' ', '\t', '\n', '\r', '\f':
+				if combinator == 0 {
+					combinator = ' '
+				}
+			case '>':
+				combinator = '>'
+			case '+', '~':
+				return nil, false
+			default:
+				break combinators
+			}
+		}
+
+		if i < len(text) {
+			combinators = append(combinators, combinator)
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil, false
+	}
+
+	// store everything rightmost first, which is the order it's matched in
+	for l, r := 0, len(parts)-1; l < r; l, r = l+1, r-1 {
+		parts[l], parts[r] = parts[r], parts[l]
+	}
+	for l, r := 0, len(combinators)-1; l < r; l, r = l+1, r-1 {
+		combinators[l], combinators[r] = combinators[r], combinators[l]
+	}
+
+	sel.compounds, sel.combinators = parts, combinators
+	return sel, true
+}
+
+func parseCompound(text string, i int, sel *selector) (*compound, int, bool) {
+	c := &compound{}
+	start := i
+
+	ident := func() string {
+		j := i
+		for j < len(text) && isIdentChar(text[j]) {
+			j++
+		}
+		s := text[i:j]
+		i = j
+		return s
+	}
+
+	if i < len(text) && text[i] == '*' {
+		i++
+	} else if i < len(text) && isIdentChar(text[i]) {
+		c.tag = strings.ToLower(ident())
+		sel.specificity[2]++
+	}
+
+	for i < len(text) {
+		switch text[i] {
+		case '#':
+			i++
+			if c.id = ident(); c.id == "" {
+				return nil, i, false
+			}
+			sel.specificity[0]++
+		case '.':
+			i++
+			class := ident()
+			if class == "" {
+				return nil, i, false
+			}
+			c.classes = append(c.classes, class)
+			sel.specificity[1]++
+		case '[':
+			end := strings.IndexByte(text[i:], ']')
+			if end < 0 {
+				return nil, i, false
+			}
+			a, ok := parseAttrSelector(text[i+1 : i+end])
+			if !ok {
+				return nil, i, false
+			}
+			c.attrs = append(c.attrs, a)
+			sel.specificity[1]++
+			i += end + 1
+		case ' ', '\t', '\n', '\r', '\f', '>', '+', '~':
+			return c, i, i > start
+		default:
+			// pseudo-classes, pseudo-elements and anything else we don't understand
+			return nil, i, false
+		}
+	}
+
+	return c, i, i > start
+}
+
+// isIdentChar is isNameChar without the colon, which starts a pseudo-class in selectors.
+func isIdentChar(c byte) bool {
+	return c != ':' && isNameChar(c) || c == '_' || c >= 0x80
+}
+
+func parseAttrSelector(text string) (attrSelector, bool) {
+	for _, op := range []string{"~=", "^=", "$=", "*=", "|=", "="} {
+		if idx := strings.Index(text, op); idx >= 0 {
+			value := strings.TrimSpace(text[idx+len(op):])
+			value = strings.Trim(value, `"'`)
+			return attrSelector{strings.ToLower(strings.TrimSpace(text[:idx])), op, value}, true
+		}
+	}
+
+	name := strings.ToLower(strings.TrimSpace(text))
+	return attrSelector{name, "", ""}, name != ""
+}
+
+// matches checks the selector against an element and its ancestors.
+func (s *selector) matches(el *element) bool {
+	return s.matchFrom(0, el)
+}
+
+func (s *selector) matchFrom(idx int, el *element) bool {
+	if !s.compounds[idx].matches(el) {
+		return false
+	}
+
+	if idx == len(s.compounds)-1 {
+		return true
+	}
+
+	if s.combinators[idx] == '>' {
+		return el.parent != nil && s.matchFrom(idx+1, el.parent)
+	}
+
+	for ancestor := el.parent; ancestor != nil; ancestor = ancestor.parent {
+		if s.matchFrom(idx+1, ancestor) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *compound) matches(el *element) bool {
+	if c.tag != "" && c.tag != el.tag {
+		return false
+	}
+
+	if c.id != "" {
+		if id, _ := el.attr("id"); id != c.id {
+			return false
+		}
+	}
+
+	for _, class := range c.classes {
+		if !el.hasClass(class) {
+			return false
+		}
+	}
+
+	for _, a := range c.attrs {
+		value, ok := el.attr(a.name)
+		if !ok {
+			return false
+		}
+
+		switch a.op {
+		case "=":
+			ok = value == a.value
+		case "~=":
+			ok = false
+			for _, word := range strings.Fields(value) {
+				ok = ok || word == a.value
+			}
+		case "^=":
+			ok = strings.HasPrefix(value, a.value)
+		case "$=":
+			ok = strings.HasSuffix(value, a.value)
+		case "*=":
+			ok = strings.Contains(value, a.value)
+		case "|=":
+			ok = value == a.value || strings.HasPrefix(value, a.value+"-")
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,182 @@
+// CSS inlining
+//
+// Many email clients ignore <style> elements, so the rules in them are applied to each
+// element's style attribute instead.  Rules that can't be inlined, such as media queries
+// and :hover, are left in a <style> element for the clients that do support them.
+
+package cssinline
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"sort"
+	"strings"
+)
+
+// elements that are never styled
+var unstyled = map[string]bool{
+	"head": true, "title": true, "style": true, "script": true, "meta": true, "link": true, "base": true,
+}
+
+// Inline applies the rules in the HTML's <style> elements to the style attributes of the
+// elements they match, following the CSS cascade: !important declarations win, then
+// existing inline styles, then the most specific rule, then the last one.
+//
+// Supported selectors are type, class, id, universal and attribute selectors combined with
+// descendant and child combinators.  Rules with any other selector, and at-rules such as
+// @media and @font-face, are kept in a <style> element; <style> elements with a media
+// attribute are left alone.
+func Inline(html string) string {
+	elements := parseHTML(html)
+	rules := []*rule{}
+
+	type edit struct {
+		start, end  int
+		replacement string
+	}
+	edits := []edit{}
+
+	for _, el := range elements {
+		if el.tag != "style" {
+			continue
+		}
+		if media, ok := el.attr("media"); ok && media != "all" && media != "screen" {
+			continue
+		}
+
+		parsed, retained := parseCSS(html[el.contentStart:el.contentEnd], len(rules))
+		rules = append(rules, parsed...)
+
+		// replace the element with whatever is left of it
+		end := el.contentEnd
+		if close := strings.IndexByte(html[end:], '>'); close >= 0 {
+			end += close + 1
+		}
+
+		replacement := ""
+		if retained != "" {
+			replacement = html[el.start:el.end] + "\n" + retained + "</style>"
+		}
+		edits = append(edits, edit{el.start, end, replacement})
+	}
+
+	if len(rules) == 0 {
+		return html
+	}
+
+	// apply rules in increasing precedence, so later ones overwrite earlier ones
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].selector.specificity != rules[j].selector.specificity {
+			return rules[i].selector.specificity.less(rules[j].selector.specificity)
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	for _, el := range elements {
+		if unstyled[el.tag] {
+			continue
+		}
+
+		style := newCascade()
+		matched := false
+
+		for _, r := range rules {
+			if r.selector.matches(el) {
+				style.apply(r.declarations, false)
+				matched = true
+			}
+		}
+
+		if !matched {
+			continue
+		}
+
+		existing, _ := el.attr("style")
+		style.apply(parseDeclarations(existing), true)
+
+		el.setAttr("style", style.String())
+		edits = append(edits, edit{el.start, el.end, el.startTag()})
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	out := &strings.Builder{}
+	last := 0
+
+	for _, e := range edits {
+		if e.start < last {
+			continue
+		}
+		out.WriteString(html[last:e.start])
+		out.WriteString(e.replacement)
+		last = e.end
+	}
+	out.WriteString(html[last:])
+
+	return out.String()
+}
+
+// cascade accumulates the declarations for an element, keeping the winner for each property.
+type cascade struct {
+	properties []string
+	winners    map[string]*cascaded
+}
+
+type cascaded struct {
+	declaration
+	inline bool
+}
+
+func newCascade() *cascade {
+	return &cascade{winners: make(map[string]*cascaded)}
+}
+
+// apply adds declarations of equal or higher precedence than those already applied,
+// other than where !important says otherwise.
+func (c *cascade) apply(declarations []declaration, inline bool) {
+	for _, d := range declarations {
+		current, ok := c.winners[d.property]
+		if !ok {
+			c.properties = append(c.properties, d.property)
+		} else if current.important && !d.important {
+			continue
+		} else if current.important && d.important && current.inline && !inline {
+			continue
+		}
+
+		c.winners[d.property] = &cascaded{d, inline}
+	}
+}
+
+func (c *cascade) String() string {
+	parts := make([]string, 0, len(c.properties))
+
+	for _, property := range c.properties {
+		d := c.winners[property]
+		value := d.value
+		if d.important {
+			value += " !important"
+		}
+		parts = append(parts, property+": "+value)
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// NewMiddleware returns a middleware that inlines the CSS of HTML bodies before they reach
+// the wrapped backend.
+func NewMiddleware() backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if !strings.Contains(strings.ToLower(e.HTMLBody), "<style") {
+				return next.SendEmail(ctx, e)
+			}
+
+			e = e.Clone()
+			e.HTMLBody = Inline(e.HTMLBody)
+
+			return next.SendEmail(ctx, e)
+		})
+	}
+}
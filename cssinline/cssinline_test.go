@@ -0,0 +1,122 @@
+package cssinline
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"strings"
+	"testing"
+)
+
+const testHTML = `<html>
+<head>
+<style type="text/css">
+/* base styles */
+p { color: black; margin: 0 }
+.note { color: gray }
+#footer p { color: blue }
+div > p.note { font-size: 12px }
+a:hover { color: red }
+p.important { color: green !important }
+@media (max-width: 600px) {
+	p { margin: 10px; }
+}
+</style>
+</head>
+<body>
+<div><p class="note">Note</p></div>
+<div id="footer"><section><p>Footer</p></section></div>
+<p class="important" style="color: purple; padding: 1px">Important</p>
+<a href="/x?a=1&amp;b=2">Link</a>
+</body>
+</html>`
+
+// TestInline checks that rules are inlined following the cascade.
+func TestInline(t *testing.T) {
+	html := Inline(testHTML)
+
+	for _, expected := range []string{
+		`<p class="note" style="color: gray; margin: 0; font-size: 12px">Note</p>`,
+		`<p style="color: blue; margin: 0">Footer</p>`,
+		`<p class="important" style="color: green !important; margin: 0; padding: 1px">Important</p>`,
+
+		// untouched elements keep their exact markup
+		`<a href="/x?a=1&amp;b=2">Link</a>`,
+		`<div id="footer"><section>`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Fatalf("missing %s in:\n%s", expected, html)
+		}
+	}
+
+	// the rules that can't be inlined are kept
+	if !strings.Contains(html, "a:hover { color: red }") || !strings.Contains(html, "@media (max-width: 600px)") {
+		t.Fatal(html)
+	}
+
+	if strings.Contains(html, ".note { color: gray }") {
+		t.Fatal(html)
+	}
+}
+
+// TestInlineRemovesStyle checks that a fully inlined <style> element is removed.
+func TestInlineRemovesStyle(t *testing.T) {
+	html := Inline(`<style>h1 { font-weight: bold }</style><h1>Hi</h1><br/>`)
+
+	if html != `<h1 style="font-weight: bold">Hi</h1><br/>` {
+		t.Fatal(html)
+	}
+}
+
+// TestInlineMalformed checks that markup in scripts and comments isn't styled, and that
+// stray "<" characters don't hide the elements after them.
+func TestInlineMalformed(t *testing.T) {
+	html := Inline(`<style>p { color: red }</style><script>if (a < b) { x = "<p>" }</script>` +
+		`<!-- <p> --><span>5 < 6</span><p>Hi`)
+
+	if html != `<script>if (a < b) { x = "<p>" }</script><!-- <p> --><span>5 < 6</span><p style="color: red">Hi` {
+		t.Fatal(html)
+	}
+}
+
+// TestSelectors checks selector parsing and specificity.
+func TestSelectors(t *testing.T) {
+	for text, expected := range map[string]specificity{
+		"p":                {0, 0, 1},
+		"div > p.note":     {0, 1, 2},
+		"#footer p":        {1, 0, 1},
+		"*":                {0, 0, 0},
+		`a[href^="http"]`:  {0, 1, 1},
+		"ul li.item.first": {0, 2, 2},
+	} {
+		sel, ok := parseSelector(text)
+		if !ok || sel.specificity != expected {
+			t.Errorf("%s: %v", text, sel)
+		}
+	}
+
+	for _, text := range []string{"a:hover", "p::first-line", "h1 + p", "h1 ~ p"} {
+		if _, ok := parseSelector(text); ok {
+			t.Errorf("%s shouldn't be inlined", text)
+		}
+	}
+}
+
+// TestMiddleware checks that the backend receives inlined HTML.
+func TestMiddleware(t *testing.T) {
+	var sent *ego.Email
+	capture := backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		sent = e
+		return &backends.Result{}, nil
+	})
+
+	e := testutils.TestEmail()
+	e.HTMLBody = `<style>h1 { color: red }</style><h1>Test</h1>`
+
+	backends.Chain(capture, NewMiddleware()).SendEmail(context.Background(), e)
+
+	if sent.HTMLBody != `<h1 style="color: red">Test</h1>` {
+		t.Fatal(sent.HTMLBody)
+	}
+}
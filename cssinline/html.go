@@ -0,0 +1,118 @@
+package cssinline
+
+import (
+	"github.com/jarcoal/ego/htmltoken"
+	"strings"
+)
+
+// attribute is a single attribute of a start tag, in source order.
+type attribute struct {
+	name, value string
+}
+
+// element is a start tag in the document.  Only what's needed to match selectors is kept;
+// the tag's position is recorded so it can be rewritten in place.
+type element struct {
+	tag        string
+	attrs      []attribute
+	parent     *element
+	start, end int // byte offsets of the start tag in the source
+
+	// set for <style> elements: the offsets of their content
+	contentStart, contentEnd int
+}
+
+func (e *element) attr(name string) (string, bool) {
+	for _, a := range e.attrs {
+		if a.name == name {
+			return a.value, true
+		}
+	}
+	return "", false
+}
+
+func (e *element) hasClass(class string) bool {
+	classes, _ := e.attr("class")
+	for _, c := range strings.Fields(classes) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *element) setAttr(name, value string) {
+	for i, a := range e.attrs {
+		if a.name == name {
+			e.attrs[i].value = value
+			return
+		}
+	}
+	e.attrs = append(e.attrs, attribute{name, value})
+}
+
+// startTag renders the element's start tag.
+func (e *element) startTag() string {
+	b := &strings.Builder{}
+	b.WriteString("<" + e.tag)
+
+	for _, a := range e.attrs {
+		b.WriteString(" " + a.name + `="`)
+		b.WriteString(strings.NewReplacer("&", "&amp;", `"`, "&quot;").Replace(a.value))
+		b.WriteString(`"`)
+	}
+
+	b.WriteString(">")
+	return b.String()
+}
+
+// parseHTML finds the elements of the document in source order.  It's forgiving in the
+// way browsers are: unclosed elements are closed by their parent's end tag, and stray end
+// tags are ignored.
+func parseHTML(src string) []*element {
+	elements := []*element{}
+	stack := []*element{}
+
+	z := htmltoken.NewTokenizer(src)
+	for tok := z.Next(); tok != nil; tok = z.Next() {
+		switch tok.Type {
+		case htmltoken.EndTag:
+			for j := len(stack) - 1; j >= 0; j-- {
+				if stack[j].tag == tok.Tag {
+					stack = stack[:j]
+					break
+				}
+			}
+
+		case htmltoken.Text:
+			// the content of <style>, which the tokenizer reads as raw text
+			if len(elements) > 0 {
+				if el := elements[len(elements)-1]; el.tag == "style" && el.contentEnd == el.end {
+					el.contentStart, el.contentEnd = tok.Start, tok.End
+				}
+			}
+
+		case htmltoken.StartTag:
+			el := &element{tag: tok.Tag, start: tok.Start, end: tok.End}
+			el.contentStart, el.contentEnd = tok.End, tok.End
+			for _, a := range tok.Attrs {
+				el.attrs = append(el.attrs, attribute{a.Name, a.Value})
+			}
+
+			if len(stack) > 0 {
+				el.parent = stack[len(stack)-1]
+			}
+			elements = append(elements, el)
+
+			if !tok.SelfClosing && !htmltoken.VoidElements[el.tag] && el.tag != "style" && el.tag != "script" {
+				stack = append(stack, el)
+			}
+		}
+	}
+
+	return elements
+}
+
+func isNameChar(c byte) bool {
+	return c == '-' || c == ':' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
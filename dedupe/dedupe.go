@@ -0,0 +1,161 @@
+// Dedupe middleware
+//
+// Refuses to send an email whose IdempotencyKey has already been sent recently, so that
+// retrying after an ambiguous failure (eg a timeout) can't deliver the same email twice.
+
+package dedupe
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"sync"
+	"time"
+)
+
+// ErrDuplicate is returned when an email with the same idempotency key was already sent.
+var ErrDuplicate = errors.New("an email with this idempotency key was already sent")
+
+// Store remembers which idempotency keys have been sent.
+type Store interface {
+	// Claim marks the key as sent for ttl.  It returns false if the key is already claimed.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+
+	// Release forgets the key, so the email can be sent again.
+	Release(ctx context.Context, key string) error
+}
+
+// NewMiddleware returns a middleware that sends each idempotency key through the wrapped
+// backend at most once per ttl.  Emails without a key are always sent.
+//
+// The key is released, so that the send can be retried, when the email certainly wasn't
+// sent: the provider rejected it with a 4xx status, or the error matches
+// backends.ErrNotSent.  Other failures are ambiguous, as the email may have gone out
+// anyway, so the key is kept.  That includes 5xx statuses, which the provider may give
+// after accepting the email, and 408 and 429.  A *backends.PartialError's key is kept too,
+// unless none of its recipients were sent to because every one of its errors matches
+// backends.ErrNotSent.
+func NewMiddleware(s Store, ttl time.Duration) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if e.IdempotencyKey == "" {
+				return next.SendEmail(ctx, e)
+			}
+
+			claimed, err := s.Claim(ctx, e.IdempotencyKey, ttl)
+			if err != nil {
+				return nil, err
+			} else if !claimed {
+				return nil, ErrDuplicate
+			}
+
+			result, err := next.SendEmail(ctx, e)
+			if err != nil && notSent(result, err) {
+				if releaseErr := s.Release(ctx, e.IdempotencyKey); releaseErr != nil {
+					return result, releaseErr
+				}
+			}
+
+			return result, err
+		})
+	}
+}
+
+// notSent reports whether the failed send certainly didn't go out.
+func notSent(result *backends.Result, err error) bool {
+	var partial *backends.PartialError
+	if errors.As(err, &partial) {
+		if partial.Failed != partial.Total || len(partial.Errs) == 0 {
+			return false
+		}
+		for _, err := range partial.Errs {
+			if !errors.Is(err, backends.ErrNotSent) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if errors.Is(err, backends.ErrNotSent) {
+		return true
+	}
+
+	if result == nil {
+		return false
+	}
+
+	// 408 and 429 may be given after the provider accepted the email
+	code := result.StatusCode
+	return code >= 400 && code < 500 && code != 408 && code != 429
+}
+
+// NewMemoryStore returns a Store that keeps keys in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{keys: make(map[string]time.Time)}
+}
+
+type memoryStore struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+
+	// expiries orders the claims by when they expire, so that expired keys can be
+	// cleared out without looking at every key.  Released and reclaimed keys leave stale
+	// entries behind, which are skipped.
+	expiries expiryHeap
+}
+
+func (m *memoryStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	// take the opportunity to clear out expired keys
+	for len(m.expiries) > 0 && !now.Before(m.expiries[0].expires) {
+		expired := heap.Pop(&m.expiries).(expiry)
+		if m.keys[expired.key].Equal(expired.expires) {
+			delete(m.keys, expired.key)
+		}
+	}
+
+	if expires, ok := m.keys[key]; ok && now.Before(expires) {
+		return false, nil
+	}
+
+	m.keys[key] = now.Add(ttl)
+	heap.Push(&m.expiries, expiry{key, m.keys[key]})
+	return true, nil
+}
+
+func (m *memoryStore) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, key)
+	return nil
+}
+
+type expiry struct {
+	key     string
+	expires time.Time
+}
+
+// expiryHeap is a heap.Interface of expiries, soonest first.
+type expiryHeap []expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x interface{}) {
+	*h = append(*h, x.(expiry))
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
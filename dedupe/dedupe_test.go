@@ -0,0 +1,171 @@
+package dedupe
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"testing"
+	"time"
+)
+
+func countingBackend(sends *int, result *backends.Result, err error) backends.Backend {
+	return backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		*sends++
+		return result, err
+	})
+}
+
+// TestDuplicate checks that an email is only sent once per key.
+func TestDuplicate(t *testing.T) {
+	sends := 0
+	b := backends.Chain(countingBackend(&sends, &backends.Result{}, nil), NewMiddleware(NewMemoryStore(), time.Hour))
+
+	ctx := context.Background()
+	e := testutils.TestEmail()
+	e.IdempotencyKey = "order-1234-receipt"
+
+	if _, err := b.SendEmail(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.SendEmail(ctx, e); err != ErrDuplicate {
+		t.Fatal(err)
+	}
+
+	if sends != 1 {
+		t.FailNow()
+	}
+
+	// emails without keys aren't deduplicated
+	e.IdempotencyKey = ""
+	b.SendEmail(ctx, e)
+	b.SendEmail(ctx, e)
+
+	if sends != 3 {
+		t.FailNow()
+	}
+}
+
+// TestRelease checks that the key is only released when the provider rejected the email.
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+	e := testutils.TestEmail()
+	e.IdempotencyKey = "order-1234-receipt"
+
+	// the provider rejected the email, so it's safe to retry
+	sends := 0
+	b := backends.Chain(countingBackend(&sends, &backends.Result{StatusCode: 400}, errors.New("bad request")),
+		NewMiddleware(NewMemoryStore(), time.Hour))
+
+	b.SendEmail(ctx, e)
+	b.SendEmail(ctx, e)
+
+	if sends != 2 {
+		t.FailNow()
+	}
+
+	// the provider may have accepted the email before it failed or timed out
+	for _, status := range []int{500, 502, 503, 504, 408, 429} {
+		sends = 0
+		b = backends.Chain(countingBackend(&sends, &backends.Result{StatusCode: status}, errors.New("boom")),
+			NewMiddleware(NewMemoryStore(), time.Hour))
+
+		b.SendEmail(ctx, e)
+		if _, err := b.SendEmail(ctx, e); err != ErrDuplicate || sends != 1 {
+			t.Fatal(status, err)
+		}
+	}
+
+	// no response, so the email might have gone out
+	sends = 0
+	b = backends.Chain(countingBackend(&sends, nil, context.DeadlineExceeded),
+		NewMiddleware(NewMemoryStore(), time.Hour))
+
+	b.SendEmail(ctx, e)
+	if _, err := b.SendEmail(ctx, e); err != ErrDuplicate {
+		t.Fatal(err)
+	}
+
+	if sends != 1 {
+		t.FailNow()
+	}
+
+	// mandrill couldn't decode a 200 response, but the email went out
+	sends = 0
+	b = backends.Chain(countingBackend(&sends, &backends.Result{StatusCode: 200}, errors.New("failed to decode")),
+		NewMiddleware(NewMemoryStore(), time.Hour))
+
+	b.SendEmail(ctx, e)
+	if _, err := b.SendEmail(ctx, e); err != ErrDuplicate {
+		t.Fatal(err)
+	}
+
+	// some recipients were, or may have been, sent to
+	for _, partial := range []*backends.PartialError{
+		{Failed: 1, Total: 2, Errs: []error{backends.NotSent(errors.New("boom"))}},
+		{Failed: 2, Total: 2, Errs: []error{backends.NotSent(errors.New("boom")), context.DeadlineExceeded}},
+	} {
+		sends = 0
+		b = backends.Chain(countingBackend(&sends, &backends.Result{}, partial),
+			NewMiddleware(NewMemoryStore(), time.Hour))
+
+		b.SendEmail(ctx, e)
+		if _, err := b.SendEmail(ctx, e); err != ErrDuplicate {
+			t.Fatal(partial, err)
+		}
+	}
+
+	// nothing was handed to the provider
+	for _, notSent := range []error{
+		&backends.PartialError{Failed: 2, Total: 2, Errs: []error{
+			backends.NotSent(errors.New("boom")), backends.NotSent(errors.New("boom")),
+		}},
+		backends.NotSent(errors.New("failed to read attachment")),
+		&backends.UnsupportedError{Backend: "sendgrid", Feature: "return path"},
+	} {
+		sends = 0
+		b = backends.Chain(countingBackend(&sends, nil, notSent), NewMiddleware(NewMemoryStore(), time.Hour))
+
+		b.SendEmail(ctx, e)
+		b.SendEmail(ctx, e)
+
+		if sends != 2 {
+			t.Fatal(notSent)
+		}
+	}
+}
+
+// TestExpiry checks that keys can be sent again after the ttl.
+func TestExpiry(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	if ok, _ := s.Claim(ctx, "key", time.Millisecond); !ok {
+		t.FailNow()
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	if ok, _ := s.Claim(ctx, "key", time.Millisecond); !ok {
+		t.FailNow()
+	}
+
+	// a released and reclaimed key isn't cleared out by its old expiry
+	if ok, _ := s.Claim(ctx, "other", time.Millisecond); !ok {
+		t.FailNow()
+	}
+	s.Release(ctx, "other")
+	s.Claim(ctx, "other", time.Hour)
+
+	time.Sleep(2 * time.Millisecond)
+	s.Claim(ctx, "key", time.Millisecond)
+
+	if ok, _ := s.Claim(ctx, "other", time.Hour); ok {
+		t.FailNow()
+	}
+	if len(s.(*memoryStore).keys) != 2 {
+		t.FailNow()
+	}
+}
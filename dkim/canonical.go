@@ -0,0 +1,103 @@
+package dkim
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// Canonicalization is a way of normalizing a message before it's hashed, so that changes
+// made by servers along the way don't break the signature.
+type Canonicalization string
+
+const (
+	// Simple tolerates almost no changes.
+	Simple Canonicalization = "simple"
+
+	// Relaxed tolerates changes to whitespace and header field name case.
+	Relaxed Canonicalization = "relaxed"
+)
+
+var (
+	whitespace = regexp.MustCompile(`[ \t]+`)
+	folding    = regexp.MustCompile(`\r\n([ \t])`)
+)
+
+// field is a raw header field, including its trailing CRLF.
+type field struct {
+	name, raw string
+}
+
+// split normalizes line endings to CRLF and splits a message into its header fields and
+// body.
+func split(msg []byte) ([]field, []byte) {
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	msg = bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+
+	header, body := msg, []byte{}
+	if idx := bytes.Index(msg, []byte("\r\n\r\n")); idx >= 0 {
+		header, body = msg[:idx+2], msg[idx+4:]
+	}
+
+	fields := []field{}
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		// continuation lines belong to the previous field
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+
+		name := line
+		if colon := strings.IndexByte(line, ':'); colon >= 0 {
+			name = line[:colon]
+		}
+		fields = append(fields, field{strings.TrimSpace(name), line})
+	}
+
+	return fields, body
+}
+
+// canonicalHeader canonicalizes a raw header field.
+func canonicalHeader(raw string, c Canonicalization) string {
+	if c == Simple {
+		return raw
+	}
+
+	colon := strings.IndexByte(raw, ':')
+	if colon < 0 {
+		return raw
+	}
+
+	name := strings.ToLower(strings.TrimSpace(raw[:colon]))
+	value := folding.ReplaceAllString(raw[colon+1:], "$1")
+	value = strings.TrimRight(value, "\r\n")
+	value = strings.TrimSpace(whitespace.ReplaceAllString(value, " "))
+
+	return name + ":" + value + "\r\n"
+}
+
+// canonicalBody canonicalizes a body that has CRLF line endings.
+func canonicalBody(body []byte, c Canonicalization) []byte {
+	if c == Relaxed {
+		lines := strings.Split(string(body), "\r\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(whitespace.ReplaceAllString(line, " "), " ")
+		}
+		body = []byte(strings.Join(lines, "\r\n"))
+	}
+
+	// trailing empty lines are ignored
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+
+	if len(body) == 0 && c == Relaxed {
+		return body
+	}
+
+	return append(body, '\r', '\n')
+}
@@ -0,0 +1,188 @@
+// DKIM signing
+//
+// Services that take a raw message, and SMTP servers, expect it to be DKIM signed by us.
+// This package signs rendered messages with RSA-SHA256 or Ed25519-SHA256 (RFC 6376 and
+// RFC 8463), and verifies signatures for tests.
+
+package dkim
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/message"
+	"strings"
+	"time"
+)
+
+// DefaultHeaders are the header fields signed when Config.Headers is empty.  Only the ones
+// a message has are signed.
+var DefaultHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID", "In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// Config describes how to sign messages.
+type Config struct {
+	// The signing domain and the selector of the key, which is published in DNS at
+	// <selector>._domainkey.<domain>.
+	Domain, Selector string
+
+	// Key is an *rsa.PrivateKey or an ed25519.PrivateKey.
+	Key crypto.Signer
+
+	// Header fields to sign.  From is always signed.
+	Headers []string
+
+	// Canonicalization of the header and body, Relaxed when empty.
+	HeaderCanonicalization, BodyCanonicalization Canonicalization
+}
+
+func (c Config) algorithm() (string, error) {
+	switch c.Key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	}
+	return "", fmt.Errorf("unsupported DKIM key type %T", c.Key)
+}
+
+func canonicalization(c Canonicalization) Canonicalization {
+	if c == "" {
+		return Relaxed
+	}
+	return c
+}
+
+// Sign returns the message with a DKIM-Signature header field added.  Line endings are
+// normalized to CRLF.
+func Sign(msg []byte, c Config) ([]byte, error) {
+	if c.Domain == "" || c.Selector == "" || c.Key == nil {
+		return nil, errors.New("DKIM signing needs a domain, selector and key")
+	}
+
+	algorithm, err := c.algorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	headerCanon, bodyCanon := canonicalization(c.HeaderCanonicalization), canonicalization(c.BodyCanonicalization)
+	fields, body := split(msg)
+
+	// sign each occurrence of the fields we have, plus From no matter what
+	names := c.Headers
+	if len(names) == 0 {
+		names = DefaultHeaders
+	}
+
+	signed := []string{}
+	seen := map[string]bool{}
+	for _, name := range append([]string{"From"}, names...) {
+		lower := strings.ToLower(name)
+		if seen[lower] {
+			continue
+		}
+		seen[lower] = true
+
+		for _, f := range fields {
+			if strings.EqualFold(f.name, name) {
+				signed = append(signed, lower)
+			}
+		}
+	}
+
+	if len(signed) == 0 || signed[0] != "from" {
+		return nil, errors.New("DKIM signing needs a message with a From header field")
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody(body, bodyCanon))
+
+	value := fmt.Sprintf("v=1; a=%s; c=%s/%s; d=%s; s=%s; t=%d;\r\n h=%s;\r\n bh=%s;\r\n b=",
+		algorithm, headerCanon, bodyCanon, c.Domain, c.Selector, time.Now().Unix(),
+		strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	signature := "DKIM-Signature: " + value
+
+	digest := headerHash(fields, signed, signature, headerCanon)
+
+	var sig []byte
+	if algorithm == "ed25519-sha256" {
+		sig, err = c.Key.Sign(rand.Reader, digest, crypto.Hash(0))
+	} else {
+		sig, err = c.Key.Sign(rand.Reader, digest, crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DKIM signature: %s", err)
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString(signature + base64.StdEncoding.EncodeToString(sig) + "\r\n")
+	for _, f := range fields {
+		out.WriteString(f.raw)
+	}
+	out.WriteString("\r\n")
+	out.Write(body)
+
+	return out.Bytes(), nil
+}
+
+// headerHash hashes the signed header fields, taking repeated fields from the bottom up,
+// followed by the DKIM-Signature field with an empty signature.
+func headerHash(fields []field, signed []string, signature string, c Canonicalization) []byte {
+	h := sha256.New()
+	used := map[int]bool{}
+
+	for _, name := range signed {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].name, name) {
+				used[i] = true
+				h.Write([]byte(canonicalHeader(fields[i].raw, c)))
+				break
+			}
+		}
+	}
+
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(signature, c), "\r\n")))
+
+	return h.Sum(nil)
+}
+
+// PublicKeyRecord returns the DNS TXT record that publishes a public key, to be added at
+// <selector>._domainkey.<domain>.
+func PublicKeyRecord(key crypto.PublicKey) (string, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(key), nil
+	}
+	return "", fmt.Errorf("unsupported DKIM key type %T", key)
+}
+
+// NewMiddleware returns a middleware that signs every message before it reaches the
+// wrapped sender.  A message that can't be signed isn't sent, and its error matches
+// backends.ErrNotSent.
+func NewMiddleware(c Config) message.Middleware {
+	return func(next message.Sender) message.Sender {
+		return message.SenderFunc(func(ctx context.Context, env *message.Envelope, msg []byte) error {
+			signed, err := Sign(msg, c)
+			if err != nil {
+				return backends.NotSent(err)
+			}
+			return next.SendMessage(ctx, env, signed)
+		})
+	}
+}
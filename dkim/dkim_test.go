@@ -0,0 +1,184 @@
+package dkim
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/message"
+	"github.com/jarcoal/ego/testutils"
+	"strings"
+	"testing"
+)
+
+const testMessage = "From: Jane <jane@example.com>\r\n" +
+	"To: joe@example.org\r\n" +
+	"Subject: Is  dinner\r\n\tready?\r\n" +
+	"Received: from somewhere\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"\r\n"
+
+var rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+var _, ed25519Key, _ = ed25519.GenerateKey(rand.Reader)
+
+// lookupFor returns a Lookup that serves the public key of signer.
+func lookupFor(t *testing.T, signer crypto.Signer) Lookup {
+	record, err := PublicKeyRecord(signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func(domain, selector string) (string, error) {
+		if domain != "example.com" || selector != "mail" {
+			t.Fatal(domain, selector)
+		}
+		return record, nil
+	}
+}
+
+// TestSignVerify checks that signatures made with each key type and canonicalization
+// verify, and that changing the message breaks them.
+func TestSignVerify(t *testing.T) {
+	for _, key := range []crypto.Signer{rsaKey, ed25519Key} {
+		for _, canon := range [][2]Canonicalization{{Relaxed, Relaxed}, {Simple, Simple}, {Relaxed, Simple}, {Simple, Relaxed}} {
+			c := Config{
+				Domain:                 "example.com",
+				Selector:               "mail",
+				Key:                    key,
+				HeaderCanonicalization: canon[0],
+				BodyCanonicalization:   canon[1],
+			}
+
+			signed, err := Sign([]byte(testMessage), c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Verify(signed, lookupFor(t, key)); err != nil {
+				t.Fatalf("%T %v: %s\n%s", key, canon, err, signed)
+			}
+
+			// trailing blank lines never matter
+			if err := Verify(append(signed, "\r\n\r\n"...), lookupFor(t, key)); err != nil {
+				t.Fatalf("%T %v: %s", key, canon, err)
+			}
+
+			// unsigned fields can be added
+			if err := Verify(append([]byte("Received: from elsewhere\r\n"), signed...), lookupFor(t, key)); err != nil {
+				t.Fatalf("%T %v: %s", key, canon, err)
+			}
+
+			tampered := bytes.Replace(signed, []byte("hungry"), []byte("full"), 1)
+			if err := Verify(tampered, lookupFor(t, key)); err == nil {
+				t.Fatalf("%T %v: tampered body verified", key, canon)
+			}
+
+			tampered = bytes.Replace(signed, []byte("Subject: Is"), []byte("Subject: Was"), 1)
+			if err := Verify(tampered, lookupFor(t, key)); err == nil {
+				t.Fatalf("%T %v: tampered header verified", key, canon)
+			}
+
+			// whitespace changes are only tolerated by relaxed canonicalization
+			respaced := bytes.Replace(signed, []byte("Subject: Is  dinner"), []byte("subject:Is dinner "), 1)
+			if err := Verify(respaced, lookupFor(t, key)); (err == nil) != (canon[0] == Relaxed) {
+				t.Fatalf("%T %v: %v", key, canon, err)
+			}
+
+			respaced = bytes.Replace(signed, []byte("the game."), []byte("the  game. "), 1)
+			if err := Verify(respaced, lookupFor(t, key)); (err == nil) != (canon[1] == Relaxed) {
+				t.Fatalf("%T %v: %v", key, canon, err)
+			}
+		}
+	}
+}
+
+// TestSignedHeaders checks the h= tag.
+func TestSignedHeaders(t *testing.T) {
+	c := Config{Domain: "example.com", Selector: "mail", Key: ed25519Key, Headers: []string{"Subject", "X-Missing"}}
+
+	signed, err := Sign([]byte(testMessage), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(signed, []byte(" h=from:subject;")) {
+		t.Fatal(string(signed))
+	}
+
+	if _, err := Sign([]byte("Subject: hi\r\n\r\nbody"), c); err == nil {
+		t.Fatal("signed a message without a From field")
+	}
+
+	if err := Verify([]byte(testMessage), lookupFor(t, ed25519Key)); err != ErrNoSignature {
+		t.Fatal(err)
+	}
+}
+
+// TestCanonicalization checks the canonical forms against RFC 6376's examples.
+func TestCanonicalization(t *testing.T) {
+	if h := canonicalHeader("SubJect: AbC\r\n", Relaxed); h != "subject:AbC\r\n" {
+		t.Fatal(h)
+	}
+
+	if h := canonicalHeader("B : Y\t\r\n\tZ  \r\n", Relaxed); h != "b:Y Z\r\n" {
+		t.Fatal(h)
+	}
+
+	_, body := split([]byte("A: X\r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+
+	if b := canonicalBody(body, Relaxed); string(b) != " C\r\nD E\r\n" {
+		t.Fatalf("%q", b)
+	}
+
+	if b := canonicalBody(body, Simple); string(b) != " C \r\nD \t E\r\n" {
+		t.Fatalf("%q", b)
+	}
+
+	if b := canonicalBody(nil, Simple); string(b) != "\r\n" {
+		t.Fatalf("%q", b)
+	}
+
+	if b := canonicalBody(nil, Relaxed); len(b) != 0 {
+		t.Fatalf("%q", b)
+	}
+}
+
+// TestMiddleware checks that rendered emails are signed on their way to the sender.
+func TestMiddleware(t *testing.T) {
+	var sent []byte
+	capture := message.SenderFunc(func(ctx context.Context, env *message.Envelope, msg []byte) error {
+		sent = msg
+		return nil
+	})
+
+	c := Config{Domain: "example.com", Selector: "mail", Key: rsaKey}
+	backend := message.NewBackend(message.Chain(capture, NewMiddleware(c)))
+
+	if _, err := backend.SendEmail(context.Background(), testutils.TestEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(sent), "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=mail;") {
+		t.Fatal(string(sent))
+	}
+
+	if err := Verify(sent, lookupFor(t, rsaKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is sent without a usable key
+	sent = nil
+	backend = message.NewBackend(message.Chain(capture, NewMiddleware(Config{Domain: "example.com", Selector: "mail"})))
+
+	if _, err := backend.SendEmail(context.Background(), testutils.TestEmail()); !errors.Is(err, backends.ErrNotSent) || sent != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,197 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// the value of the b= tag, which is left out when hashing the signature's own field
+var signatureValue = regexp.MustCompile(`([:;][ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+
+// Lookup returns the TXT record published at <selector>._domainkey.<domain>.
+type Lookup func(domain, selector string) (string, error)
+
+// LookupDNS is the Lookup that queries DNS.
+func LookupDNS(domain, selector string) (string, error) {
+	records, err := net.LookupTXT(selector + "._domainkey." + domain)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return "", fmt.Errorf("no DKIM key for %s._domainkey.%s", selector, domain)
+	}
+	return records[0], nil
+}
+
+// ErrNoSignature is returned by Verify for messages without a DKIM-Signature.
+var ErrNoSignature = errors.New("message has no DKIM signature")
+
+// Verify checks the message's DKIM signatures, succeeding if any of them is valid.  Keys
+// are found with lookup, or in DNS if it's nil.  It's meant for testing our own signing,
+// so only the features Sign uses are supported.
+func Verify(msg []byte, lookup Lookup) error {
+	if lookup == nil {
+		lookup = LookupDNS
+	}
+
+	fields, body := split(msg)
+	var firstErr error
+
+	for _, f := range fields {
+		if !strings.EqualFold(f.name, "DKIM-Signature") {
+			continue
+		}
+
+		err := verify(f.raw, fields, body, lookup)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr == nil {
+		return ErrNoSignature
+	}
+	return firstErr
+}
+
+func verify(signature string, fields []field, body []byte, lookup Lookup) error {
+	tags := parseTags(signature[strings.IndexByte(signature, ':')+1:])
+
+	if tags["v"] != "1" {
+		return fmt.Errorf("unsupported DKIM version %q", tags["v"])
+	}
+	if _, ok := tags["l"]; ok {
+		return errors.New("DKIM body length limits aren't supported")
+	}
+
+	headerCanon, bodyCanon := Simple, Simple
+	if c := strings.SplitN(tags["c"], "/", 2); tags["c"] != "" {
+		headerCanon = Canonicalization(c[0])
+		if len(c) == 2 {
+			bodyCanon = Canonicalization(c[1])
+		}
+	}
+	for _, c := range []Canonicalization{headerCanon, bodyCanon} {
+		if c != Simple && c != Relaxed {
+			return fmt.Errorf("unsupported DKIM canonicalization %q", c)
+		}
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody(body, bodyCanon))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return errors.New("DKIM body hash doesn't match")
+	}
+
+	record, err := lookup(tags["d"], tags["s"])
+	if err != nil {
+		return fmt.Errorf("failed to look up DKIM key: %s", err)
+	}
+	key, err := parseKey(record)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("invalid DKIM signature encoding: %s", err)
+	}
+
+	signed := strings.Split(strings.ToLower(tags["h"]), ":")
+	for i := range signed {
+		signed[i] = strings.TrimSpace(signed[i])
+	}
+
+	// the signature's own field is hashed without its value, and separately from the others
+	others := []field{}
+	for _, f := range fields {
+		if f.raw != signature {
+			others = append(others, f)
+		}
+	}
+	unsigned := signatureValue.ReplaceAllString(signature, "$1")
+	digest := headerHash(others, signed, unsigned, headerCanon)
+
+	switch tags["a"] {
+	case "rsa-sha256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("DKIM key isn't an RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig); err != nil {
+			return fmt.Errorf("invalid DKIM signature: %s", err)
+		}
+	case "ed25519-sha256":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("DKIM key isn't an Ed25519 key")
+		}
+		if !ed25519.Verify(pub, digest, sig) {
+			return errors.New("invalid DKIM signature")
+		}
+	default:
+		return fmt.Errorf("unsupported DKIM algorithm %q", tags["a"])
+	}
+
+	return nil
+}
+
+// parseTags parses a tag list such as "v=1; a=rsa-sha256", removing all whitespace from
+// the values, which only base64 tags have and then as folding.
+func parseTags(list string) map[string]string {
+	tags := map[string]string{}
+
+	for _, tag := range strings.Split(list, ";") {
+		eq := strings.IndexByte(tag, '=')
+		if eq < 0 {
+			continue
+		}
+		tags[strings.TrimSpace(tag[:eq])] = strings.Join(strings.Fields(tag[eq+1:]), "")
+	}
+
+	return tags
+}
+
+// parseKey parses the public key in a DKIM key record.
+func parseKey(record string) (crypto.PublicKey, error) {
+	tags := parseTags(record)
+
+	data, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid DKIM key encoding: %s", err)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("DKIM key has been revoked")
+	}
+
+	switch tags["k"] {
+	case "", "rsa":
+		key, err := x509.ParsePKIXPublicKey(data)
+		if err != nil {
+			if key, err := x509.ParsePKCS1PublicKey(data); err == nil {
+				return key, nil
+			}
+			return nil, fmt.Errorf("invalid DKIM key: %s", err)
+		}
+		return key, nil
+	case "ed25519":
+		if len(data) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid DKIM key: wrong Ed25519 key size")
+		}
+		return ed25519.PublicKey(bytes.Clone(data)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported DKIM key type %q", tags["k"])
+}
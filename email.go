@@ -1,9 +1,13 @@
 package ego
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/mail"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -36,6 +40,9 @@ type Email struct {
 	// Files/data to be attached to the email
 	Attachments []*Attachment
 
+	// Meeting that the email is an invitation for, which is attached to it
+	Event *Event
+
 	// SMTP headers to include with message
 	Headers url.Values
 
@@ -61,11 +68,162 @@ type Email struct {
 	// Can recipients see the names of other recipients in the "from" header?
 	// This almost always should be `false`.
 	VisibleRecipients bool
+
+	// Threading information, as Message-IDs without angle brackets.  MessageID is generated
+	// by backends when it's empty (see NewMessageID).  InReplyTo is the message this one
+	// replies to, and References is its thread from the first message on, ending with
+	// the one replied to; SetInReplyTo sets both.
+	MessageID  string
+	InReplyTo  string
+	References []string
+
+	// ReturnPath is the address bounces go back to (the SMTP envelope sender), when it
+	// isn't the From address.  The verp package gives each recipient their own, so that
+	// bounces can be traced back to them.  Providers that run their own bounce handling
+	// may only take its domain, or ignore it.
+	ReturnPath string
+
+	// Where recipients can unsubscribe, sent in the List-Unsubscribe header (see
+	// ListHeaders).  UnsubscribeURL is an https URL that unsubscribes with a single POST,
+	// as RFC 8058 one-click unsubscribes do, and UnsubscribeMailto is an address (or a
+	// mailto: URL) that unsubscribes whoever emails it.  Either or both may be set.
+	UnsubscribeURL    string
+	UnsubscribeMailto string
+
+	// Uniquely identifies this email so that retries can't send it twice.  It's passed on
+	// to services that deduplicate sends themselves.
+	IdempotencyKey string
+}
+
+// ListHeaders returns the List-Unsubscribe and List-Unsubscribe-Post header fields for the
+// email's unsubscribe links, which are empty if it has none.  List-Unsubscribe-Post is only
+// given for an https UnsubscribeURL.
+func (e *Email) ListHeaders() map[string]string {
+	headers := map[string]string{}
+	links := []string{}
+
+	if e.UnsubscribeMailto != "" {
+		mailto := e.UnsubscribeMailto
+		if !strings.HasPrefix(strings.ToLower(mailto), "mailto:") {
+			mailto = "mailto:" + mailto
+		}
+		links = append(links, "<"+mailto+">")
+	}
+
+	if e.UnsubscribeURL != "" {
+		links = append(links, "<"+e.UnsubscribeURL+">")
+
+		if strings.HasPrefix(strings.ToLower(e.UnsubscribeURL), "https://") {
+			headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+		}
+	}
+
+	if len(links) > 0 {
+		headers["List-Unsubscribe"] = strings.Join(links, ", ")
+	}
+
+	return headers
+}
+
+// Clone returns a copy of the email that can have its recipients, headers, tags and
+// template context changed without affecting the original.  Recipients are shared between
+// the two, as are attachments unless their data can be read from any offset (such as a
+// bytes.Reader), in which case the clone gets its own reader over it.
+func (e *Email) Clone() *Email {
+	clone := *e
+
+	clone.To = append([]*Recipient(nil), e.To...)
+	clone.Cc = append([]*Recipient(nil), e.Cc...)
+	clone.Bcc = append([]*Recipient(nil), e.Bcc...)
+	clone.Tags = append([]string(nil), e.Tags...)
+	clone.References = append([]string(nil), e.References...)
+
+	clone.Headers = url.Values{}
+	for k, v := range e.Headers {
+		clone.Headers[k] = append([]string(nil), v...)
+	}
+
+	if e.TemplateContext != nil {
+		clone.TemplateContext = make(map[string]string, len(e.TemplateContext))
+		for k, v := range e.TemplateContext {
+			clone.TemplateContext[k] = v
+		}
+	}
+
+	clone.Attachments = make([]*Attachment, 0, len(e.Attachments))
+	for _, attachment := range e.Attachments {
+		if data, ok := attachment.Data.(sizedReaderAt); ok {
+			copied := *attachment
+			copied.Data = io.NewSectionReader(data, 0, data.Size())
+			attachment = &copied
+		}
+		clone.Attachments = append(clone.Attachments, attachment)
+	}
+
+	return &clone
+}
+
+// sizedReaderAt is implemented by bytes.Reader, strings.Reader and io.SectionReader.
+type sizedReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// BufferAttachments reads the data of every attachment into memory, so that clones of the
+// email can each read it again.  Use it before sending clones of an email more than once.
+//
+// Attachments are replaced with copies whose Data is a bytes.Reader, so the *Attachment
+// values the email shares with others are left as they were, but their Data has been
+// read to the end.
+func (e *Email) BufferAttachments() error {
+	for i, attachment := range e.Attachments {
+		if _, ok := attachment.Data.(sizedReaderAt); ok || attachment.Data == nil {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(attachment.Data)
+		if err != nil {
+			return fmt.Errorf("failed to read %s attachment: %s", attachment.Name, err)
+		}
+
+		copied := *attachment
+		copied.Data = bytes.NewReader(data)
+		e.Attachments[i] = &copied
+	}
+
+	return nil
+}
+
+// AllAttachments returns the email's attachments, followed by the invitation for its Event
+// if it has one.
+func (e *Email) AllAttachments() ([]*Attachment, error) {
+	if e.Event == nil {
+		return e.Attachments, nil
+	}
+
+	invite, err := e.Event.Attachment()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(e.Attachments[:len(e.Attachments):len(e.Attachments)], invite), nil
 }
 
 // AddAttachment is a convenience method for adding attachments to the message
 func (e *Email) AddAttachment(name, mimetype string, data io.Reader) {
-	e.Attachments = append(e.Attachments, &Attachment{name, mimetype, data})
+	e.Attachments = append(e.Attachments, &Attachment{Name: name, Mimetype: mimetype, Data: data})
+}
+
+// AddInlineAttachment adds an attachment that's displayed within the HTML body rather than
+// offered as a download, referenced from it as cid:<contentID> (eg <img src="cid:logo">).
+func (e *Email) AddInlineAttachment(contentID, name, mimetype string, data io.Reader) {
+	e.Attachments = append(e.Attachments, &Attachment{
+		Name:        name,
+		Mimetype:    mimetype,
+		Data:        data,
+		ContentID:   contentID,
+		Disposition: DispositionInline,
+	})
 }
 
 // AddRecipient is a convenience method for adding recipients to the message
@@ -79,10 +237,54 @@ func (e *Email) AddRecipient(name, email string, tmplCtx map[string]string) {
 	})
 }
 
+// Attachment dispositions
+const (
+	DispositionAttachment = "attachment"
+	DispositionInline     = "inline"
+)
+
 // Attachment represents a piece of data to be attached to an email.
 type Attachment struct {
 	Name, Mimetype string
 	Data           io.Reader
+
+	// ContentID identifies the attachment to references in the HTML body, without the
+	// angle brackets: an image with ContentID "logo" is shown by <img src="cid:logo">.
+	ContentID string
+
+	// Disposition is DispositionAttachment (the default when empty) or DispositionInline.
+	Disposition string
+}
+
+// Inline reports whether the attachment is displayed within the body of the email.
+func (a *Attachment) Inline() bool {
+	return a.Disposition == DispositionInline
+}
+
+// Size reports how many bytes of data the attachment has left to read, or -1 if that
+// can't be determined without consuming the data.
+func (a *Attachment) Size() int64 {
+	switch data := a.Data.(type) {
+	case interface {
+		Len() int
+	}:
+		return int64(data.Len())
+	case io.Seeker:
+		cur, err := data.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := data.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		if _, err := data.Seek(cur, io.SeekStart); err != nil {
+			return -1
+		}
+		return end - cur
+	}
+
+	return -1
 }
 
 // Recipient represents a single recipient in an email.
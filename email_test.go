@@ -1,6 +1,8 @@
 package ego
 
 import (
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -19,6 +21,17 @@ func TestEmailAddAttachment(t *testing.T) {
 	}
 }
 
+// TestEmailAddInlineAttachment checks that the AddInlineAttachment method of Email is working.
+func TestEmailAddInlineAttachment(t *testing.T) {
+	e := NewEmail()
+
+	e.AddInlineAttachment("logo", "logo.png", "image/png", nil)
+
+	if len(e.Attachments) != 1 || !e.Attachments[0].Inline() || e.Attachments[0].ContentID != "logo" {
+		t.FailNow()
+	}
+}
+
 // TestEmailAddRecipient checks that the AddRecipient method of Email is working.
 func TestEmailAddRecipient(t *testing.T) {
 	e := NewEmail()
@@ -49,3 +62,94 @@ func TestEmailAddRecipient(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// TestAttachmentSize checks that attachment sizes are measured without consuming the data.
+func TestAttachmentSize(t *testing.T) {
+	a := &Attachment{Name: "test-attachment", Mimetype: "text/plain", Data: strings.NewReader("hello")}
+
+	if a.Size() != 5 {
+		t.FailNow()
+	}
+
+	data, err := ioutil.ReadAll(a.Data)
+	if err != nil || string(data) != "hello" {
+		t.FailNow()
+	}
+
+	a.Data = ioutil.NopCloser(nil)
+	if a.Size() != -1 {
+		t.FailNow()
+	}
+}
+
+// TestEmailClone checks that changes to a clone don't leak into the original.
+func TestEmailClone(t *testing.T) {
+	e := NewEmail()
+	e.AddRecipient("test recipient", "test@test.com", nil)
+	e.Headers.Set("hello", "world")
+	e.TemplateContext["hello"] = "world"
+
+	clone := e.Clone()
+	clone.AddRecipient("other recipient", "other@test.com", nil)
+	clone.Headers.Set("hello", "there")
+	clone.TemplateContext["hello"] = "there"
+
+	if len(e.To) != 1 || len(clone.To) != 2 {
+		t.FailNow()
+	}
+
+	if e.Headers.Get("hello") != "world" || e.TemplateContext["hello"] != "world" {
+		t.FailNow()
+	}
+}
+
+// TestEmailCloneAttachments checks that buffered attachments can be read by every clone.
+func TestEmailCloneAttachments(t *testing.T) {
+	e := NewEmail()
+	e.AddAttachment("test-attachment", "text/plain", ioutil.NopCloser(strings.NewReader("hello")))
+	shared := e.Attachments[0]
+
+	if err := e.BufferAttachments(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the attachment is buffered into a copy
+	if e.Attachments[0] == shared || e.Attachments[0].Data == shared.Data {
+		t.FailNow()
+	}
+
+	for i := 0; i < 2; i++ {
+		data, err := ioutil.ReadAll(e.Clone().Attachments[0].Data)
+		if err != nil || string(data) != "hello" {
+			t.FailNow()
+		}
+	}
+}
+
+// TestEmailListHeaders checks the List-Unsubscribe headers for each kind of link.
+func TestEmailListHeaders(t *testing.T) {
+	e := NewEmail()
+
+	if len(e.ListHeaders()) != 0 {
+		t.FailNow()
+	}
+
+	e.UnsubscribeMailto = "unsubscribe@example.com?subject=unsubscribe"
+	headers := e.ListHeaders()
+	if headers["List-Unsubscribe"] != "<mailto:unsubscribe@example.com?subject=unsubscribe>" || len(headers) != 1 {
+		t.Fatal(headers)
+	}
+
+	e.UnsubscribeURL = "https://example.com/unsubscribe?token=abc"
+	headers = e.ListHeaders()
+	if headers["List-Unsubscribe"] != "<mailto:unsubscribe@example.com?subject=unsubscribe>, <https://example.com/unsubscribe?token=abc>" ||
+		headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Fatal(headers)
+	}
+
+	// one-click unsubscribes need https
+	e.UnsubscribeURL = "http://example.com/unsubscribe"
+	if _, ok := e.ListHeaders()["List-Unsubscribe-Post"]; ok {
+		t.FailNow()
+	}
+}
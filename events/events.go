@@ -0,0 +1,119 @@
+// Delivery events
+//
+// Receives providers' webhooks about what happened to sent emails (deliveries, bounces,
+// opens, complaints and so on), verifies that they came from the provider, and hands them
+// on in a single normalized form.  Mandrill, SendGrid and PostageApp are supported.
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// MaxBodySize is the size of the largest webhook the handlers read, so that requests that
+// haven't been authenticated yet can't use up memory.
+const MaxBodySize = 10 << 20
+
+// Type is what happened to an email.
+type Type string
+
+const (
+	// Delivered means the recipient's mail server accepted the email.
+	Delivered Type = "delivered"
+
+	// HardBounce means the email can never be delivered, such as to an address that
+	// doesn't exist.  SoftBounce means it couldn't be delivered this time, such as to a
+	// full mailbox, and the provider gave up.
+	HardBounce Type = "hard_bounce"
+	SoftBounce Type = "soft_bounce"
+
+	// Deferred means delivery failed for now and will be retried.
+	Deferred Type = "deferred"
+
+	Opened  Type = "opened"
+	Clicked Type = "clicked"
+
+	// SpamComplaint means the recipient marked the email as spam.
+	SpamComplaint Type = "spam_complaint"
+
+	// Unsubscribed means the recipient unsubscribed through the provider.
+	Unsubscribed Type = "unsubscribed"
+
+	// Rejected means the provider refused to send the email, such as to an address on
+	// its own suppression list.
+	Rejected Type = "rejected"
+)
+
+// Event is something that happened to an email sent to a recipient.
+type Event struct {
+	Type Type
+
+	// ID identifies the event, so that one delivered again by a retried webhook can be
+	// recognized.  It's the provider's own identifier when it gives one.
+	ID string
+
+	// Provider is the name of the backend that sent the email, such as "mandrill".
+	Provider string
+
+	Recipient string
+	Timestamp time.Time
+
+	// MessageID is the identifier the provider gave the message, as in the
+	// backends.Result of its send when the provider reported one there.
+	MessageID string
+
+	// Tags of the email, for providers that report them.
+	Tags []string
+
+	// URL is the link that was followed, for Clicked events.
+	URL string
+
+	// Reason is the provider's explanation of bounces, deferrals and rejections.
+	Reason string
+
+	// Raw is the provider's own description of the event.
+	Raw json.RawMessage
+}
+
+// Callback is given the events of a webhook, one at a time.  If it returns an error, the
+// webhook is answered with a server error so that the provider sends it again, including
+// the events that were already handled; callbacks should cope with seeing an event twice.
+type Callback func(ctx context.Context, ev *Event) error
+
+// readBody reads the request's body, up to MaxBodySize.  If it can't, the webhook is
+// answered and ok is false.
+func readBody(w http.ResponseWriter, r *http.Request) (body []byte, ok bool) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		bodyError(w, err)
+		return nil, false
+	}
+	return body, true
+}
+
+// bodyError answers a webhook whose body couldn't be read.
+func bodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "webhook too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "failed to read webhook", http.StatusBadRequest)
+}
+
+// emit hands the events to fn, answering the webhook.
+func emit(w http.ResponseWriter, r *http.Request, fn Callback, events []*Event) {
+	for _, ev := range events {
+		if err := fn(r.Context(), ev); err != nil {
+			http.Error(w, "failed to handle event", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,156 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MandrillConfig describes a Mandrill webhook.
+type MandrillConfig struct {
+	// Key is the webhook's key, shown in Mandrill's webhook settings.
+	Key string
+
+	// URL is the webhook's URL exactly as it was entered in Mandrill, which it signs.
+	URL string
+}
+
+// mandrillTypes maps mandrill's events to ours.  Others, such as its notices of changes to
+// the rejection list, are skipped.
+var mandrillTypes = map[string]Type{
+	"send":        Delivered,
+	"deferral":    Deferred,
+	"hard_bounce": HardBounce,
+	"soft_bounce": SoftBounce,
+	"open":        Opened,
+	"click":       Clicked,
+	"spam":        SpamComplaint,
+	"unsub":       Unsubscribed,
+	"reject":      Rejected,
+}
+
+type mandrillEvent struct {
+	Event string `json:"event"`
+	TS    int64  `json:"ts"`
+	URL   string `json:"url"`
+	Msg   struct {
+		ID                string   `json:"_id"`
+		Email             string   `json:"email"`
+		Tags              []string `json:"tags"`
+		BounceDescription string   `json:"bounce_description"`
+		Diag              string   `json:"diag"`
+		SMTPEvents        []struct {
+			Diag string `json:"diag"`
+		} `json:"smtp_events"`
+	} `json:"msg"`
+}
+
+// NewMandrillHandler returns a handler for Mandrill's webhook, which checks the
+// X-Mandrill-Signature of every request and hands its events to fn.
+func NewMandrillHandler(c MandrillConfig, fn Callback) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// mandrill checks that the webhook exists with a HEAD request
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+		if err := r.ParseForm(); err != nil {
+			bodyError(w, err)
+			return
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Mandrill-Signature"))
+		if err != nil || c.Key == "" || !hmac.Equal(signature, mandrillSignature(c, r)) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		events, err := parseMandrill([]byte(r.PostForm.Get("mandrill_events")))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		emit(w, r, fn, events)
+	})
+}
+
+// mandrillSignature signs the webhook's URL followed by each POST parameter's name and
+// value, in order of their names.
+func mandrillSignature(c MandrillConfig, r *http.Request) []byte {
+	names := make([]string, 0, len(r.PostForm))
+	for name := range r.PostForm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mac := hmac.New(sha1.New, []byte(c.Key))
+	mac.Write([]byte(c.URL))
+	for _, name := range names {
+		mac.Write([]byte(name + r.PostForm.Get(name)))
+	}
+
+	return mac.Sum(nil)
+}
+
+func parseMandrill(data []byte) ([]*Event, error) {
+	raws := []json.RawMessage{}
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, fmt.Errorf("invalid mandrill events: %s", err)
+	}
+
+	events := make([]*Event, 0, len(raws))
+	for _, raw := range raws {
+		me := &mandrillEvent{}
+		if err := json.Unmarshal(raw, me); err != nil {
+			return nil, fmt.Errorf("invalid mandrill event: %s", err)
+		}
+
+		typ, ok := mandrillTypes[me.Event]
+		if !ok {
+			continue
+		}
+
+		// mandrill's events have no identifier of their own, so one is made of the
+		// message's, the kind of event and its time
+		ev := &Event{
+			Type:      typ,
+			ID:        me.Msg.ID + "/" + me.Event + "/" + strconv.FormatInt(me.TS, 10),
+			Provider:  "mandrill",
+			Recipient: me.Msg.Email,
+			Timestamp: time.Unix(me.TS, 0),
+			MessageID: me.Msg.ID,
+			Tags:      me.Msg.Tags,
+			URL:       me.URL,
+			Raw:       raw,
+		}
+
+		switch {
+		case me.Msg.Diag != "":
+			ev.Reason = me.Msg.Diag
+		case len(me.Msg.SMTPEvents) > 0:
+			ev.Reason = me.Msg.SMTPEvents[len(me.Msg.SMTPEvents)-1].Diag
+		}
+		if ev.Reason == "" {
+			ev.Reason = me.Msg.BounceDescription
+		}
+
+		events = append(events, ev)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const mandrillEvents = `[
+	{"event":"send","ts":1700000000,"msg":{"_id":"abc123","email":"zane@anastacio.co.uk","tags":["welcome"]}},
+	{"event":"hard_bounce","ts":1700000060,"msg":{"_id":"abc124","email":"retta@fletcher.biz","bounce_description":"bad_mailbox","diag":"smtp;550 5.1.1 User unknown"}},
+	{"event":"click","ts":1700000120,"url":"https://austen.name/","msg":{"_id":"abc123","email":"zane@anastacio.co.uk"}},
+	{"type":"whitelist","action":"add","entry":{"email":"zane@anastacio.co.uk"}}
+]`
+
+// mandrillRequest signs a webhook like mandrill does.
+func mandrillRequest(c MandrillConfig, events string) *http.Request {
+	mac := hmac.New(sha1.New, []byte(c.Key))
+	mac.Write([]byte(c.URL + "mandrill_events" + events))
+
+	form := url.Values{"mandrill_events": {events}}
+	r := httptest.NewRequest("POST", c.URL, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Mandrill-Signature", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+// TestMandrillHandler checks that signed webhooks are normalized and others refused.
+func TestMandrillHandler(t *testing.T) {
+	c := MandrillConfig{Key: "webhook-key", URL: "https://austen.name/webhooks/mandrill"}
+
+	events := []*Event{}
+	h := NewMandrillHandler(c, func(ctx context.Context, ev *Event) error {
+		events = append(events, ev)
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, mandrillRequest(c, mandrillEvents))
+	if w.Code != 200 || len(events) != 3 {
+		t.Fatal(w.Code, events)
+	}
+
+	if events[0].Type != Delivered || events[0].Provider != "mandrill" || events[0].MessageID != "abc123" ||
+		events[0].Recipient != "zane@anastacio.co.uk" || events[0].Tags[0] != "welcome" || events[0].Timestamp.Unix() != 1700000000 {
+		t.Fatal(events[0])
+	}
+
+	if events[1].Type != HardBounce || events[1].Reason != "smtp;550 5.1.1 User unknown" || events[1].ID != "abc124/hard_bounce/1700000060" {
+		t.Fatal(events[1])
+	}
+
+	if events[2].Type != Clicked || events[2].URL != "https://austen.name/" {
+		t.Fatal(events[2])
+	}
+
+	// signed for another URL
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, mandrillRequest(MandrillConfig{Key: c.Key, URL: "https://elsewhere.com/"}, mandrillEvents))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code)
+	}
+
+	// mandrill's check that the webhook exists
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("HEAD", c.URL, nil))
+	if w.Code != 200 {
+		t.Fatal(w.Code)
+	}
+}
+
+// TestCallbackError checks that the provider is asked to retry when the callback fails.
+func TestCallbackError(t *testing.T) {
+	c := MandrillConfig{Key: "webhook-key", URL: "https://austen.name/webhooks/mandrill"}
+
+	h := NewMandrillHandler(c, func(ctx context.Context, ev *Event) error {
+		return errors.New("database is down")
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, mandrillRequest(c, mandrillEvents))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatal(w.Code)
+	}
+}
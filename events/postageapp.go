@@ -0,0 +1,139 @@
+package events
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PostageAppConfig describes a PostageApp webhook.  PostageApp doesn't sign its webhooks,
+// so they're authenticated by a secret only PostageApp knows: a "secret" parameter in the
+// webhook's URL, such as https://ourdomain.com/webhooks/postageapp?secret=..., or a
+// username and password in it for basic authentication.  Requests must match every one
+// that's set, and are refused when none is.  Either way the webhook should be served over
+// HTTPS so that the secret stays one.
+type PostageAppConfig struct {
+	Secret string
+
+	Username, Password string
+}
+
+// postageAppTypes maps postageapp's events to ours.
+var postageAppTypes = map[string]Type{
+	"delivered":    Delivered,
+	"deferred":     Deferred,
+	"hard_bounce":  HardBounce,
+	"soft_bounce":  SoftBounce,
+	"opened":       Opened,
+	"clicked":      Clicked,
+	"complained":   SpamComplaint,
+	"unsubscribed": Unsubscribed,
+	"rejected":     Rejected,
+}
+
+type postageAppEvent struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	Recipient string      `json:"recipient"`
+	Timestamp int64       `json:"timestamp"`
+	MessageID json.Number `json:"message_id"`
+	Tags      []string    `json:"tags"`
+	URL       string      `json:"url"`
+	Reason    string      `json:"reason"`
+}
+
+// NewPostageAppHandler returns a handler for PostageApp's webhook, which checks the secret
+// of every request (see PostageAppConfig) and hands its events to fn.
+func NewPostageAppHandler(c PostageAppConfig, fn Callback) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authenticPostageApp(c, r) {
+			if c.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="postageapp"`)
+			}
+			http.Error(w, "invalid secret", http.StatusUnauthorized)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		events, err := parsePostageApp(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		emit(w, r, fn, events)
+	})
+}
+
+// authenticPostageApp checks the request's secret and basic authentication, comparing them
+// in constant time.
+func authenticPostageApp(c PostageAppConfig, r *http.Request) bool {
+	if c.Secret == "" && c.Username == "" {
+		return false
+	}
+
+	if c.Secret != "" && !hmac.Equal([]byte(r.URL.Query().Get("secret")), []byte(c.Secret)) {
+		return false
+	}
+
+	if c.Username != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || !hmac.Equal([]byte(username), []byte(c.Username)) || !hmac.Equal([]byte(password), []byte(c.Password)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parsePostageApp reads a webhook's events, which postageapp sends as a list, or on their
+// own one at a time.
+func parsePostageApp(data []byte) ([]*Event, error) {
+	raws := []json.RawMessage{}
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		raws = append(raws, json.RawMessage(trimmed))
+	} else if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, fmt.Errorf("invalid postageapp events: %s", err)
+	}
+
+	events := make([]*Event, 0, len(raws))
+	for _, raw := range raws {
+		pe := &postageAppEvent{}
+		if err := json.Unmarshal(raw, pe); err != nil {
+			return nil, fmt.Errorf("invalid postageapp event: %s", err)
+		}
+
+		typ, ok := postageAppTypes[pe.Event]
+		if !ok {
+			continue
+		}
+
+		events = append(events, &Event{
+			Type:      typ,
+			ID:        pe.ID,
+			Provider:  "postageapp",
+			Recipient: pe.Recipient,
+			Timestamp: time.Unix(pe.Timestamp, 0),
+			MessageID: pe.MessageID.String(),
+			Tags:      pe.Tags,
+			URL:       pe.URL,
+			Reason:    pe.Reason,
+			Raw:       raw,
+		})
+	}
+
+	return events, nil
+}
@@ -0,0 +1,102 @@
+package events
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const postageAppEvents = `[
+	{"event":"delivered","recipient":"zane@anastacio.co.uk","timestamp":1700000000,"message_id":123,"tags":["welcome"]},
+	{"id":"ev2","event":"soft_bounce","recipient":"retta@fletcher.biz","timestamp":1700000060,"message_id":123,"reason":"452 mailbox full"},
+	{"event":"queued","recipient":"zane@anastacio.co.uk","timestamp":1700000000,"message_id":123}
+]`
+
+// TestPostageAppHandler checks that webhooks with the secret are normalized and others
+// refused.
+func TestPostageAppHandler(t *testing.T) {
+	events := []*Event{}
+	h := NewPostageAppHandler(PostageAppConfig{Secret: "s3cret"}, func(ctx context.Context, ev *Event) error {
+		events = append(events, ev)
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/postageapp?secret=s3cret", strings.NewReader(postageAppEvents)))
+	if w.Code != 200 || len(events) != 2 {
+		t.Fatal(w.Code, events)
+	}
+
+	if events[0].Type != Delivered || events[0].Provider != "postageapp" || events[0].MessageID != "123" ||
+		events[0].Recipient != "zane@anastacio.co.uk" || events[0].Tags[0] != "welcome" || events[0].Timestamp.Unix() != 1700000000 {
+		t.Fatal(events[0])
+	}
+
+	if events[1].Type != SoftBounce || events[1].Reason != "452 mailbox full" || events[1].ID != "ev2" {
+		t.Fatal(events[1])
+	}
+
+	// a single event
+	events = events[:0]
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/postageapp?secret=s3cret", strings.NewReader(` {"event":"opened","recipient":"zane@anastacio.co.uk"}`)))
+	if w.Code != 200 || len(events) != 1 || events[0].Type != Opened {
+		t.Fatal(w.Code, events)
+	}
+
+	for _, target := range []string{"/webhooks/postageapp", "/webhooks/postageapp?secret=guess"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", target, strings.NewReader(postageAppEvents)))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatal(target, w.Code)
+		}
+	}
+}
+
+// TestPostageAppBasicAuth checks webhooks authenticated with a username and password.
+func TestPostageAppBasicAuth(t *testing.T) {
+	h := NewPostageAppHandler(PostageAppConfig{Username: "postageapp", Password: "s3cret"}, func(ctx context.Context, ev *Event) error {
+		return nil
+	})
+
+	for password, code := range map[string]int{"s3cret": 200, "guess": http.StatusUnauthorized} {
+		r := httptest.NewRequest("POST", "/webhooks/postageapp", strings.NewReader(postageAppEvents))
+		r.SetBasicAuth("postageapp", password)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatal(password, w.Code)
+		}
+	}
+
+	// nothing to check requests against
+	w := httptest.NewRecorder()
+	NewPostageAppHandler(PostageAppConfig{}, nil).ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/postageapp?secret=", strings.NewReader(postageAppEvents)))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code)
+	}
+}
+
+// TestMaxBodySize checks that webhooks too large to be read are refused before they're
+// authenticated.
+func TestMaxBodySize(t *testing.T) {
+	large := strings.Repeat(" ", MaxBodySize+1)
+
+	for name, h := range map[string]http.Handler{
+		"sendgrid":   NewSendGridHandler(SendGridConfig{}, nil),
+		"mandrill":   NewMandrillHandler(MandrillConfig{Key: "webhook-key"}, nil),
+		"postageapp": NewPostageAppHandler(PostageAppConfig{Secret: "s3cret"}, nil),
+	} {
+		r := httptest.NewRequest("POST", "/webhook?secret=s3cret", strings.NewReader(large))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatal(name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package events
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SendGridConfig describes a SendGrid event webhook, which must have signing enabled.
+type SendGridConfig struct {
+	// PublicKey is the verification key shown in the webhook's security settings, see
+	// ParseSendGridKey.
+	PublicKey *ecdsa.PublicKey
+
+	// MaxAge is how old a request's signed timestamp may be, so that captured requests
+	// can't be replayed later.  Zero accepts any age.
+	MaxAge time.Duration
+}
+
+// ParseSendGridKey parses the verification key of a signed event webhook, as SendGrid
+// shows it (base64 encoded DER).
+func ParseSendGridKey(key string) (*ecdsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sendgrid key: %s", err)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sendgrid key: %s", err)
+	}
+
+	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("invalid sendgrid key: not an ECDSA key")
+	}
+
+	return ecdsaPub, nil
+}
+
+// sendGridTypes maps sendgrid's events to ours.  "processed" and resubscribes are skipped,
+// and bounces are split up by their type.
+var sendGridTypes = map[string]Type{
+	"delivered":         Delivered,
+	"deferred":          Deferred,
+	"open":              Opened,
+	"click":             Clicked,
+	"spamreport":        SpamComplaint,
+	"unsubscribe":       Unsubscribed,
+	"group_unsubscribe": Unsubscribed,
+	"dropped":           Rejected,
+}
+
+type sendGridEvent struct {
+	ID        string          `json:"sg_event_id"`
+	Event     string          `json:"event"`
+	Email     string          `json:"email"`
+	Timestamp int64           `json:"timestamp"`
+	MessageID string          `json:"sg_message_id"`
+	URL       string          `json:"url"`
+	Type      string          `json:"type"`
+	Reason    string          `json:"reason"`
+	Response  string          `json:"response"`
+	Category  json.RawMessage `json:"category"`
+}
+
+// NewSendGridHandler returns a handler for SendGrid's event webhook, which checks the
+// signature of every request and hands its events to fn.
+func NewSendGridHandler(c SendGridConfig, fn Callback) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+
+		if err := verifySendGrid(c, r.Header, body); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		events, err := parseSendGrid(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		emit(w, r, fn, events)
+	})
+}
+
+// verifySendGrid checks the ECDSA signature of the timestamp header followed by the body.
+func verifySendGrid(c SendGridConfig, header http.Header, body []byte) error {
+	timestamp := header.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+
+	signature, err := base64.StdEncoding.DecodeString(header.Get("X-Twilio-Email-Event-Webhook-Signature"))
+	if err != nil || c.PublicKey == nil || timestamp == "" {
+		return errors.New("invalid signature")
+	}
+
+	digest := sha256.Sum256(append([]byte(timestamp), body...))
+	if !ecdsa.VerifyASN1(c.PublicKey, digest[:], signature) {
+		return errors.New("invalid signature")
+	}
+
+	if c.MaxAge > 0 {
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(seconds, 0)) > c.MaxAge {
+			return errors.New("expired signature")
+		}
+	}
+
+	return nil
+}
+
+func parseSendGrid(data []byte) ([]*Event, error) {
+	raws := []json.RawMessage{}
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, fmt.Errorf("invalid sendgrid events: %s", err)
+	}
+
+	events := make([]*Event, 0, len(raws))
+	for _, raw := range raws {
+		se := &sendGridEvent{}
+		if err := json.Unmarshal(raw, se); err != nil {
+			return nil, fmt.Errorf("invalid sendgrid event: %s", err)
+		}
+
+		typ, ok := sendGridTypes[se.Event]
+		if se.Event == "bounce" {
+			// blocks are bounces the receiving server may accept another time
+			typ, ok = HardBounce, true
+			if se.Type == "blocked" {
+				typ = SoftBounce
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		ev := &Event{
+			Type:      typ,
+			ID:        se.ID,
+			Provider:  "sendgrid",
+			Recipient: se.Email,
+			Timestamp: time.Unix(se.Timestamp, 0),
+			MessageID: se.MessageID,
+			Tags:      categories(se.Category),
+			URL:       se.URL,
+			Reason:    se.Reason,
+			Raw:       raw,
+		}
+		if ev.Reason == "" {
+			ev.Reason = se.Response
+		}
+
+		events = append(events, ev)
+	}
+
+	return events, nil
+}
+
+// categories reads an event's categories, which sendgrid gives as a string when there's
+// only one of them.
+func categories(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	list := []string{}
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+
+	single := ""
+	if err := json.Unmarshal(raw, &single); err == nil && single != "" {
+		return []string{single}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sendGridEvents = `[
+	{"email":"zane@anastacio.co.uk","timestamp":1700000000,"event":"processed","sg_message_id":"m1.filter"},
+	{"email":"zane@anastacio.co.uk","timestamp":1700000001,"event":"delivered","sg_message_id":"m1.filter","category":"welcome"},
+	{"email":"retta@fletcher.biz","timestamp":1700000002,"event":"bounce","type":"blocked","reason":"421 try again later","sg_message_id":"m2.filter","sg_event_id":"ev2"},
+	{"email":"freida@orpha.info","timestamp":1700000003,"event":"bounce","type":"bounce","reason":"550 no such user","sg_message_id":"m3.filter"},
+	{"email":"corrine@remington.io","timestamp":1700000004,"event":"spamreport","category":["a","b"],"sg_message_id":"m4.filter"}
+]`
+
+// sendGridRequest signs a webhook like sendgrid does.
+func sendGridRequest(t *testing.T, key *ecdsa.PrivateKey, timestamp time.Time, events string) *http.Request {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	digest := sha256.Sum256([]byte(ts + events))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/webhooks/sendgrid", strings.NewReader(events))
+	r.Header.Set("X-Twilio-Email-Event-Webhook-Timestamp", ts)
+	r.Header.Set("X-Twilio-Email-Event-Webhook-Signature", base64.StdEncoding.EncodeToString(signature))
+	return r
+}
+
+// TestSendGridHandler checks that signed webhooks are normalized and others refused.
+func TestSendGridHandler(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	pub, err := ParseSendGridKey(base64.StdEncoding.EncodeToString(der))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []*Event{}
+	h := NewSendGridHandler(SendGridConfig{PublicKey: pub, MaxAge: time.Hour}, func(ctx context.Context, ev *Event) error {
+		events = append(events, ev)
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, sendGridRequest(t, key, time.Now(), sendGridEvents))
+	if w.Code != 200 || len(events) != 4 {
+		t.Fatal(w.Code, events)
+	}
+
+	if events[0].Type != Delivered || events[0].Provider != "sendgrid" || events[0].MessageID != "m1.filter" ||
+		len(events[0].Tags) != 1 || events[0].Tags[0] != "welcome" {
+		t.Fatal(events[0])
+	}
+
+	if events[1].Type != SoftBounce || events[1].Reason != "421 try again later" || events[1].ID != "ev2" || events[2].Type != HardBounce {
+		t.Fatal(events[1], events[2])
+	}
+
+	if events[3].Type != SpamComplaint || len(events[3].Tags) != 2 {
+		t.Fatal(events[3])
+	}
+
+	// tampered with
+	r := sendGridRequest(t, key, time.Now(), sendGridEvents)
+	r.Body = http.NoBody
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code)
+	}
+
+	// replayed
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, sendGridRequest(t, key, time.Now().Add(-2*time.Hour), sendGridEvents))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code)
+	}
+}
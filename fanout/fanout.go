@@ -0,0 +1,151 @@
+// Fan-out middleware
+//
+// Splits an email into one email per recipient, each with that recipient's TemplateContext,
+// for backends whose provider can't personalize a single send per recipient.
+
+package fanout
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+)
+
+// DefaultConcurrency is how many sends are made at once when Config.Concurrency isn't set.
+const DefaultConcurrency = 4
+
+// Config describes how emails are fanned out.
+type Config struct {
+	// Maximum number of sends in flight at once for a single email.
+	Concurrency int
+
+	// Fan out even if the backend can personalize sends itself.
+	Always bool
+}
+
+// NewMiddleware returns a middleware that fans emails out per recipient before they reach
+// the wrapped backend.  Emails are only split up if some recipient has a TemplateContext
+// and the backend isn't a backends.Personalizer (unless Config.Always is set).
+//
+// Every recipient, whether in To, Cc or Bcc, gets their own email addressed to them
+// alone, whose TemplateContext is the original's with the recipient's own on top.
+// The result lists the outcome for each recipient, and a *backends.PartialError is
+// returned if any of them failed.
+func NewMiddleware(c Config) backends.Middleware {
+	if c.Concurrency <= 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+
+	return func(next backends.Backend) backends.Backend {
+		var personalizer backends.Personalizer
+		personalizes := backends.As(next, &personalizer) && personalizer.SupportsPersonalization()
+
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if (personalizes && !c.Always) || !NeedsSplit(e) {
+				return next.SendEmail(ctx, e)
+			}
+
+			emails, err := Split(e)
+			if err != nil {
+				return nil, err
+			}
+
+			return backends.SendAll(ctx, next, emails, c.Concurrency)
+		})
+	}
+}
+
+// NeedsSplit reports whether the email has several recipients, some with their own
+// TemplateContext, so that it must be split up to give each of them their own.
+func NeedsSplit(e *ego.Email) bool {
+	if len(e.To)+len(e.Cc)+len(e.Bcc) < 2 {
+		return false
+	}
+
+	for _, recipients := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+		for _, recip := range recipients {
+			if len(recip.TemplateContext) > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Split returns a copy of the email for each of its recipients, as described on NewMiddleware.
+// The attachments are read into memory so that every copy can send them.  Each copy's
+// IdempotencyKey is the original's followed by "/" and the recipient's address, so that
+// a middleware deduplicating sends treats the copies apart but still recognizes a retry.
+func Split(e *ego.Email) ([]*ego.Email, error) {
+	e = e.Clone()
+	if err := e.BufferAttachments(); err != nil {
+		return nil, backends.NotSent(err)
+	}
+
+	emails := []*ego.Email{}
+
+	for _, recipients := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+		for _, recip := range recipients {
+			single := e.Clone()
+			single.To = []*ego.Recipient{recip}
+			single.Cc = []*ego.Recipient{}
+			single.Bcc = []*ego.Recipient{}
+
+			if e.IdempotencyKey != "" {
+				single.IdempotencyKey = e.IdempotencyKey + "/" + recip.Email.Address
+			}
+
+			if single.TemplateContext == nil {
+				single.TemplateContext = make(map[string]string)
+			}
+			for k, v := range recip.TemplateContext {
+				single.TemplateContext[k] = v
+			}
+
+			emails = append(emails, single)
+		}
+	}
+
+	return emails, nil
+}
+
+// SendEach sends the email through b with fn applied to each recipient's copy, for
+// middleware that gives every recipient something of their own, such as a link or a
+// return path.  An email to several recipients is split up with Split and the copies are
+// sent with at most concurrency in flight (DefaultConcurrency when it's zero), so the
+// result lists the outcome for each recipient.  An email to a single recipient is copied
+// but not split up, and one without recipients is sent as it is.
+//
+// If fn fails, nothing is sent and its error is returned.
+func SendEach(ctx context.Context, b backends.Backend, e *ego.Email, concurrency int,
+	fn func(single *ego.Email, recip *ego.Recipient) error) (*backends.Result, error) {
+
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	emails := []*ego.Email{e.Clone()}
+	if len(e.To)+len(e.Cc)+len(e.Bcc) > 1 {
+		var err error
+		if emails, err = Split(e); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, single := range emails {
+		for _, recipients := range [][]*ego.Recipient{single.To, single.Cc, single.Bcc} {
+			if len(recipients) > 0 {
+				if err := fn(single, recipients[0]); err != nil {
+					return nil, backends.NotSent(err)
+				}
+				break
+			}
+		}
+	}
+
+	if len(emails) == 1 {
+		return b.SendEmail(ctx, emails[0])
+	}
+	return backends.SendAll(ctx, b, emails, concurrency)
+}
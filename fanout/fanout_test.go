@@ -0,0 +1,187 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type captureBackend struct {
+	mu           sync.Mutex
+	sent         []*ego.Email
+	fail         string
+	personalizes bool
+}
+
+func (c *captureBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent = append(c.sent, e)
+
+	if e.To[0].Email.Address == c.fail {
+		return nil, errors.New("boom")
+	}
+
+	return &backends.Result{MessageID: "id-" + e.To[0].Email.Address}, nil
+}
+
+func (c *captureBackend) SupportsPersonalization() bool {
+	return c.personalizes
+}
+
+// TestFanout checks that each recipient gets their own email and context.
+func TestFanout(t *testing.T) {
+	capture := &captureBackend{}
+	b := backends.Chain(capture, NewMiddleware(Config{Concurrency: 2}))
+
+	e := testutils.TestEmail()
+	e.TemplateContext["site"] = "ego"
+	e.IdempotencyKey = "newsletter-42"
+	e.Bcc = append(e.Bcc, &ego.Recipient{Email: e.From, TemplateContext: map[string]string{"name": "Nyasia"}})
+	e.AddAttachment("test.txt", "text/plain", ioutil.NopCloser(strings.NewReader("hello")))
+
+	result, err := b.SendEmail(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(capture.sent) != len(e.To)+1 || len(result.Recipients) != len(e.To)+1 {
+		t.FailNow()
+	}
+
+	for _, sent := range capture.sent {
+		if len(sent.To) != 1 || len(sent.Cc) != 0 || len(sent.Bcc) != 0 {
+			t.FailNow()
+		}
+
+		data, err := ioutil.ReadAll(sent.Attachments[0].Data)
+		if err != nil || string(data) != "hello" {
+			t.FailNow()
+		}
+
+		if sent.TemplateContext["site"] != "ego" || sent.TemplateContext["name"] != sent.To[0].TemplateContext["name"] {
+			t.FailNow()
+		}
+
+		if sent.IdempotencyKey != "newsletter-42/"+sent.To[0].Email.Address {
+			t.Fatal(sent.IdempotencyKey)
+		}
+	}
+
+	for i, recipResult := range result.Recipients {
+		if recipResult.MessageID != "id-"+recipResult.Recipient.Address || recipResult.Err != nil {
+			t.FailNow()
+		}
+
+		if i < len(e.To) && recipResult.Recipient != e.To[i].Email {
+			t.FailNow()
+		}
+	}
+}
+
+// TestPartialFailure checks that failures are reported per recipient.
+func TestPartialFailure(t *testing.T) {
+	e := testutils.TestEmail()
+
+	capture := &captureBackend{fail: e.To[2].Email.Address}
+	b := backends.Chain(capture, NewMiddleware(Config{}))
+
+	result, err := b.SendEmail(context.Background(), e)
+
+	var partial *backends.PartialError
+	if !errors.As(err, &partial) {
+		t.Fatal(err)
+	}
+
+	if partial.Failed != 1 || partial.Total != len(e.To) {
+		t.FailNow()
+	}
+
+	if result.Recipients[2].Err == nil || result.Recipients[1].Err != nil {
+		t.FailNow()
+	}
+}
+
+// TestPassthrough checks when emails aren't split up.
+func TestPassthrough(t *testing.T) {
+	// the backend can personalize
+	capture := &captureBackend{personalizes: true}
+	b := backends.Chain(capture, NewMiddleware(Config{}))
+
+	b.SendEmail(context.Background(), testutils.TestEmail())
+	if len(capture.sent) != 1 {
+		t.FailNow()
+	}
+
+	// unless we insist
+	capture = &captureBackend{personalizes: true}
+	b = backends.Chain(capture, NewMiddleware(Config{Always: true}))
+
+	b.SendEmail(context.Background(), testutils.TestEmail())
+	if len(capture.sent) != len(testutils.TestRecipients()) {
+		t.FailNow()
+	}
+
+	// no recipient has any context
+	capture = &captureBackend{}
+	b = backends.Chain(capture, NewMiddleware(Config{}))
+
+	e := testutils.TestEmail()
+	for _, recip := range e.To {
+		recip.TemplateContext = nil
+	}
+
+	b.SendEmail(context.Background(), e)
+	if len(capture.sent) != 1 {
+		t.FailNow()
+	}
+}
+
+// TestSendEach checks that every recipient's copy is changed on its own.
+func TestSendEach(t *testing.T) {
+	capture := &captureBackend{}
+	e := testutils.TestEmail()
+	e.To = e.To[:3]
+
+	result, err := SendEach(context.Background(), capture, e, 0, func(single *ego.Email, recip *ego.Recipient) error {
+		single.Subject = "For " + recip.Email.Address
+		return nil
+	})
+	if err != nil || len(result.Recipients) != 3 || len(capture.sent) != 3 {
+		t.Fatal(result, err)
+	}
+
+	for _, sent := range capture.sent {
+		if sent.Subject != "For "+sent.To[0].Email.Address {
+			t.Fatal(sent.Subject)
+		}
+	}
+
+	// a single recipient's email is copied, not split
+	capture = &captureBackend{}
+	e.To = e.To[:1]
+
+	SendEach(context.Background(), capture, e, 0, func(single *ego.Email, recip *ego.Recipient) error {
+		single.Subject = "Changed"
+		return nil
+	})
+	if len(capture.sent) != 1 || capture.sent[0].Subject != "Changed" || e.Subject == "Changed" {
+		t.FailNow()
+	}
+
+	// nothing is sent if a copy can't be made
+	capture = &captureBackend{}
+	_, err = SendEach(context.Background(), capture, e, 0, func(single *ego.Email, recip *ego.Recipient) error {
+		return errors.New("boom")
+	})
+	if !errors.Is(err, backends.ErrNotSent) || len(capture.sent) != 0 {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,273 @@
+// HTML to plain text conversion
+//
+// Generates a plain text alternative for emails that only have an HTML body, since
+// sending without one hurts deliverability.
+
+package htmltext
+
+import (
+	"context"
+	"fmt"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/htmltoken"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// elements whose content never makes it into the text
+var skipped = map[string]bool{"head": true, "title": true, "style": true, "script": true}
+
+// elements that start and end on their own line
+var blocks = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "header": true, "footer": true,
+	"blockquote": true, "pre": true, "table": true, "tr": true, "ul": true, "ol": true, "li": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true,
+	"center": true, "address": true, "form": true, "dl": true, "dt": true, "dd": true,
+}
+
+// block elements that only need a line break, rather than a blank line, around them
+var lines = map[string]bool{"li": true, "tr": true, "dt": true, "dd": true}
+
+var spaces = regexp.MustCompile(`[ \t\r\n\f]+`)
+
+// Convert renders HTML as readable plain text.  Links become "text (url)", list items are
+// bulleted or numbered, headings are underlined, and table cells are separated by " | ".
+// HTML is read as forgivingly as browsers read it, so any document can be converted, but
+// the error is kept for conversions that may fail in future.
+func Convert(html string) (string, error) {
+	z := htmltoken.NewTokenizer(html)
+	c := &converter{}
+
+	for tok := z.Next(); tok != nil; tok = z.Next() {
+		switch tok.Type {
+		case htmltoken.StartTag:
+			c.start(tok.Tag, tok.Attrs)
+			if tok.SelfClosing || htmltoken.VoidElements[tok.Tag] {
+				c.end(tok.Tag)
+			}
+		case htmltoken.EndTag:
+			c.end(tok.Tag)
+		case htmltoken.Text:
+			c.text(tok.Data)
+		}
+	}
+
+	return c.String(), nil
+}
+
+// list tracks the numbering of a list being converted.
+type list struct {
+	ordered bool
+	count   int
+}
+
+type converter struct {
+	out  strings.Builder
+	line strings.Builder
+
+	skip  int
+	pre   int
+	lists []*list
+	links []string
+	texts []int
+	cells int
+}
+
+func (c *converter) start(name string, attrs []htmltoken.Attribute) {
+	if skipped[name] {
+		c.skip++
+		return
+	}
+
+	if blocks[name] {
+		c.breakBlock(name)
+	}
+
+	switch name {
+	case "br":
+		c.newline()
+	case "hr":
+		c.write("----------")
+		c.paragraph()
+	case "pre":
+		c.pre++
+	case "ul", "ol":
+		c.lists = append(c.lists, &list{ordered: name == "ol"})
+	case "li":
+		indent := ""
+		if len(c.lists) > 1 {
+			indent = strings.Repeat("  ", len(c.lists)-1)
+		}
+
+		if len(c.lists) > 0 && c.lists[len(c.lists)-1].ordered {
+			l := c.lists[len(c.lists)-1]
+			l.count++
+			c.write(indent + strconv.Itoa(l.count) + ". ")
+		} else {
+			c.write(indent + "* ")
+		}
+	case "tr":
+		c.cells = 0
+	case "td", "th":
+		if c.cells > 0 {
+			c.write(" | ")
+		}
+		c.cells++
+	case "a":
+		c.links = append(c.links, attr(attrs, "href"))
+		c.texts = append(c.texts, c.line.Len())
+	case "img":
+		c.text(attr(attrs, "alt"))
+	}
+}
+
+func (c *converter) end(name string) {
+	if skipped[name] {
+		if c.skip > 0 {
+			c.skip--
+		}
+		return
+	}
+
+	switch name {
+	case "pre":
+		if c.pre > 0 {
+			c.pre--
+		}
+	case "ul", "ol":
+		if len(c.lists) > 0 {
+			c.lists = c.lists[:len(c.lists)-1]
+		}
+	case "a":
+		if len(c.links) == 0 {
+			break
+		}
+
+		href, start := c.links[len(c.links)-1], c.texts[len(c.texts)-1]
+		c.links, c.texts = c.links[:len(c.links)-1], c.texts[:len(c.texts)-1]
+
+		// only mention the url if it adds something
+		text := ""
+		if start <= c.line.Len() {
+			text = strings.TrimSpace(c.line.String()[start:])
+		}
+		if href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(href, "javascript:") &&
+			text != href && "mailto:"+text != href {
+			c.write(" (" + href + ")")
+		}
+	case "h1", "h2":
+		underline := "="
+		if name == "h2" {
+			underline = "-"
+		}
+		if width := len([]rune(strings.TrimSpace(c.line.String()))); width > 0 {
+			c.newline()
+			c.write(strings.Repeat(underline, width))
+		}
+	}
+
+	if blocks[name] {
+		c.breakBlock(name)
+	}
+}
+
+// text adds character data, collapsing whitespace outside of <pre>.
+func (c *converter) text(s string) {
+	if c.skip > 0 {
+		return
+	}
+
+	if c.pre > 0 {
+		for i, line := range strings.Split(s, "\n") {
+			if i > 0 {
+				c.newline()
+			}
+			c.line.WriteString(line)
+		}
+		return
+	}
+
+	s = spaces.ReplaceAllString(strings.ReplaceAll(s, "\u00a0", " "), " ")
+
+	// don't start lines with spaces, or double them up
+	current := c.line.String()
+	if strings.TrimSpace(current) == "" || strings.HasSuffix(current, " ") {
+		s = strings.TrimLeft(s, " ")
+	}
+
+	c.line.WriteString(s)
+}
+
+func (c *converter) write(s string) {
+	if c.skip == 0 {
+		c.line.WriteString(s)
+	}
+}
+
+// newline ends the current line.
+func (c *converter) newline() {
+	c.out.WriteString(strings.TrimRight(c.line.String(), " "))
+	c.out.WriteString("\n")
+	c.line.Reset()
+}
+
+// breakBlock separates a block element from its surroundings.  List items, table rows
+// and nested lists go on their own line, everything else gets its own paragraph.
+func (c *converter) breakBlock(name string) {
+	if lines[name] || ((name == "ul" || name == "ol") && len(c.lists) > 0) {
+		if strings.TrimSpace(c.line.String()) != "" {
+			c.newline()
+		}
+		return
+	}
+	c.paragraph()
+}
+
+// paragraph ends the current line, if there is one, and leaves a blank line after it.
+func (c *converter) paragraph() {
+	if strings.TrimSpace(c.line.String()) != "" {
+		c.newline()
+	}
+	if out := c.out.String(); out != "" && !strings.HasSuffix(out, "\n\n") {
+		c.out.WriteString("\n")
+	}
+}
+
+func (c *converter) String() string {
+	c.paragraph()
+	return strings.TrimSpace(c.out.String()) + "\n"
+}
+
+func attr(attrs []htmltoken.Attribute, name string) string {
+	for _, a := range attrs {
+		if a.Name == name {
+			return strings.TrimSpace(a.Value)
+		}
+	}
+	return ""
+}
+
+// NewMiddleware returns a middleware that fills in the TextBody of emails that only have
+// an HTMLBody before they reach the wrapped backend.  If the HTML can't be converted, the
+// email isn't sent and the error is returned.
+func NewMiddleware() backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if e.TextBody != "" || e.HTMLBody == "" {
+				return next.SendEmail(ctx, e)
+			}
+
+			text, err := Convert(e.HTMLBody)
+			if err != nil {
+				return nil, backends.NotSent(fmt.Errorf("failed to convert html to text: %s", err))
+			}
+
+			e = e.Clone()
+			e.TextBody = text
+
+			return next.SendEmail(ctx, e)
+		})
+	}
+}
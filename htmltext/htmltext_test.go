@@ -0,0 +1,98 @@
+package htmltext
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"testing"
+)
+
+// TestConvert checks the conversion of a typical email.
+func TestConvert(t *testing.T) {
+	html := `<!DOCTYPE html>
+<html>
+<head><title>Ignored</title><style>p { color: red; }</style></head>
+<body>
+	<h1>Welcome&nbsp;aboard</h1>
+	<p>Thanks for   signing up,
+		<b>Jane</b>.<br>Here's what's next:</p>
+	<ol>
+		<li>Read the <a href="https://example.com/docs">docs</a></li>
+		<li>Email <a href="mailto:help@example.com">help@example.com</a></li>
+	</ol>
+	<ul><li>One</li><li>Two<ul><li>Nested</li></ul></li></ul>
+	<table>
+		<tr><th>Item</th><th>Price</th></tr>
+		<tr><td>Widget</td><td>$10</td></tr>
+	</table>
+	<img src="logo.png" alt="Example Inc">
+	<script>alert("ignored")</script>
+</body>
+</html>`
+
+	expected := `Welcome aboard
+==============
+
+Thanks for signing up, Jane.
+Here's what's next:
+
+1. Read the docs (https://example.com/docs)
+2. Email help@example.com
+
+* One
+* Two
+  * Nested
+
+Item | Price
+Widget | $10
+
+Example Inc
+`
+
+	text, err := Convert(html)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text != expected {
+		t.Fatalf("got:\n%s", text)
+	}
+}
+
+// TestMalformed checks that HTML that isn't well formed is converted as browsers show it.
+func TestMalformed(t *testing.T) {
+	for html, expected := range map[string]string{
+		`<script>if (a < b) { go() }</script><p>5 < 6</p>`: "5 < 6\n",
+		`<p>Fish&nbspchips &amp peas`:                      "Fish chips & peas\n",
+		`<p>One<p>Two<br/>Three`:                           "One\n\nTwo\nThree\n",
+	} {
+		text, err := Convert(html)
+		if err != nil || text != expected {
+			t.Fatalf("%s: got %q, %v", html, text, err)
+		}
+	}
+}
+
+// TestMiddleware checks that only emails without a text body are converted.
+func TestMiddleware(t *testing.T) {
+	var sent *ego.Email
+	capture := backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		sent = e
+		return &backends.Result{}, nil
+	})
+
+	b := backends.Chain(capture, NewMiddleware())
+
+	e := testutils.TestEmail()
+	b.SendEmail(context.Background(), e)
+	if sent.TextBody != e.TextBody {
+		t.FailNow()
+	}
+
+	e.TextBody = ""
+	b.SendEmail(context.Background(), e)
+	if sent.TextBody != "Test Body\n=========\n" || e.TextBody != "" {
+		t.Fatal(sent.TextBody)
+	}
+}
@@ -0,0 +1,306 @@
+// HTML tokenizer
+//
+// Splits HTML into tags, text and comments the way a browser would read them: forgivingly.
+// Stray "<" characters are text, entities are decoded with or without their semicolon, the
+// content of <script> and <style> is never mistaken for markup, and unterminated tags run
+// to the end of the document.  Every token records where it was found, so that documents
+// can be rewritten in place without disturbing the rest of their markup.
+
+package htmltoken
+
+import (
+	"html"
+	"strings"
+)
+
+// Type is the kind of a token.
+type Type int
+
+const (
+	// Text is character data between tags.
+	Text Type = iota
+
+	// StartTag is a tag such as <p class="x">, or <br/> with SelfClosing set.
+	StartTag
+
+	// EndTag is a tag such as </p>.
+	EndTag
+
+	// Comment is a <!-- comment -->.
+	Comment
+
+	// Directive is a <!DOCTYPE ...> or <?processing instruction?>.
+	Directive
+)
+
+// VoidElements never have content or an end tag.
+var VoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// elements whose content is text up to their end tag, rather than markup.  Entities are
+// decoded in the content of those that are true.
+var rawText = map[string]bool{"script": false, "style": false, "title": true, "textarea": true}
+
+// Attribute is an attribute of a start tag.
+type Attribute struct {
+	// Name is lower case, and Value has its entities decoded.
+	Name, Value string
+
+	// ValueStart and ValueEnd are the offsets of the value as it's written in the source,
+	// including any quotes.  They're both just past the name if the attribute has no value.
+	ValueStart, ValueEnd int
+}
+
+// Token is a piece of an HTML document.
+type Token struct {
+	Type Type
+
+	// Tag is the lower case name of a start or end tag.
+	Tag string
+
+	Attrs       []Attribute
+	SelfClosing bool
+
+	// Data is the decoded content of text, or the content of a comment.
+	Data string
+
+	// Start and End are the offsets of the token in the source.
+	Start, End int
+}
+
+// Attr returns the value of the named attribute of a start tag.
+func (t *Token) Attr(name string) (string, bool) {
+	for _, a := range t.Attrs {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Tokenizer reads the tokens of an HTML document in order.
+type Tokenizer struct {
+	src string
+	pos int
+
+	// set after the start tag of an element whose content is raw text
+	raw string
+}
+
+// NewTokenizer returns a tokenizer for the document.
+func NewTokenizer(src string) *Tokenizer {
+	return &Tokenizer{src: src}
+}
+
+// Next returns the next token, or nil at the end of the document.
+func (z *Tokenizer) Next() *Token {
+	if z.pos >= len(z.src) {
+		return nil
+	}
+
+	if z.raw != "" {
+		return z.rawText()
+	}
+
+	src, i := z.src, z.pos
+
+	switch {
+	case strings.HasPrefix(src[i:], "<!--"):
+		end := strings.Index(src[i+4:], "-->")
+		if end < 0 {
+			return z.emit(&Token{Type: Comment, Data: src[i+4:]}, len(src))
+		}
+		return z.emit(&Token{Type: Comment, Data: src[i+4 : i+4+end]}, i+4+end+3)
+
+	case strings.HasPrefix(src[i:], "<!") || strings.HasPrefix(src[i:], "<?"):
+		return z.emit(&Token{Type: Directive, Data: src[i+2 : z.tagEnd(i)]}, z.afterTag(i))
+
+	case strings.HasPrefix(src[i:], "</") && i+2 < len(src) && isLetter(src[i+2]):
+		j := i + 2
+		for j < len(src) && !isSpace(src[j]) && src[j] != '/' && src[j] != '>' {
+			j++
+		}
+		return z.emit(&Token{Type: EndTag, Tag: strings.ToLower(src[i+2 : j])}, z.afterTag(i))
+
+	case strings.HasPrefix(src[i:], "</"):
+		// browsers treat anything else after "</" as a comment, and "</>" as nothing
+		return z.emit(&Token{Type: Comment, Data: src[i+2 : z.tagEnd(i)]}, z.afterTag(i))
+
+	case src[i] == '<' && i+1 < len(src) && isLetter(src[i+1]):
+		return z.startTag()
+	}
+
+	return z.text()
+}
+
+func (z *Tokenizer) emit(t *Token, end int) *Token {
+	t.Start, t.End = z.pos, end
+	z.pos = end
+	return t
+}
+
+// tagEnd returns the offset of the ">" closing the tag at i, or the end of the document.
+func (z *Tokenizer) tagEnd(i int) int {
+	if end := strings.IndexByte(z.src[i:], '>'); end >= 0 {
+		return i + end
+	}
+	return len(z.src)
+}
+
+// afterTag returns the offset just past the tag at i.
+func (z *Tokenizer) afterTag(i int) int {
+	if end := z.tagEnd(i); end < len(z.src) {
+		return end + 1
+	}
+	return len(z.src)
+}
+
+// text reads character data up to the next piece of markup.  A "<" that doesn't start
+// any is part of the text.
+func (z *Tokenizer) text() *Token {
+	src := z.src
+	j := z.pos + 1
+
+	for j < len(src) {
+		lt := strings.IndexByte(src[j:], '<')
+		if lt < 0 {
+			j = len(src)
+			break
+		}
+		j += lt
+
+		if j+1 < len(src) && (isLetter(src[j+1]) || src[j+1] == '/' || src[j+1] == '!' || src[j+1] == '?') {
+			break
+		}
+		j++
+	}
+
+	return z.emit(&Token{Type: Text, Data: html.UnescapeString(src[z.pos:j])}, j)
+}
+
+// rawText reads the content of a <script>, <style> or similar element, up to its end tag.
+func (z *Tokenizer) rawText() *Token {
+	tag := z.raw
+	z.raw = ""
+
+	src := z.src
+	end := len(src)
+
+	lower := strings.ToLower(src[z.pos:])
+	for from := 0; ; {
+		j := strings.Index(lower[from:], "</"+tag)
+		if j < 0 {
+			break
+		}
+		j += from
+
+		if after := z.pos + j + 2 + len(tag); after >= len(src) || isSpace(src[after]) || src[after] == '/' || src[after] == '>' {
+			end = z.pos + j
+			break
+		}
+		from = j + 2
+	}
+
+	if end == z.pos {
+		return z.Next()
+	}
+
+	data := src[z.pos:end]
+	if rawText[tag] {
+		data = html.UnescapeString(data)
+	}
+
+	return z.emit(&Token{Type: Text, Data: data}, end)
+}
+
+// startTag reads a start tag and its attributes.
+func (z *Tokenizer) startTag() *Token {
+	src := z.src
+	j := z.pos + 1
+	for j < len(src) && !isSpace(src[j]) && src[j] != '/' && src[j] != '>' {
+		j++
+	}
+
+	t := &Token{Type: StartTag, Tag: strings.ToLower(src[z.pos+1 : j])}
+
+	for j < len(src) {
+		for j < len(src) && isSpace(src[j]) {
+			j++
+		}
+		if j >= len(src) {
+			break
+		}
+
+		if src[j] == '>' {
+			j++
+			break
+		}
+		if src[j] == '/' {
+			t.SelfClosing = j+1 < len(src) && src[j+1] == '>'
+			j++
+			continue
+		}
+
+		// attribute name
+		k := j + 1
+		for k < len(src) && !isSpace(src[k]) && src[k] != '=' && src[k] != '>' && src[k] != '/' {
+			k++
+		}
+		a := Attribute{Name: strings.ToLower(src[j:k]), ValueStart: k, ValueEnd: k}
+		j = k
+
+		for k < len(src) && isSpace(src[k]) {
+			k++
+		}
+
+		if k < len(src) && src[k] == '=' {
+			k++
+			for k < len(src) && isSpace(src[k]) {
+				k++
+			}
+			a.ValueStart = k
+
+			if k < len(src) && (src[k] == '"' || src[k] == '\'') {
+				end := strings.IndexByte(src[k+1:], src[k])
+				if end < 0 {
+					end = len(src) - k - 1
+				}
+				a.Value = src[k+1 : k+1+end]
+				j = k + end + 2
+			} else {
+				j = k
+				for j < len(src) && !isSpace(src[j]) && src[j] != '>' {
+					j++
+				}
+				a.Value = src[k:j]
+			}
+
+			if j > len(src) {
+				j = len(src)
+			}
+			a.ValueEnd = j
+			a.Value = html.UnescapeString(a.Value)
+		}
+
+		// the first of repeated attributes wins
+		if _, ok := t.Attr(a.Name); !ok {
+			t.Attrs = append(t.Attrs, a)
+		}
+	}
+
+	if _, ok := rawText[t.Tag]; ok && !t.SelfClosing {
+		z.raw = t.Tag
+	}
+
+	return z.emit(t, j)
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,93 @@
+package htmltoken
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func tokens(src string) []*Token {
+	z := NewTokenizer(src)
+	list := []*Token{}
+	for t := z.Next(); t != nil; t = z.Next() {
+		list = append(list, t)
+	}
+	return list
+}
+
+// describe summarizes tokens, to compare them in tests.
+func describe(list []*Token) string {
+	parts := []string{}
+	for _, t := range list {
+		switch t.Type {
+		case StartTag:
+			s := "<" + t.Tag
+			for _, a := range t.Attrs {
+				s += fmt.Sprintf(" %s=%q", a.Name, a.Value)
+			}
+			if t.SelfClosing {
+				s += "/"
+			}
+			parts = append(parts, s+">")
+		case EndTag:
+			parts = append(parts, "</"+t.Tag+">")
+		case Text:
+			parts = append(parts, fmt.Sprintf("%q", t.Data))
+		case Comment:
+			parts = append(parts, "<!--"+t.Data+"-->")
+		case Directive:
+			parts = append(parts, "<!"+t.Data+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// TestTokenizer checks the tokens of markup that isn't well formed.
+func TestTokenizer(t *testing.T) {
+	for src, expected := range map[string]string{
+		`<p>5 < 6</p>`: `<p> "5 < 6" </p>`,
+		`<script>if (a < b && c) { x = "</p>" }</script>`:  `<script> "if (a < b && c) { x = \"</p>\" }" </script>`,
+		`<STYLE media=screen>p > a {}</style >`:            `<style media="screen"> "p > a {}" </style>`,
+		`<title>Fish &amp; Chips</title>`:                  `<title> "Fish & Chips" </title>`,
+		`A&nbspB &amp C &copy; &bogus;`:                    `"A\u00a0B & C © &bogus;"`,
+		`<!DOCTYPE html><!-- <p> --><br/>`:                 `<!DOCTYPE html> <!-- <p> --> <br/>`,
+		`<img src=logo.png alt='A "logo"' ALT="x" hidden>`: `<img src="logo.png" alt="A \"logo\"" hidden="">`,
+		`<a href="x?a=1&amp;b=2">`:                         `<a href="x?a=1&b=2">`,
+		`</ p><p`:                                          `<!-- p--> <p>`,
+		`<div class="unterminated`:                         `<div class="unterminated">`,
+		`<script>unterminated`:                             `<script> "unterminated"`,
+		`<style></style>`:                                  `<style> </style>`,
+	} {
+		if got := describe(tokens(src)); got != expected {
+			t.Errorf("%s\n got: %s\nwant: %s", src, got, expected)
+		}
+	}
+}
+
+// TestOffsets checks that tokens and attribute values can be found in the source.
+func TestOffsets(t *testing.T) {
+	src := `<p>Hi <img src = 'a.png' alt=b hidden></p>`
+	list := tokens(src)
+
+	end := 0
+	for _, tok := range list {
+		if tok.Start != end {
+			t.Fatal(tok.Start, end)
+		}
+		end = tok.End
+	}
+	if end != len(src) {
+		t.FailNow()
+	}
+
+	img := list[2]
+	if src[img.Start:img.End] != `<img src = 'a.png' alt=b hidden>` {
+		t.Fatal(src[img.Start:img.End])
+	}
+
+	for i, expected := range []string{`'a.png'`, `b`, ``} {
+		if a := img.Attrs[i]; src[a.ValueStart:a.ValueEnd] != expected {
+			t.Fatal(a)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Local image embedding
+//
+// Templates are often designed against a directory of images, referenced by relative
+// paths that mean nothing to the recipient's mail client.  This package attaches those
+// images to the email and points the HTML at the attachments instead.
+
+package inlineimages
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/htmltoken"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// characters that aren't allowed in the content ids we generate
+var unsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Embed attaches the images that the email's HTML body references by a relative path
+// found in fsys, and rewrites the references to cid: URLs.  Images referenced more than
+// once are attached once.  References to anything else, such as absolute URLs or paths
+// that aren't in fsys, are left alone.
+func Embed(e *ego.Email, fsys fs.FS) error {
+	// content ids by path, including those of images already embedded
+	embedded := map[string]string{}
+	taken := map[string]bool{}
+	for _, attachment := range e.Attachments {
+		taken[attachment.ContentID] = true
+	}
+
+	src := e.HTMLBody
+	out := strings.Builder{}
+	last := 0
+
+	// the src of an <img>, or the background of any element
+	z := htmltoken.NewTokenizer(src)
+	for tok := z.Next(); tok != nil; tok = z.Next() {
+		if tok.Type != htmltoken.StartTag {
+			continue
+		}
+
+		for _, a := range tok.Attrs {
+			if !(a.Name == "src" && tok.Tag == "img" || a.Name == "background") || a.ValueStart == a.ValueEnd {
+				continue
+			}
+
+			name, ok := localPath(a.Value)
+			if !ok {
+				continue
+			}
+
+			cid, ok := embedded[name]
+			if !ok {
+				data, err := fs.ReadFile(fsys, name)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				} else if err != nil {
+					return fmt.Errorf("failed to read image %s: %s", name, err)
+				}
+
+				cid = contentID(name, taken)
+				taken[cid], embedded[name] = true, cid
+
+				e.AddInlineAttachment(cid, path.Base(name), mimetype(name, data), bytes.NewReader(data))
+			}
+
+			out.WriteString(src[last:a.ValueStart])
+			out.WriteString(`"cid:` + cid + `"`)
+			last = a.ValueEnd
+		}
+	}
+
+	out.WriteString(src[last:])
+	e.HTMLBody = out.String()
+
+	return nil
+}
+
+// localPath converts a reference to a path within the file system, if it's a relative URL.
+func localPath(ref string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+u.Path), "/")
+	return name, fs.ValidPath(name)
+}
+
+// contentID makes a readable content id for a path that isn't already used.
+func contentID(name string, taken map[string]bool) string {
+	base := strings.Trim(unsafe.ReplaceAllString(path.Base(name), "-"), "-.")
+	if base == "" {
+		base = "image"
+	}
+
+	cid := base
+	for i := 2; taken[cid]; i++ {
+		cid = base + "-" + strconv.Itoa(i)
+	}
+
+	return cid
+}
+
+func mimetype(name string, data []byte) string {
+	if mimetype := mime.TypeByExtension(path.Ext(name)); mimetype != "" {
+		return mimetype
+	}
+	return http.DetectContentType(data)
+}
+
+// NewMiddleware returns a middleware that embeds the images in fsys that HTML bodies
+// refer to, before they reach the wrapped backend.
+func NewMiddleware(fsys fs.FS) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if e.HTMLBody == "" {
+				return next.SendEmail(ctx, e)
+			}
+
+			e = e.Clone()
+			if err := Embed(e, fsys); err != nil {
+				return nil, err
+			}
+
+			return next.SendEmail(ctx, e)
+		})
+	}
+}
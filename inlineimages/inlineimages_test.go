@@ -0,0 +1,113 @@
+package inlineimages
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"io/ioutil"
+	"testing"
+	"testing/fstest"
+)
+
+var images = fstest.MapFS{
+	"images/logo.png":   {Data: []byte("\x89PNG logo")},
+	"images/banner.jpg": {Data: []byte("banner")},
+	"images/logo.gif":   {Data: []byte("GIF89a")},
+}
+
+// TestEmbed checks that local images are attached once and their references rewritten.
+func TestEmbed(t *testing.T) {
+	e := testutils.TestEmail()
+	e.HTMLBody = `<table background='images/banner.jpg'><tr><td>
+<img src="images/logo.png" alt="Logo"><img class="x" src="./images/logo.png?v=2">
+<img src=images/logo.gif>
+<img src="https://example.com/remote.png"><img src="images/missing.png"><img src="cid:existing">
+</td></tr></table>`
+
+	if err := Embed(e, images); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<table background="cid:banner.jpg"><tr><td>
+<img src="cid:logo.png" alt="Logo"><img class="x" src="cid:logo.png">
+<img src="cid:logo.gif">
+<img src="https://example.com/remote.png"><img src="images/missing.png"><img src="cid:existing">
+</td></tr></table>`
+
+	if e.HTMLBody != expected {
+		t.Fatal(e.HTMLBody)
+	}
+
+	if len(e.Attachments) != 3 {
+		t.Fatal(len(e.Attachments))
+	}
+
+	for i, expected := range []struct{ cid, name, mimetype, data string }{
+		{"banner.jpg", "banner.jpg", "image/jpeg", "banner"},
+		{"logo.png", "logo.png", "image/png", "\x89PNG logo"},
+		{"logo.gif", "logo.gif", "image/gif", "GIF89a"},
+	} {
+		a := e.Attachments[i]
+		data, _ := ioutil.ReadAll(a.Data)
+
+		if !a.Inline() || a.ContentID != expected.cid || a.Name != expected.name ||
+			a.Mimetype != expected.mimetype || string(data) != expected.data {
+			t.Fatal(a)
+		}
+	}
+}
+
+// TestEmbedIgnoresText checks that references outside of tags aren't mistaken for images.
+func TestEmbedIgnoresText(t *testing.T) {
+	e := testutils.TestEmail()
+	e.HTMLBody = `<!-- <img src="images/logo.png"> --><script>x = '<img src="images/logo.png">'</script>` +
+		`<p>1 < 2 <img src="images/logo.gif"></p><div data-src="images/banner.jpg">`
+
+	if err := Embed(e, images); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<!-- <img src="images/logo.png"> --><script>x = '<img src="images/logo.png">'</script>` +
+		`<p>1 < 2 <img src="cid:logo.gif"></p><div data-src="images/banner.jpg">`
+
+	if e.HTMLBody != expected || len(e.Attachments) != 1 {
+		t.Fatal(e.HTMLBody)
+	}
+}
+
+// TestContentID checks that generated content ids don't collide.
+func TestContentID(t *testing.T) {
+	taken := map[string]bool{"logo.png": true, "logo.png-2": true}
+
+	if cid := contentID("other/logo.png", taken); cid != "logo.png-3" {
+		t.Fatal(cid)
+	}
+
+	if cid := contentID("my logo (1).png", nil); cid != "my-logo-1-.png" {
+		t.Fatal(cid)
+	}
+}
+
+// TestMiddleware checks that the backend receives the embedded images, leaving the
+// original email alone.
+func TestMiddleware(t *testing.T) {
+	var sent *ego.Email
+	capture := backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		sent = e
+		return &backends.Result{}, nil
+	})
+
+	e := testutils.TestEmail()
+	e.HTMLBody = `<img src="images/logo.png">`
+
+	backends.Chain(capture, NewMiddleware(images)).SendEmail(context.Background(), e)
+
+	if sent.HTMLBody != `<img src="cid:logo.png">` || len(sent.Attachments) != 1 {
+		t.Fatal(sent.HTMLBody)
+	}
+
+	if e.HTMLBody != `<img src="images/logo.png">` || len(e.Attachments) != 0 {
+		t.Fatal(e.HTMLBody)
+	}
+}
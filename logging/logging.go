@@ -0,0 +1,188 @@
+// Structured logging middleware
+//
+// Emits one log/slog record per send, with optional redaction of personal data so
+// that the logs can be kept in production.
+
+package logging
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"log/slog"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Redaction controls which parts of an email are hidden from the logs.
+type Redaction struct {
+	// Replace the local part of every address with its first character, eg "j***@smith.com",
+	// and drop display names.  Addresses in error messages, such as a provider refusing a
+	// recipient, are masked too.
+	MaskAddresses bool
+
+	// Leave the subject, text and HTML bodies out of the record.
+	DropBodies bool
+
+	// Replace template context values with an HMAC of them keyed with HashKey, so that
+	// equal values can still be matched up but guessable ones (names, zip codes) can't be
+	// found by hashing candidates.  Without a HashKey the values are left out entirely.
+	HashTemplateContext bool
+	HashKey             []byte
+}
+
+// Redact is the recommended redaction for production use: everything is redacted.  Set
+// a HashKey on a copy of it to keep hashes of the template context.
+var Redact = Redaction{MaskAddresses: true, DropBodies: true, HashTemplateContext: true}
+
+// NewMiddleware returns a middleware that logs every send through the wrapped backend to l.
+// Successful sends are logged at info level, failures at error level.
+func NewMiddleware(l *slog.Logger, r Redaction) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		name := backends.Name(next)
+
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			start := time.Now()
+			result, err := next.SendEmail(ctx, e)
+
+			attrs := []slog.Attr{
+				slog.String("backend", name),
+				slog.Duration("duration", time.Since(start)),
+			}
+			attrs = append(attrs, r.emailAttrs(e)...)
+
+			if result != nil {
+				if result.MessageID != "" {
+					attrs = append(attrs, slog.String("message_id", result.MessageID))
+				}
+				if result.StatusCode != 0 {
+					attrs = append(attrs, slog.Int("status_code", result.StatusCode))
+				}
+			}
+
+			if err != nil {
+				attrs = append(attrs, slog.String("error", r.errorMessage(err)))
+				l.LogAttrs(ctx, slog.LevelError, "email send failed", attrs...)
+			} else {
+				l.LogAttrs(ctx, slog.LevelInfo, "email sent", attrs...)
+			}
+
+			return result, err
+		})
+	}
+}
+
+// emailAttrs describes the email, applying the redaction rules.
+func (r Redaction) emailAttrs(e *ego.Email) []slog.Attr {
+	attrs := []slog.Attr{}
+
+	if e.From != nil {
+		attrs = append(attrs, slog.String("from", r.address(e.From)))
+	}
+
+	for _, field := range []struct {
+		key        string
+		recipients []*ego.Recipient
+	}{{"to", e.To}, {"cc", e.Cc}, {"bcc", e.Bcc}} {
+		if len(field.recipients) == 0 {
+			continue
+		}
+
+		addresses := make([]string, 0, len(field.recipients))
+		for _, recip := range field.recipients {
+			addresses = append(addresses, r.address(recip.Email))
+		}
+		attrs = append(attrs, slog.Any(field.key, addresses))
+	}
+
+	if len(e.Tags) > 0 {
+		attrs = append(attrs, slog.Any("tags", e.Tags))
+	}
+
+	if e.TemplateID != "" {
+		attrs = append(attrs, slog.String("template_id", e.TemplateID))
+	}
+
+	if len(e.TemplateContext) > 0 {
+		attrs = append(attrs, slog.Attr{
+			Key:   "template_context",
+			Value: slog.GroupValue(r.templateContext(e.TemplateContext)...),
+		})
+	}
+
+	if len(e.Attachments) > 0 {
+		names := make([]string, 0, len(e.Attachments))
+		for _, attachment := range e.Attachments {
+			names = append(names, attachment.Name)
+		}
+		attrs = append(attrs, slog.Any("attachments", names))
+	}
+
+	if !r.DropBodies {
+		attrs = append(attrs,
+			slog.String("subject", e.Subject),
+			slog.String("text_body", e.TextBody),
+			slog.String("html_body", e.HTMLBody),
+		)
+	}
+
+	return attrs
+}
+
+func (r Redaction) address(addr *mail.Address) string {
+	if !r.MaskAddresses {
+		return addr.String()
+	}
+	return MaskAddress(addr.Address)
+}
+
+func (r Redaction) templateContext(ctx map[string]string) []slog.Attr {
+	keys := make([]string, 0, len(ctx))
+	for k := range ctx {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, k := range keys {
+		v := ctx[k]
+		if r.HashTemplateContext && len(r.HashKey) == 0 {
+			v = "[redacted]"
+		} else if r.HashTemplateContext {
+			mac := hmac.New(sha256.New, r.HashKey)
+			mac.Write([]byte(v))
+			v = hex.EncodeToString(mac.Sum(nil)[:8])
+		}
+		attrs = append(attrs, slog.String(k, v))
+	}
+
+	return attrs
+}
+
+// addressPattern finds addresses within free text, such as error messages.
+var addressPattern = regexp.MustCompile(`[^\s<>()\[\]"',;:]+@[^\s<>()\[\]"',;:]+`)
+
+func (r Redaction) errorMessage(err error) string {
+	if !r.MaskAddresses {
+		return err.Error()
+	}
+	return addressPattern.ReplaceAllStringFunc(err.Error(), MaskAddress)
+}
+
+// MaskAddress hides all but the first character of an address's local part,
+// so "jane@smith.com" becomes "j***@smith.com".
+func MaskAddress(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 1 {
+		return "***"
+	}
+	_, first := utf8.DecodeRuneInString(address)
+	return address[:first] + "***" + address[at:]
+}
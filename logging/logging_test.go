@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/backends/dummy"
+	"github.com/jarcoal/ego/testutils"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func sendAndDecode(t *testing.T, r Redaction) (map[string]interface{}, string) {
+	return sendWith(t, r, dummy.NewBackend(nil))
+}
+
+func sendWith(t *testing.T, r Redaction, next backends.Backend) (map[string]interface{}, string) {
+	buf := &bytes.Buffer{}
+	l := slog.New(slog.NewJSONHandler(buf, nil))
+
+	e := testutils.TestEmail()
+	e.TemplateContext["secret"] = "hunter2"
+
+	backends.Chain(next, NewMiddleware(l, r)).SendEmail(context.Background(), e)
+
+	record := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	return record, buf.String()
+}
+
+// TestUnredacted checks that everything is logged when redaction is off.
+func TestUnredacted(t *testing.T) {
+	record, raw := sendAndDecode(t, Redaction{})
+
+	if record["backend"] != "dummy" || record["msg"] != "email sent" {
+		t.FailNow()
+	}
+
+	if record["text_body"] != "Test Body" {
+		t.FailNow()
+	}
+
+	if !strings.Contains(raw, "zane@anastacio.co.uk") || !strings.Contains(raw, "hunter2") {
+		t.FailNow()
+	}
+}
+
+// TestRedacted checks that personal data is kept out of the log.
+func TestRedacted(t *testing.T) {
+	record, raw := sendAndDecode(t, Redact)
+
+	if _, ok := record["text_body"]; ok {
+		t.FailNow()
+	}
+
+	if strings.Contains(raw, "zane@anastacio.co.uk") || strings.Contains(raw, "Sandy Schulist") {
+		t.FailNow()
+	}
+
+	if !strings.Contains(raw, "z***@anastacio.co.uk") {
+		t.FailNow()
+	}
+
+	if strings.Contains(raw, "hunter2") {
+		t.FailNow()
+	}
+
+	if record["template_context"].(map[string]interface{})["secret"] != "[redacted]" {
+		t.FailNow()
+	}
+}
+
+// TestHashedTemplateContext checks that template values are hashed with the key.
+func TestHashedTemplateContext(t *testing.T) {
+	r := Redact
+	r.HashKey = []byte("key")
+	record, _ := sendAndDecode(t, r)
+
+	r.HashKey = []byte("other key")
+	other, _ := sendAndDecode(t, r)
+
+	hashed := record["template_context"].(map[string]interface{})["secret"].(string)
+	if len(hashed) != 16 || hashed == other["template_context"].(map[string]interface{})["secret"] {
+		t.Fatal(hashed)
+	}
+}
+
+// TestRedactedError checks that addresses in error messages are masked.
+func TestRedactedError(t *testing.T) {
+	failing := backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		return nil, errors.New("SMTP server refused recipient zane@anastacio.co.uk: 550 no such user <zane@anastacio.co.uk>")
+	})
+
+	record, raw := sendWith(t, Redact, failing)
+
+	if strings.Contains(raw, "zane@anastacio.co.uk") ||
+		record["error"] != "SMTP server refused recipient z***@anastacio.co.uk: 550 no such user <z***@anastacio.co.uk>" {
+		t.Fatal(record["error"])
+	}
+}
+
+// TestMaskAddress checks the masking of addresses.
+func TestMaskAddress(t *testing.T) {
+	if MaskAddress("jane@smith.com") != "j***@smith.com" {
+		t.FailNow()
+	}
+
+	if MaskAddress("émile@smith.com") != "é***@smith.com" {
+		t.FailNow()
+	}
+
+	if MaskAddress("not-an-address") != "***" {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,119 @@
+// Merge tag translation
+//
+// Every provider has its own syntax for the placeholders in an inline template, so
+// switching providers means rewriting templates.  This package rewrites placeholders
+// from one syntax to another, so templates can be written once in the neutral syntax
+// (or any provider's) and translated by the backend.
+
+package mergetag
+
+import (
+	"regexp"
+)
+
+// Syntax is a style of merge tag.
+type Syntax int
+
+const (
+	// Neutral is ego's own syntax: ${name}
+	Neutral Syntax = iota
+
+	// Mandrill's syntax: *|NAME|*
+	Mandrill
+
+	// SendGrid's legacy substitution syntax: -name-
+	SendGrid
+
+	// PostageApp's syntax: {{name}}
+	PostageApp
+)
+
+// Tags are parsed with a leading and trailing group so that a tag's surroundings can be
+// checked and put back.  SendGrid's tags would otherwise match hyphenated words, so they
+// can't be directly next to a letter, digit or hyphen.
+var patterns = map[Syntax]*regexp.Regexp{
+	Neutral:    regexp.MustCompile(`()\$\{\s*([A-Za-z0-9_:.]+)\s*\}()`),
+	Mandrill:   regexp.MustCompile(`()\*\|([A-Za-z0-9_:.]+)\|\*()`),
+	SendGrid:   regexp.MustCompile(`(^|[^A-Za-z0-9_-])-([A-Za-z0-9_]+)-($|[^A-Za-z0-9_-])`),
+	PostageApp: regexp.MustCompile(`()\{\{\s*([A-Za-z0-9_:.]+)\s*\}\}()`),
+}
+
+// Format returns the merge tag for name in the syntax.
+func Format(s Syntax, name string) string {
+	switch s {
+	case Mandrill:
+		return "*|" + name + "|*"
+	case SendGrid:
+		return "-" + name + "-"
+	case PostageApp:
+		return "{{" + name + "}}"
+	}
+	return "${" + name + "}"
+}
+
+// Names returns the names of the merge tags in text, in the order they appear.
+func Names(text string, s Syntax) []string {
+	names := []string{}
+	for _, tag := range find(text, s) {
+		names = append(names, text[tag.nameStart:tag.nameEnd])
+	}
+	return names
+}
+
+// Replace rewrites the merge tags in text with what fn returns for their names.  Tags
+// that fn doesn't know, by returning false, and anything that isn't a merge tag are left
+// alone.
+func Replace(text string, s Syntax, fn func(name string) (string, bool)) string {
+	replaced := []byte{}
+	last := 0
+
+	for _, tag := range find(text, s) {
+		if value, ok := fn(text[tag.nameStart:tag.nameEnd]); ok {
+			replaced = append(append(replaced, text[last:tag.start]...), value...)
+			last = tag.end
+		}
+	}
+
+	if last == 0 {
+		return text
+	}
+	return string(append(replaced, text[last:]...))
+}
+
+// Translate rewrites the merge tags in text from one syntax to another.  Anything that
+// isn't a merge tag is left alone.
+func Translate(text string, from, to Syntax) string {
+	if from == to {
+		return text
+	}
+
+	return Replace(text, from, func(name string) (string, bool) {
+		return Format(to, name), true
+	})
+}
+
+// tag is where a merge tag and its name are in a text.
+type tag struct {
+	start, end, nameStart, nameEnd int
+}
+
+// find returns the merge tags in text, in order.
+func find(text string, s Syntax) []tag {
+	pattern := patterns[s]
+	tags := []tag{}
+
+	// the surroundings matched with a tag may be those of the next one, eg the space in
+	// sendgrid's "-a- -b-", so each search starts where the last tag ended.  A tag can't
+	// end just before another starts, so "^" can't match there by mistake.
+	for pos := 0; pos < len(text); {
+		loc := pattern.FindStringSubmatchIndex(text[pos:])
+		if loc == nil {
+			break
+		}
+
+		tags = append(tags, tag{pos + loc[3], pos + loc[6], pos + loc[4], pos + loc[5]})
+		pos += loc[6]
+	}
+
+	return tags
+}
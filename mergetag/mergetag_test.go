@@ -0,0 +1,59 @@
+package mergetag
+
+import (
+	"testing"
+)
+
+// TestTranslate checks translation between every pair of syntaxes.
+func TestTranslate(t *testing.T) {
+	texts := map[Syntax]string{
+		Neutral:    "Hi ${name}, your order ${order_id} has shipped.",
+		Mandrill:   "Hi *|name|*, your order *|order_id|* has shipped.",
+		SendGrid:   "Hi -name-, your order -order_id- has shipped.",
+		PostageApp: "Hi {{name}}, your order {{order_id}} has shipped.",
+	}
+
+	for from, text := range texts {
+		for to, expected := range texts {
+			if translated := Translate(text, from, to); translated != expected {
+				t.Errorf("%d -> %d: %q", from, to, translated)
+			}
+		}
+	}
+}
+
+// TestSendGridAmbiguity checks that hyphenated words aren't mistaken for sendgrid tags.
+func TestSendGridAmbiguity(t *testing.T) {
+	text := "A well-known-brand: -first- -last-"
+
+	if translated := Translate(text, SendGrid, Neutral); translated != "A well-known-brand: ${first} ${last}" {
+		t.Fatal(translated)
+	}
+}
+
+// TestNames checks that tag names are found.
+func TestNames(t *testing.T) {
+	names := Names("*|FNAME|* *|LNAME|*", Mandrill)
+
+	if len(names) != 2 || names[0] != "FNAME" || names[1] != "LNAME" {
+		t.FailNow()
+	}
+}
+
+// TestReplace checks that only the known tags are replaced, and hyphenated words never.
+func TestReplace(t *testing.T) {
+	values := map[string]string{"first": "Jane", "known": "X"}
+	lookup := func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+
+	text := "A well-known-brand: -first- -last- -known-"
+	if replaced := Replace(text, SendGrid, lookup); replaced != "A well-known-brand: Jane -last- X" {
+		t.Fatal(replaced)
+	}
+
+	if names := Names(text, SendGrid); len(names) != 3 || names[0] != "first" || names[2] != "known" {
+		t.Fatal(names)
+	}
+}
@@ -0,0 +1,93 @@
+package message
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"strings"
+)
+
+var _ backends.EnvelopeSender = (*messageBackend)(nil)
+
+// Envelope is the SMTP envelope of a message: the address bounces go back to, and the
+// addresses it's delivered to.
+type Envelope struct {
+	From string
+	To   []string
+}
+
+// Sender delivers rendered messages, such as over SMTP or to a provider's raw send API.
+type Sender interface {
+	SendMessage(ctx context.Context, env *Envelope, msg []byte) error
+}
+
+// SenderFunc adapts an ordinary function to the Sender interface.
+type SenderFunc func(ctx context.Context, env *Envelope, msg []byte) error
+
+// SendMessage calls f(ctx, env, msg).
+func (f SenderFunc) SendMessage(ctx context.Context, env *Envelope, msg []byte) error {
+	return f(ctx, env, msg)
+}
+
+// Middleware wraps a Sender to work on messages once they're rendered, such as to sign
+// them.
+type Middleware func(Sender) Sender
+
+// Chain wraps the sender with the given middleware.  The first middleware is the
+// outermost one, so it is the first to see a message being sent.
+func Chain(s Sender, middleware ...Middleware) Sender {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		s = middleware[i](s)
+	}
+	return s
+}
+
+// NewBackend returns a backend that renders emails and delivers them with s, as a single
+// message to all of their recipients.
+func NewBackend(s Sender) backends.Backend {
+	return &messageBackend{s}
+}
+
+type messageBackend struct {
+	sender Sender
+}
+
+func (m *messageBackend) Name() string {
+	return "message"
+}
+
+// SupportsEnvelopeSender reports that an email's ReturnPath is its envelope sender.
+func (m *messageBackend) SupportsEnvelopeSender() bool {
+	return true
+}
+
+func (m *messageBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	msg, err := New(e)
+	if err != nil {
+		return nil, backends.NotSent(err)
+	}
+
+	if err := m.sender.SendMessage(ctx, NewEnvelope(e), msg.Bytes()); err != nil {
+		return nil, err
+	}
+
+	messageID := strings.Trim(msg.Header.Get("Message-ID"), "<>")
+	return &backends.Result{MessageID: messageID, HeaderMessageID: messageID}, nil
+}
+
+// NewEnvelope returns the envelope for an email: from its ReturnPath, or its sender if it
+// has none, to all of its To, Cc and Bcc recipients.
+func NewEnvelope(e *ego.Email) *Envelope {
+	env := &Envelope{From: e.ReturnPath}
+	if env.From == "" && e.From != nil {
+		env.From = e.From.Address
+	}
+
+	for _, list := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+		for _, r := range list {
+			env.To = append(env.To, r.Email.Address)
+		}
+	}
+
+	return env
+}
@@ -0,0 +1,85 @@
+package message
+
+import (
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+)
+
+// Header is a list of header fields, kept in the order they're written, since signatures
+// such as DKIM depend on it.
+type Header []Field
+
+// Field is a single header field.  Long values may be folded over several lines.
+type Field struct {
+	Name, Value string
+}
+
+// Get returns the value of the first field with the given name, ignoring case.
+func (h Header) Get(name string) string {
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Add appends a field.
+func (h *Header) Add(name, value string) {
+	*h = append(*h, Field{name, value})
+}
+
+// Set replaces the fields with the given name, or appends one if there aren't any.
+func (h *Header) Set(name, value string) {
+	for i, f := range *h {
+		if strings.EqualFold(f.Name, name) {
+			(*h)[i].Value = value
+			h.del(name, i+1)
+			return
+		}
+	}
+	h.Add(name, value)
+}
+
+// Del removes every field with the given name.
+func (h *Header) Del(name string) {
+	h.del(name, 0)
+}
+
+func (h *Header) del(name string, from int) {
+	kept := (*h)[:from]
+	for _, f := range (*h)[from:] {
+		if !strings.EqualFold(f.Name, name) {
+			kept = append(kept, f)
+		}
+	}
+	*h = kept
+}
+
+// WriteTo writes the fields followed by the blank line that ends a header.
+func (h Header) WriteTo(w io.Writer) (int64, error) {
+	b := &strings.Builder{}
+	for _, f := range h {
+		b.WriteString(f.Name + ": " + f.Value + "\r\n")
+	}
+	b.WriteString("\r\n")
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// encodeWord encodes header text that isn't plain ASCII, per RFC 2047.
+func encodeWord(s string) string {
+	return mime.QEncoding.Encode("utf-8", s)
+}
+
+// formatAddresses formats an address list, one address per line.
+func formatAddresses(addresses []*mail.Address) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		formatted = append(formatted, a.String())
+	}
+	return strings.Join(formatted, ",\r\n ")
+}
@@ -0,0 +1,245 @@
+// MIME message rendering
+//
+// Renders emails as RFC 5322 messages, for services that accept raw messages and for
+// anything that has to work on the message itself, such as signing.
+
+package message
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jarcoal/ego"
+	"io"
+	"mime"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is a rendered email: the message header and its body, whose Content-* fields
+// are kept in the body's own header so the body can be signed or encrypted as a unit.
+type Message struct {
+	Header Header
+	Body   *Part
+}
+
+// New renders an email as a message.  The body is built from the most specific structure
+// the email needs:
+//
+//	multipart/mixed             when there are attachments
+//	  multipart/related         when there are inline attachments for the HTML
+//	    multipart/alternative   when there's both a text and an HTML body
+//	      text/plain
+//	      text/html
+//	    image/png (inline)
+//	  application/pdf (attachment)
+//
+// Reading the attachments consumes their data.
+func New(e *ego.Email) (*Message, error) {
+	m := &Message{}
+
+	m.Header.Add("Date", time.Now().Format(time.RFC1123Z))
+	if e.From != nil {
+		m.Header.Add("From", e.From.String())
+	}
+	if e.ReplyTo != nil {
+		m.Header.Add("Reply-To", e.ReplyTo.String())
+	}
+
+	if to := addresses(e.To); len(to) == 1 || len(to) > 1 && e.VisibleRecipients {
+		m.Header.Add("To", formatAddresses(to))
+	} else if len(to) > 1 {
+		// a single message goes to everyone, so they can only be hidden from each other
+		m.Header.Add("To", "undisclosed-recipients:;")
+	}
+	if cc := addresses(e.Cc); len(cc) > 0 {
+		m.Header.Add("Cc", formatAddresses(cc))
+	}
+
+	m.Header.Add("Subject", encodeWord(e.Subject))
+
+	_, threading := e.ThreadingHeaders()
+	for _, name := range []string{"Message-ID", "In-Reply-To", "References"} {
+		if value, ok := threading[name]; ok {
+			m.Header.Add(name, value)
+		}
+	}
+
+	list := e.ListHeaders()
+	for _, name := range []string{"List-Unsubscribe", "List-Unsubscribe-Post"} {
+		if value, ok := list[name]; ok {
+			m.Header.Add(name, value)
+		}
+	}
+
+	names := make([]string, 0, len(e.Headers))
+	for name := range e.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range e.Headers[name] {
+			m.Header.Add(name, encodeWord(value))
+		}
+	}
+
+	m.Header.Add("MIME-Version", "1.0")
+
+	body, err := newBody(e)
+	if err != nil {
+		return nil, err
+	}
+	m.Body = body
+
+	return m, nil
+}
+
+// Render renders an email as the bytes of a message.
+func Render(e *ego.Email) ([]byte, error) {
+	m, err := New(e)
+	if err != nil {
+		return nil, err
+	}
+	return m.Bytes(), nil
+}
+
+// Parse splits a rendered message into its header and body, so that the body can be
+// transformed as a unit, such as to sign or encrypt it.  The body's Content-* fields are
+// moved into its own header; its content is kept as it is.
+func Parse(msg []byte) (*Message, error) {
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	msg = bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, errors.New("message has no body")
+	}
+
+	m := &Message{Body: &Part{Body: msg[end+4:]}}
+
+	for _, line := range strings.SplitAfter(string(msg[:end+2]), "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		// continuation lines belong to the previous field
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(m.Header) == 0 {
+				return nil, errors.New("message header starts with a continuation line")
+			}
+			last := &m.Header[len(m.Header)-1]
+			last.Value += "\r\n" + strings.TrimSuffix(line, "\r\n")
+			continue
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid header line %q", strings.TrimSpace(line))
+		}
+		m.Header.Add(line[:colon], strings.TrimSpace(line[colon+1:]))
+	}
+
+	// move the content fields to the body, now that they're complete
+	content := Header{}
+	for _, f := range m.Header {
+		if strings.HasPrefix(strings.ToLower(f.Name), "content-") {
+			content = append(content, f)
+		}
+	}
+	for _, f := range content {
+		m.Header.Del(f.Name)
+	}
+	m.Body.Header = content
+
+	return m, nil
+}
+
+func newBody(e *ego.Email) (*Part, error) {
+	alternatives := []*Part{}
+	if e.TextBody != "" || e.HTMLBody == "" {
+		alternatives = append(alternatives, NewTextPart("text/plain", e.TextBody))
+	}
+	if e.HTMLBody != "" {
+		alternatives = append(alternatives, NewTextPart("text/html", e.HTMLBody))
+	}
+
+	// calendar clients such as Outlook only treat an invitation as one when it's an
+	// alternative to the body, so it's there as well as attached, rendered once so that
+	// both copies are the same
+	attachments := e.Attachments
+	if e.Event != nil {
+		ics, err := e.Event.ICS()
+		if err != nil {
+			return nil, err
+		}
+		calendar := NewTextPart("text/calendar", string(ics))
+		mediatype, params, _ := mime.ParseMediaType(e.Event.ContentType())
+		calendar.SetContentType(mediatype, params)
+
+		alternatives = append(alternatives, calendar)
+		attachments = append(attachments[:len(attachments):len(attachments)], e.Event.NewAttachment(ics))
+	}
+
+	body := alternatives[0]
+	if len(alternatives) > 1 {
+		body = NewMultipart("alternative", alternatives...)
+	}
+
+	related, attached := []*Part{body}, []*Part{}
+
+	for _, attachment := range attachments {
+		part, err := NewAttachmentPart(attachment)
+		if err != nil {
+			return nil, err
+		}
+
+		// inline attachments are only related to an HTML body that can refer to them
+		if attachment.Inline() && e.HTMLBody != "" {
+			related = append(related, part)
+		} else {
+			attached = append(attached, part)
+		}
+	}
+
+	if len(related) > 1 {
+		body = NewMultipart("related", related...)
+		body.SetContentType("multipart/related", map[string]string{"type": related[0].mediatype()})
+	}
+
+	if len(attached) > 0 {
+		body = NewMultipart("mixed", append([]*Part{body}, attached...)...)
+	}
+
+	return body, nil
+}
+
+// WriteTo writes the whole message.
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	b := &bytes.Buffer{}
+
+	header := append(Header{}, m.Header...)
+	header = append(header, m.Body.Header...)
+	header.WriteTo(b)
+	m.Body.writeContent(b)
+
+	return b.WriteTo(w)
+}
+
+// Bytes returns the whole message.
+func (m *Message) Bytes() []byte {
+	b := &bytes.Buffer{}
+	m.WriteTo(b)
+
+	return b.Bytes()
+}
+
+func addresses(recipients []*ego.Recipient) []*mail.Address {
+	list := make([]*mail.Address, 0, len(recipients))
+	for _, r := range recipients {
+		list = append(list, r.Email)
+	}
+	return list
+}
@@ -0,0 +1,313 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/testutils"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parse reads a rendered message, returning its header and the media type of its body.
+func parse(t *testing.T, raw []byte) (*mail.Message, string, map[string]string) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediatype, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg, mediatype, params
+}
+
+// TestRender checks the header and the structure of a message with inline and regular
+// attachments.
+func TestRender(t *testing.T) {
+	e := testutils.TestEmail()
+	e.Subject = "Café"
+	e.Headers.Set("X-Campaign", "launch")
+	e.AddAttachment("report.pdf", "application/pdf", strings.NewReader("%PDF"))
+	e.AddInlineAttachment("logo", "logo.png", "image/png", strings.NewReader("png"))
+
+	raw, err := Render(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, mediatype, params := parse(t, raw)
+
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != "Café" {
+		t.Fatal(subject)
+	}
+
+	if msg.Header.Get("To") != "undisclosed-recipients:;" || msg.Header.Get("X-Campaign") != "launch" {
+		t.Fatal(msg.Header)
+	}
+
+	if from, err := msg.Header.AddressList("From"); err != nil || from[0].Address != e.From.Address {
+		t.Fatal(err)
+	}
+
+	if mediatype != "multipart/mixed" {
+		t.Fatal(mediatype)
+	}
+
+	mixed := multipart.NewReader(msg.Body, params["boundary"])
+
+	// the first part is the body, with the inline image
+	related, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediatype, params, _ = mime.ParseMediaType(related.Header.Get("Content-Type"))
+	if mediatype != "multipart/related" || params["type"] != "multipart/alternative" {
+		t.Fatal(mediatype, params)
+	}
+
+	relatedParts := multipart.NewReader(related, params["boundary"])
+
+	alternative, err := relatedParts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, _ = mime.ParseMediaType(alternative.Header.Get("Content-Type"))
+
+	bodies := multipart.NewReader(alternative, params["boundary"])
+	for _, expected := range []string{e.TextBody, e.HTMLBody} {
+		part, err := bodies.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// quoted-printable is decoded by the reader
+		if content, _ := ioutil.ReadAll(part); string(content) != expected {
+			t.Fatal(string(content))
+		}
+	}
+
+	image, err := relatedParts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Header.Get("Content-ID") != "<logo>" || !strings.HasPrefix(image.Header.Get("Content-Disposition"), "inline") {
+		t.Fatal(image.Header)
+	}
+
+	// then the regular attachment
+	attachment, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "report.pdf" || attachment.Header.Get("Content-Transfer-Encoding") != "base64" {
+		t.Fatal(attachment.Header)
+	}
+}
+
+// TestRenderText checks that a plain email is a single part.
+func TestRenderText(t *testing.T) {
+	e := ego.NewEmail()
+	e.From = &mail.Address{Address: "from@example.com"}
+	e.AddRecipient("", "to@example.com", nil)
+	e.TextBody = "Hello"
+
+	raw, err := Render(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, mediatype, params := parse(t, raw)
+
+	if mediatype != "text/plain" || params["charset"] != "utf-8" {
+		t.Fatal(mediatype)
+	}
+
+	if msg.Header.Get("To") != "<to@example.com>" || msg.Header.Get("MIME-Version") != "1.0" {
+		t.Fatal(msg.Header)
+	}
+}
+
+// TestHeader checks the ordered header operations.
+func TestHeader(t *testing.T) {
+	h := Header{}
+	h.Add("To", "a")
+	h.Add("Received", "1")
+	h.Add("Received", "2")
+	h.Set("received", "3")
+
+	if len(h) != 2 || h.Get("RECEIVED") != "3" || h[1].Name != "Received" {
+		t.Fatal(h)
+	}
+
+	h.Del("to")
+	if len(h) != 1 || h.Get("To") != "" {
+		t.Fatal(h)
+	}
+}
+
+// TestBackend checks that emails are rendered and sent to all of their recipients, with
+// Bcc recipients only in the envelope.
+func TestBackend(t *testing.T) {
+	var env *Envelope
+	var sent []byte
+	capture := SenderFunc(func(ctx context.Context, e *Envelope, msg []byte) error {
+		env, sent = e, msg
+		return nil
+	})
+
+	e := testutils.TestEmail()
+	e.To = e.To[:1]
+	e.Bcc = append(e.Bcc, &ego.Recipient{Email: &mail.Address{Address: "audit@example.com"}})
+
+	if _, err := NewBackend(capture).SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.From != e.From.Address || len(env.To) != 2 || env.To[1] != "audit@example.com" {
+		t.Fatal(env)
+	}
+
+	if bytes.Contains(sent, []byte("audit@example.com")) {
+		t.Fatal(string(sent))
+	}
+}
+
+// TestParse checks that a rendered message parses back into the same header and body.
+func TestParse(t *testing.T) {
+	e := testutils.TestEmail()
+	e.VisibleRecipients = true
+	e.AddAttachment("report.pdf", "application/pdf", strings.NewReader("%PDF"))
+
+	m, err := New(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(m.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed.Header) != len(m.Header) || parsed.Header.Get("To") != m.Header.Get("To") {
+		t.Fatal(parsed.Header)
+	}
+
+	if !bytes.Equal(parsed.Body.Bytes(), m.Body.Bytes()) || !bytes.Equal(parsed.Bytes(), m.Bytes()) {
+		t.Fatal(string(parsed.Bytes()))
+	}
+}
+
+// TestRenderEvent checks that an event's invitation is both an alternative to the body
+// and an attachment.
+func TestRenderEvent(t *testing.T) {
+	e := testutils.TestEmail()
+	e.Event = &ego.Event{
+		UID:       "launch@example.com",
+		Start:     time.Date(2026, 10, 31, 13, 0, 0, 0, time.UTC),
+		End:       time.Date(2026, 10, 31, 14, 0, 0, 0, time.UTC),
+		Organizer: e.From,
+	}
+
+	raw, err := Render(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, mediatype, params := parse(t, raw)
+	if mediatype != "multipart/mixed" {
+		t.Fatal(mediatype)
+	}
+
+	mixed := multipart.NewReader(msg.Body, params["boundary"])
+
+	alternative, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, _ = mime.ParseMediaType(alternative.Header.Get("Content-Type"))
+
+	alternatives := multipart.NewReader(alternative, params["boundary"])
+	var calendar []byte
+	for _, expected := range []string{"text/plain", "text/html", "text/calendar"} {
+		part, err := alternatives.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mediatype, params, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if mediatype != expected {
+			t.Fatal(mediatype)
+		}
+
+		if expected == "text/calendar" {
+			content, _ := ioutil.ReadAll(part)
+			calendar = content
+			if params["method"] != "REQUEST" || !strings.Contains(string(content), "UID:launch@example.com\r\n") {
+				t.Fatal(params, string(content))
+			}
+		}
+	}
+
+	invite, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediatype, params, _ = mime.ParseMediaType(invite.Header.Get("Content-Type"))
+	if invite.FileName() != "invite.ics" || mediatype != "text/calendar" || params["method"] != "REQUEST" {
+		t.Fatal(invite.Header)
+	}
+
+	// both copies are the same rendering, down to its DTSTAMP
+	attached, _ := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, invite))
+	if !bytes.Equal(attached, calendar) {
+		t.Fatal(string(attached))
+	}
+}
+
+// TestThreading checks that replies are rendered with their threading and list headers,
+// and that the Message-ID is reported back.
+func TestThreading(t *testing.T) {
+	var sent []byte
+	capture := SenderFunc(func(ctx context.Context, e *Envelope, msg []byte) error {
+		sent = msg
+		return nil
+	})
+
+	e := testutils.TestEmail()
+	e.SetInReplyTo("second@austen.name", []string{"first@austen.name"})
+	e.UnsubscribeURL = "https://austen.name/unsubscribe"
+
+	result, err := NewBackend(capture).SendEmail(context.Background(), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(sent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Header.Get("Message-Id") != "<"+result.HeaderMessageID+">" || !strings.HasSuffix(result.HeaderMessageID, "@austen.name") {
+		t.Fatal(msg.Header, result)
+	}
+
+	if msg.Header.Get("In-Reply-To") != "<second@austen.name>" || msg.Header.Get("References") != "<first@austen.name> <second@austen.name>" {
+		t.Fatal(msg.Header)
+	}
+
+	if msg.Header.Get("List-Unsubscribe") != "<https://austen.name/unsubscribe>" ||
+		msg.Header.Get("List-Unsubscribe-Post") != "List-Unsubscribe=One-Click" {
+		t.Fatal(msg.Header)
+	}
+}
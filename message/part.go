@@ -0,0 +1,179 @@
+package message
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/jarcoal/ego"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+)
+
+// Part is a MIME entity: its Content-* header fields, and either an encoded body or, for
+// multipart entities, the parts it's made of.
+type Part struct {
+	Header Header
+	Body   []byte
+	Parts  []*Part
+}
+
+// NewTextPart creates a quoted-printable part holding text of the given media type, such
+// as text/plain or text/html.
+func NewTextPart(mediatype, text string) *Part {
+	body := &bytes.Buffer{}
+	w := quotedprintable.NewWriter(body)
+	io.WriteString(w, text)
+	w.Close()
+
+	p := &Part{Body: body.Bytes()}
+	p.Header.Add("Content-Type", mime.FormatMediaType(mediatype, map[string]string{"charset": "utf-8"}))
+	p.Header.Add("Content-Transfer-Encoding", "quoted-printable")
+
+	return p
+}
+
+// NewBase64Part creates a part holding arbitrary data, base64 encoded.
+func NewBase64Part(contentType string, data []byte) *Part {
+	p := &Part{Body: encodeBase64(data)}
+	p.Header.Add("Content-Type", contentType)
+	p.Header.Add("Content-Transfer-Encoding", "base64")
+
+	return p
+}
+
+// NewAttachmentPart reads an attachment into a part, along with the headers that say how
+// it's to be displayed.
+func NewAttachmentPart(a *ego.Attachment) (*Part, error) {
+	data := []byte{}
+	if a.Data != nil {
+		var err error
+		if data, err = ioutil.ReadAll(a.Data); err != nil {
+			return nil, fmt.Errorf("failed to read %s attachment: %s", a.Name, err)
+		}
+	}
+
+	// the attachment's mimetype may have parameters of its own
+	mimetype, params, err := mime.ParseMediaType(a.Mimetype)
+	if err != nil {
+		mimetype, params = "application/octet-stream", map[string]string{}
+	}
+	params["name"] = a.Name
+
+	disposition := ego.DispositionAttachment
+	if a.Inline() {
+		disposition = ego.DispositionInline
+	}
+
+	p := NewBase64Part(mime.FormatMediaType(mimetype, params), data)
+	p.Header.Add("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Name}))
+
+	if a.ContentID != "" {
+		p.Header.Add("Content-ID", "<"+a.ContentID+">")
+	}
+
+	return p, nil
+}
+
+// NewMultipart creates a multipart entity of the given subtype (eg "mixed") from parts,
+// with a random boundary.
+func NewMultipart(subtype string, parts ...*Part) *Part {
+	p := &Part{Parts: parts}
+	p.SetContentType("multipart/"+subtype, nil)
+
+	return p
+}
+
+// SetContentType sets the part's Content-Type, adding the boundary of multipart parts to
+// params.
+func (p *Part) SetContentType(mediatype string, params map[string]string) {
+	if len(p.Parts) > 0 {
+		if params == nil {
+			params = map[string]string{}
+		}
+		if params["boundary"] = p.boundary(); params["boundary"] == "" {
+			params["boundary"] = newBoundary()
+		}
+	}
+
+	p.Header.Set("Content-Type", mime.FormatMediaType(mediatype, params))
+}
+
+// mediatype returns the part's media type, without parameters.
+func (p *Part) mediatype() string {
+	mediatype, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+	return mediatype
+}
+
+func (p *Part) boundary() string {
+	_, params, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params["boundary"]
+}
+
+// WriteTo writes the part's header and content.
+func (p *Part) WriteTo(w io.Writer) (int64, error) {
+	b := &bytes.Buffer{}
+	p.Header.WriteTo(b)
+	p.writeContent(b)
+
+	return b.WriteTo(w)
+}
+
+// Content returns the part's content without its header.
+func (p *Part) Content() []byte {
+	b := &bytes.Buffer{}
+	p.writeContent(b)
+
+	return b.Bytes()
+}
+
+func (p *Part) writeContent(b *bytes.Buffer) {
+	if len(p.Parts) == 0 {
+		b.Write(p.Body)
+		return
+	}
+
+	boundary := p.boundary()
+	for _, part := range p.Parts {
+		b.WriteString("\r\n--" + boundary + "\r\n")
+		part.WriteTo(b)
+	}
+	b.WriteString("\r\n--" + boundary + "--\r\n")
+}
+
+// Bytes returns the part's header and content.
+func (p *Part) Bytes() []byte {
+	b := &bytes.Buffer{}
+	p.WriteTo(b)
+
+	return b.Bytes()
+}
+
+// encodeBase64 encodes data in lines of 76 characters.
+func encodeBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	b := &bytes.Buffer{}
+
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	if encoded != "" {
+		b.WriteString(encoded + "\r\n")
+	}
+
+	return b.Bytes()
+}
+
+func newBoundary() string {
+	random := make([]byte, 16)
+	rand.Read(random)
+
+	return "ego-" + hex.EncodeToString(random)
+}
@@ -0,0 +1,152 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/jarcoal/ego/backends"
+	"net"
+	"net/smtp"
+	"os/exec"
+	"strings"
+)
+
+// SMTPOption configures an SMTP sender.
+type SMTPOption func(*smtpOptions)
+
+type smtpOptions struct {
+	requireTLS *bool
+}
+
+// RequireTLS sets whether messages may only be sent over TLS, so that a server that
+// doesn't offer STARTTLS (or a connection that had it stripped) is refused rather than
+// sent messages in plaintext.  By default it's required unless the server is on this
+// host.
+func RequireTLS(required bool) SMTPOption {
+	return func(o *smtpOptions) {
+		o.requireTLS = &required
+	}
+}
+
+// NewSMTPSender returns a Sender that delivers messages to the SMTP server at addr
+// (host:port), with the envelope as the MAIL FROM and RCPT TO addresses.  STARTTLS is used
+// when the server offers it (see RequireTLS), and auth (if not nil) to log in.  Errors
+// from before the message is handed over match backends.ErrNotSent; those while it's
+// being handed over don't, as the server may have accepted it anyway.
+func NewSMTPSender(addr string, auth smtp.Auth, opts ...SMTPOption) Sender {
+	o := &smtpOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return SenderFunc(func(ctx context.Context, env *Envelope, msg []byte) error {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return backends.NotSent(fmt.Errorf("invalid SMTP server address: %s", err))
+		}
+
+		requireTLS := !isLocal(host)
+		if o.requireTLS != nil {
+			requireTLS = *o.requireTLS
+		}
+
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return backends.NotSent(fmt.Errorf("failed to connect to SMTP server: %s", err))
+		}
+
+		// the conversation is cut short when the context is done
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		c, err := smtp.NewClient(conn, host)
+		if err != nil {
+			conn.Close()
+			return backends.NotSent(fmt.Errorf("failed to start SMTP session: %s", err))
+		}
+		defer c.Close()
+
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				return backends.NotSent(fmt.Errorf("failed to start TLS: %s", err))
+			}
+		} else if requireTLS {
+			return backends.NotSent(fmt.Errorf("SMTP server %s doesn't support STARTTLS", host))
+		}
+
+		if auth != nil {
+			if err := c.Auth(auth); err != nil {
+				return backends.NotSent(fmt.Errorf("SMTP authentication failed: %s", err))
+			}
+		}
+
+		if err := c.Mail(env.From); err != nil {
+			return backends.NotSent(fmt.Errorf("SMTP server refused sender %s: %s", env.From, err))
+		}
+		for _, to := range env.To {
+			if err := c.Rcpt(to); err != nil {
+				return backends.NotSent(fmt.Errorf("SMTP server refused recipient %s: %s", to, err))
+			}
+		}
+
+		w, err := c.Data()
+		if err != nil {
+			return fmt.Errorf("SMTP server refused message: %s", err)
+		}
+		if _, err := w.Write(msg); err != nil {
+			return fmt.Errorf("failed to write message: %s", err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("SMTP server refused message: %s", err)
+		}
+
+		// the message is accepted, so a failure to say goodbye mustn't have it sent again
+		c.Quit()
+		return nil
+	})
+}
+
+// isLocal reports whether the host is this one, where messages don't cross the network.
+func isLocal(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// NewSendmailSender returns a Sender that delivers messages with the sendmail command at
+// path ("/usr/sbin/sendmail" when empty), which most MTAs provide, giving it the envelope
+// sender with -f.  As sendmail only exits with an error when it didn't take the
+// message, its errors match backends.ErrNotSent.
+func NewSendmailSender(path string) Sender {
+	if path == "" {
+		path = "/usr/sbin/sendmail"
+	}
+
+	return SenderFunc(func(ctx context.Context, env *Envelope, msg []byte) error {
+		args := []string{"-i"}
+		if env.From != "" {
+			args = append(args, "-f", env.From)
+		}
+		args = append(append(args, "--"), env.To...)
+
+		// sendmail reads messages with the local line endings
+		msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+
+		stderr := &bytes.Buffer{}
+		cmd := exec.CommandContext(ctx, path, args...)
+		cmd.Stdin, cmd.Stderr = bytes.NewReader(msg), stderr
+
+		if err := cmd.Run(); err != nil {
+			return backends.NotSent(fmt.Errorf("sendmail failed: %s: %s", err, strings.TrimSpace(stderr.String())))
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,161 @@
+package message
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// smtpServer accepts a single SMTP session on a local port, recording the commands and
+// message it's sent.  If hangUp is set, it disconnects instead of answering QUIT.
+func smtpServer(t *testing.T, hangUp bool) (string, chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	session := make(chan []string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		lines := []string{}
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 test ESMTP")
+		for data := false; ; {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimSuffix(line, "\r\n")
+			lines = append(lines, line)
+
+			switch {
+			case data && line == ".":
+				data = false
+				reply("250 queued")
+			case data:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-test\r\n250 8BITMIME")
+			case line == "DATA":
+				data = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				if !hangUp {
+					reply("221 bye")
+				}
+				session <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		session <- lines
+	}()
+
+	return l.Addr().String(), session
+}
+
+// TestSMTPSender checks that messages are sent to an SMTP server with their envelope.
+func TestSMTPSender(t *testing.T) {
+	addr, session := smtpServer(t, false)
+
+	e := testutils.TestEmail()
+	e.To = e.To[:2]
+	e.ReturnPath = "bounces+zane=anastacio.co.uk@austen.name"
+
+	if _, err := NewBackend(NewSMTPSender(addr, nil)).SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Join(<-session, "\n")
+	for _, expected := range []string{
+		"MAIL FROM:<bounces+zane=anastacio.co.uk@austen.name>",
+		"RCPT TO:<zane@anastacio.co.uk>\nRCPT TO:<retta.ankunding@fletcher.biz>\nDATA\n",
+		"Subject: Test Subject\n",
+		"\n.\nQUIT",
+	} {
+		if !strings.Contains(lines, expected) {
+			t.Fatalf("missing %q in:\n%s", expected, lines)
+		}
+	}
+}
+
+// TestSMTPRequireTLS checks that messages aren't sent in plaintext when TLS is required.
+func TestSMTPRequireTLS(t *testing.T) {
+	addr, session := smtpServer(t, false)
+
+	env := &Envelope{From: "bounces@austen.name", To: []string{"zane@anastacio.co.uk"}}
+	if err := NewSMTPSender(addr, nil, RequireTLS(true)).SendMessage(context.Background(), env, []byte("Subject: hi\r\n\r\nbody\r\n")); !errors.Is(err, backends.ErrNotSent) {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Join(<-session, "\n"); strings.Contains(lines, "MAIL FROM") {
+		t.Fatal(lines)
+	}
+
+	for host, local := range map[string]bool{"localhost": true, "127.0.0.1": true, "::1": true, "smtp.austen.name": false, "10.0.0.1": false} {
+		if isLocal(host) != local {
+			t.Fatal(host)
+		}
+	}
+}
+
+// TestSMTPQuit checks that a message the server accepted isn't reported as failed when the
+// connection is lost while quitting.
+func TestSMTPQuit(t *testing.T) {
+	addr, session := smtpServer(t, true)
+
+	env := &Envelope{From: "bounces@austen.name", To: []string{"zane@anastacio.co.uk"}}
+	if err := NewSMTPSender(addr, nil).SendMessage(context.Background(), env, []byte("Subject: hi\r\n\r\nbody\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-session
+}
+
+// TestSendmailSender checks the arguments and input given to the sendmail command.
+func TestSendmailSender(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sendmail")
+
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncat > " + filepath.Join(dir, "msg") + "\n"
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	env := &Envelope{From: "bounces@austen.name", To: []string{"zane@anastacio.co.uk"}}
+	if err := NewSendmailSender(path).SendMessage(context.Background(), env, []byte("Subject: hi\r\n\r\nbody\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	msg, _ := os.ReadFile(filepath.Join(dir, "msg"))
+
+	if string(args) != "-i -f bounces@austen.name -- zane@anastacio.co.uk\n" || string(msg) != "Subject: hi\n\nbody\n" {
+		t.Fatal(string(args), string(msg))
+	}
+
+	// sendmail refused the message
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho 'no such user' >&2\nexit 67\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	err := NewSendmailSender(path).SendMessage(context.Background(), env, []byte("Subject: hi\r\n\r\nbody\r\n"))
+	if !errors.Is(err, backends.ErrNotSent) || !strings.Contains(err.Error(), "no such user") {
+		t.Fatal(err)
+	}
+}
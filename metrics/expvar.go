@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"expvar"
+	"sort"
+	"strings"
+)
+
+// NewExpvarRecorder returns a Recorder that publishes metrics as an expvar map with the
+// given name.  Counters are keyed by metric name and labels; histograms are reduced
+// to a "_count" and "_sum" pair, which is enough to derive rates and averages.
+func NewExpvarRecorder(name string) Recorder {
+	return &expvarRecorder{expvar.NewMap(name)}
+}
+
+type expvarRecorder struct {
+	vars *expvar.Map
+}
+
+func (r *expvarRecorder) AddCounter(name string, value float64, labels Labels) {
+	r.vars.AddFloat(expvarKey(name, labels), value)
+}
+
+func (r *expvarRecorder) ObserveHistogram(name string, value float64, labels Labels) {
+	r.vars.AddFloat(expvarKey(name+"_count", labels), 1)
+	r.vars.AddFloat(expvarKey(name+"_sum", labels), value)
+}
+
+// expvarKey renders a metric in the Prometheus text format, eg `name{a="b",c="d"}`.
+func expvarKey(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+`"`+v+`"`)
+	}
+	sort.Strings(pairs)
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
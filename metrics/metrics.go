@@ -0,0 +1,158 @@
+// Metrics middleware
+//
+// Records send volume, latency, payload size and failures for any backend.  Metrics are
+// reported through the Recorder interface so they can be exported to Prometheus, expvar
+// or anything else without ego depending on a metrics library.
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"net"
+	"time"
+)
+
+// Metric names, following Prometheus naming conventions.
+const (
+	EmailsSent           = "ego_emails_sent_total"
+	EmailsFailed         = "ego_emails_failed_total"
+	RecipientsSent       = "ego_recipients_sent_total"
+	RecipientsFailed     = "ego_recipients_failed_total"
+	RecipientsSuppressed = "ego_recipients_suppressed_total"
+	SendDuration         = "ego_send_duration_seconds"
+	PayloadSize          = "ego_payload_size_bytes"
+	LabelBackend         = "backend"
+	LabelTag             = "tag"
+	LabelErrorClass      = "error_class"
+)
+
+// Labels are the dimensions a metric is recorded with.
+type Labels map[string]string
+
+// Recorder receives the metrics produced by the middleware.  Counters only ever
+// increase; histogram observations are the raw values to be bucketed.
+type Recorder interface {
+	AddCounter(name string, value float64, labels Labels)
+	ObserveHistogram(name string, value float64, labels Labels)
+}
+
+// ErrorClasser can be implemented by errors to choose their own error_class label.
+type ErrorClasser interface {
+	ErrorClass() string
+}
+
+// NewMiddleware returns a middleware that records metrics for every email sent
+// through the wrapped backend.  Recipients that middleware further down dropped from
+// the email, as listed in the result's Suppressed field, are counted as suppressed
+// rather than sent, and an email that had all of its recipients dropped isn't counted.
+func NewMiddleware(r Recorder) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		name := backends.Name(next)
+
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			// measure the payload up front, as the backend may consume the attachments
+			size := payloadSize(e)
+
+			start := time.Now()
+			result, err := next.SendEmail(ctx, e)
+			elapsed := time.Since(start)
+
+			labels := Labels{LabelBackend: name, LabelTag: tagLabel(e)}
+
+			r.ObserveHistogram(SendDuration, elapsed.Seconds(), labels)
+			r.ObserveHistogram(PayloadSize, float64(size), labels)
+
+			recipients := len(e.To) + len(e.Cc) + len(e.Bcc)
+			if result != nil && len(result.Suppressed) > 0 {
+				recipients -= len(result.Suppressed)
+				r.AddCounter(RecipientsSuppressed, float64(len(result.Suppressed)), labels)
+			}
+
+			if err != nil {
+				failedLabels := Labels{LabelErrorClass: ErrorClass(err)}
+				for k, v := range labels {
+					failedLabels[k] = v
+				}
+				r.AddCounter(EmailsFailed, 1, failedLabels)
+
+				// an email that was split up may have reached some of its recipients
+				failed := recipients
+				var partial *backends.PartialError
+				if errors.As(err, &partial) {
+					failed = partial.Failed
+					r.AddCounter(RecipientsSent, float64(partial.Total-partial.Failed), labels)
+				}
+				r.AddCounter(RecipientsFailed, float64(failed), failedLabels)
+
+				return result, err
+			}
+
+			if recipients > 0 {
+				r.AddCounter(EmailsSent, 1, labels)
+				r.AddCounter(RecipientsSent, float64(recipients), labels)
+			}
+
+			return result, nil
+		})
+	}
+}
+
+// ErrorClass buckets an error into a coarse, low-cardinality class suitable for a label.
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var partial *backends.PartialError
+	if errors.As(err, &partial) {
+		return "partial"
+	}
+
+	var classer ErrorClasser
+	if errors.As(err, &classer) {
+		return classer.ErrorClass()
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+
+	return "other"
+}
+
+// tagLabel uses the first tag of the email, which keeps the label's cardinality down.
+func tagLabel(e *ego.Email) string {
+	if len(e.Tags) == 0 {
+		return ""
+	}
+	return e.Tags[0]
+}
+
+// payloadSize approximates the size of the email: the subject, bodies and any
+// attachments whose size can be determined without consuming them.
+func payloadSize(e *ego.Email) int64 {
+	size := int64(len(e.Subject) + len(e.HTMLBody) + len(e.TextBody))
+
+	for _, attachment := range e.Attachments {
+		if n := attachment.Size(); n > 0 {
+			size += n
+		}
+	}
+
+	return size
+}
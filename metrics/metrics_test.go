@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/backends/dummy"
+	"github.com/jarcoal/ego/testutils"
+	"strings"
+	"testing"
+)
+
+type testRecorder struct {
+	counters   map[string]float64
+	histograms map[string][]float64
+	labels     map[string]Labels
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{
+		counters:   make(map[string]float64),
+		histograms: make(map[string][]float64),
+		labels:     make(map[string]Labels),
+	}
+}
+
+func (r *testRecorder) AddCounter(name string, value float64, labels Labels) {
+	r.counters[name] += value
+	r.labels[name] = labels
+}
+
+func (r *testRecorder) ObserveHistogram(name string, value float64, labels Labels) {
+	r.histograms[name] = append(r.histograms[name], value)
+	r.labels[name] = labels
+}
+
+// TestSent checks the metrics recorded for a successful send.
+func TestSent(t *testing.T) {
+	r := newTestRecorder()
+	b := backends.Chain(dummy.NewBackend(nil), NewMiddleware(r))
+
+	e := testutils.TestEmail()
+	e.AddAttachment("test.txt", "text/plain", strings.NewReader("hello"))
+
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.counters[EmailsSent] != 1 {
+		t.FailNow()
+	}
+
+	if r.counters[RecipientsSent] != float64(len(e.To)) {
+		t.FailNow()
+	}
+
+	if _, ok := r.counters[EmailsFailed]; ok {
+		t.FailNow()
+	}
+
+	if len(r.histograms[SendDuration]) != 1 {
+		t.FailNow()
+	}
+
+	size := len(e.Subject) + len(e.HTMLBody) + len(e.TextBody) + len("hello")
+	if r.histograms[PayloadSize][0] != float64(size) {
+		t.FailNow()
+	}
+
+	labels := r.labels[EmailsSent]
+	if labels[LabelBackend] != "dummy" || labels[LabelTag] != e.Tags[0] {
+		t.FailNow()
+	}
+}
+
+// TestFailed checks the metrics recorded for a failed send.
+func TestFailed(t *testing.T) {
+	r := newTestRecorder()
+	b := backends.Chain(backends.BackendFunc(func(context.Context, *ego.Email) (*backends.Result, error) {
+		return nil, errors.New("boom")
+	}), NewMiddleware(r))
+
+	if _, err := b.SendEmail(context.Background(), testutils.TestEmail()); err == nil {
+		t.FailNow()
+	}
+
+	if r.counters[EmailsFailed] != 1 {
+		t.FailNow()
+	}
+
+	if _, ok := r.counters[EmailsSent]; ok {
+		t.FailNow()
+	}
+
+	if r.counters[RecipientsFailed] != float64(len(testutils.TestRecipients())) {
+		t.FailNow()
+	}
+
+	labels := r.labels[EmailsFailed]
+	if labels[LabelErrorClass] != "other" || labels[LabelBackend] != "unknown" {
+		t.FailNow()
+	}
+}
+
+// TestExpvarKey checks that expvar keys are rendered deterministically.
+func TestExpvarKey(t *testing.T) {
+	key := expvarKey(EmailsSent, Labels{LabelTag: "welcome", LabelBackend: "mandrill"})
+
+	if key != `ego_emails_sent_total{backend="mandrill",tag="welcome"}` {
+		t.Fatal(key)
+	}
+}
+
+// TestPartiallyFailed checks that the recipients of an email that was split up are counted
+// by their own outcome.
+func TestPartiallyFailed(t *testing.T) {
+	r := newTestRecorder()
+	b := backends.Chain(backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		return backends.SendAll(ctx, backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if e.To[0].Email.Address == "zane@anastacio.co.uk" {
+				return nil, errors.New("boom")
+			}
+			return &backends.Result{}, nil
+		}), []*ego.Email{{To: e.To[:1]}, {To: e.To[1:]}}, 1)
+	}), NewMiddleware(r))
+
+	e := testutils.TestEmail()
+	if _, err := b.SendEmail(context.Background(), e); err == nil {
+		t.FailNow()
+	}
+
+	if r.counters[EmailsFailed] != 1 || r.counters[RecipientsFailed] != 1 || r.counters[RecipientsSent] != float64(len(e.To)-1) {
+		t.Fatal(r.counters)
+	}
+
+	if r.labels[EmailsFailed][LabelErrorClass] != "partial" {
+		t.Fatal(r.labels[EmailsFailed])
+	}
+}
+
+// TestSuppressed checks that recipients dropped before reaching the backend aren't counted
+// as sent.
+func TestSuppressed(t *testing.T) {
+	r := newTestRecorder()
+	suppress := 1
+
+	b := backends.Chain(backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		result := &backends.Result{}
+		for _, recip := range e.To[:suppress] {
+			result.Suppressed = append(result.Suppressed, recip.Email)
+		}
+		return result, nil
+	}), NewMiddleware(r))
+
+	e := testutils.TestEmail()
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.counters[EmailsSent] != 1 || r.counters[RecipientsSent] != float64(len(e.To)-1) || r.counters[RecipientsSuppressed] != 1 {
+		t.Fatal(r.counters)
+	}
+
+	// every recipient dropped
+	suppress = len(e.To)
+	b.SendEmail(context.Background(), e)
+
+	if r.counters[EmailsSent] != 1 || r.counters[RecipientsSent] != float64(len(e.To)-1) || r.counters[RecipientsSuppressed] != float64(len(e.To)+1) {
+		t.Fatal(r.counters)
+	}
+}
@@ -0,0 +1,92 @@
+package pgpmime
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// GPG is a Keyring that uses the gpg command and the keys in its keyring.  Secret keys
+// must be usable without a passphrase prompt, such as through a preset gpg-agent.
+type GPG struct {
+	// Path of the gpg command, "gpg" when empty.
+	Path string
+
+	// Homedir is the GnuPG home directory, the default one when empty.
+	Homedir string
+}
+
+var _ Keyring = (*GPG)(nil)
+
+func (g *GPG) command(ctx context.Context, args ...string) *exec.Cmd {
+	path := g.Path
+	if path == "" {
+		path = "gpg"
+	}
+
+	args = append([]string{"--batch", "--no-tty", "--armor"}, args...)
+	if g.Homedir != "" {
+		args = append([]string{"--homedir", g.Homedir}, args...)
+	}
+
+	return exec.CommandContext(ctx, path, args...)
+}
+
+func (g *GPG) run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := g.command(ctx, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(stdin), stdout, stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// armored output goes into the message, which has CRLF line endings
+	out := bytes.ReplaceAll(stdout.Bytes(), []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(out, []byte("\n"), []byte("\r\n")), nil
+}
+
+// hasKey checks for a public key for address.  gpg exits with an error when there isn't one.
+func (g *GPG) hasKey(ctx context.Context, address string) (bool, error) {
+	err := g.command(ctx, "--list-keys", "<"+address+">").Run()
+	if _, ok := err.(*exec.ExitError); ok {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("gpg failed: %s", err)
+	}
+	return true, nil
+}
+
+// Sign makes a detached signature with SHA-256.
+func (g *GPG) Sign(ctx context.Context, signer string, data []byte) ([]byte, string, error) {
+	signature, err := g.run(ctx, data, "--digest-algo", "SHA256", "--local-user", "<"+signer+">", "--detach-sign")
+	if err != nil {
+		return nil, "", err
+	}
+	return signature, "pgp-sha256", nil
+}
+
+// Encrypt encrypts to the recipients' keys, trusting every key in the keyring.
+func (g *GPG) Encrypt(ctx context.Context, recipients []string, data []byte) ([]byte, error) {
+	args := []string{"--trust-model", "always", "--encrypt"}
+	missing := []string{}
+
+	for _, r := range recipients {
+		ok, err := g.hasKey(ctx, r)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			missing = append(missing, r)
+			continue
+		}
+		args = append(args, "--recipient", "<"+r+">")
+	}
+
+	if len(missing) > 0 {
+		return nil, &MissingKeyError{missing}
+	}
+
+	return g.run(ctx, data, args...)
+}
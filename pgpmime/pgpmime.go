@@ -0,0 +1,158 @@
+// OpenPGP/MIME signing and encryption
+//
+// Signs rendered messages as multipart/signed and encrypts them as multipart/encrypted,
+// per RFC 3156.  The OpenPGP operations themselves are left to a Keyring, such as GPG.
+
+package pgpmime
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/message"
+	"net/mail"
+	"strings"
+)
+
+// ErrNoKey is returned by a Keyring that has no key for an address.
+var ErrNoKey = errors.New("no OpenPGP key")
+
+// MissingKeyError is returned when some of a message's recipients have no key, so the
+// message can't be encrypted to all of them.  Nothing is sent.
+type MissingKeyError struct {
+	Addresses []string
+}
+
+func (m *MissingKeyError) Error() string {
+	return "no OpenPGP key for " + strings.Join(m.Addresses, ", ")
+}
+
+// Unwrap allows errors.Is(err, ErrNoKey).
+func (m *MissingKeyError) Unwrap() error {
+	return ErrNoKey
+}
+
+// Keyring performs OpenPGP operations with the keys it holds.
+type Keyring interface {
+	// Sign returns an ASCII armored detached signature of data by the key of signer, and
+	// the micalg parameter naming the hash it used, such as "pgp-sha256".
+	Sign(ctx context.Context, signer string, data []byte) (signature []byte, micalg string, err error)
+
+	// Encrypt returns data encrypted to the keys of all of the recipients, ASCII armored.
+	// It returns a *MissingKeyError if any of them have no key.
+	Encrypt(ctx context.Context, recipients []string, data []byte) ([]byte, error)
+}
+
+// Config describes how to sign and encrypt messages.  Either or both may be done.
+type Config struct {
+	Keyring Keyring
+
+	// Messages are signed by the key of Signer when Sign is set.  Signer defaults to the
+	// message's From address.
+	Sign   bool
+	Signer string
+
+	// Messages are encrypted to every recipient (To, Cc and Bcc) when Encrypt is set, and
+	// to the signer when signing too, so that the sender can read what they sent.
+	Encrypt bool
+}
+
+// Sign replaces the body of the message with a multipart/signed body holding the
+// original and its detached signature.
+func Sign(ctx context.Context, m *message.Message, keyring Keyring, signer string) error {
+	signature, micalg, err := keyring.Sign(ctx, signer, m.Body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	signaturePart := &message.Part{Body: signature}
+	signaturePart.Header.Add("Content-Type", `application/pgp-signature; name="signature.asc"`)
+	signaturePart.Header.Add("Content-Description", "OpenPGP digital signature")
+
+	body := message.NewMultipart("signed", m.Body, signaturePart)
+	body.SetContentType("multipart/signed", map[string]string{
+		"protocol": "application/pgp-signature",
+		"micalg":   micalg,
+	})
+	m.Body = body
+
+	return nil
+}
+
+// Encrypt replaces the body of the message with a multipart/encrypted body that holds the
+// original encrypted to the keys of the recipients.
+func Encrypt(ctx context.Context, m *message.Message, keyring Keyring, recipients []string) error {
+	encrypted, err := keyring.Encrypt(ctx, recipients, m.Body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	control := &message.Part{Body: []byte("Version: 1\r\n")}
+	control.Header.Add("Content-Type", "application/pgp-encrypted")
+	control.Header.Add("Content-Description", "PGP/MIME version identification")
+
+	data := &message.Part{Body: encrypted}
+	data.Header.Add("Content-Type", `application/octet-stream; name="encrypted.asc"`)
+	data.Header.Add("Content-Description", "OpenPGP encrypted message")
+	data.Header.Add("Content-Disposition", `inline; filename="encrypted.asc"`)
+
+	body := message.NewMultipart("encrypted", control, data)
+	body.SetContentType("multipart/encrypted", map[string]string{"protocol": "application/pgp-encrypted"})
+	m.Body = body
+
+	return nil
+}
+
+// NewMiddleware returns a middleware that signs and/or encrypts every message before it
+// reaches the wrapped sender.  Signing comes first, so the signature is encrypted too.  A
+// message that can't be signed or encrypted isn't sent, and its error matches
+// backends.ErrNotSent.
+func NewMiddleware(c Config) message.Middleware {
+	return func(next message.Sender) message.Sender {
+		return message.SenderFunc(func(ctx context.Context, env *message.Envelope, msg []byte) error {
+			protected, err := protect(ctx, c, env, msg)
+			if err != nil {
+				return backends.NotSent(err)
+			}
+
+			return next.SendMessage(ctx, env, protected)
+		})
+	}
+}
+
+// protect signs and/or encrypts the message as the Config says.
+func protect(ctx context.Context, c Config, env *message.Envelope, msg []byte) ([]byte, error) {
+	m, err := message.Parse(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := c.Signer
+	if signer == "" {
+		if from, err := mail.ParseAddress(m.Header.Get("From")); err == nil {
+			signer = from.Address
+		}
+	}
+
+	if c.Sign {
+		if signer == "" {
+			return nil, errors.New("OpenPGP signing needs a signer or a From address")
+		}
+		if err := Sign(ctx, m, c.Keyring, signer); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Encrypt {
+		recipients := append([]string{}, env.To...)
+		if c.Sign {
+			recipients = append(recipients, signer)
+		}
+
+		if err := Encrypt(ctx, m, c.Keyring, recipients); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.Bytes(), nil
+}
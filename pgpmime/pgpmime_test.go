@@ -0,0 +1,213 @@
+package pgpmime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/message"
+	"github.com/jarcoal/ego/testutils"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKeyring pretends to sign and encrypt, recording what it was given.
+type testKeyring struct {
+	keys       map[string]bool
+	signed     []byte
+	signer     string
+	recipients []string
+}
+
+func (k *testKeyring) Sign(ctx context.Context, signer string, data []byte) ([]byte, string, error) {
+	k.signed, k.signer = data, signer
+	return []byte("-----BEGIN PGP SIGNATURE-----\r\n\r\nsig\r\n-----END PGP SIGNATURE-----\r\n"), "pgp-sha256", nil
+}
+
+func (k *testKeyring) Encrypt(ctx context.Context, recipients []string, data []byte) ([]byte, error) {
+	missing := []string{}
+	for _, r := range recipients {
+		if !k.keys[r] {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &MissingKeyError{missing}
+	}
+
+	k.recipients = recipients
+	return append([]byte("ENCRYPTED\r\n"), data...), nil
+}
+
+// capture returns a sender that keeps the last message sent through it.
+func capture(sent *[]byte) message.Sender {
+	return message.SenderFunc(func(ctx context.Context, env *message.Envelope, msg []byte) error {
+		*sent = msg
+		return nil
+	})
+}
+
+// readMultipart checks a message's Content-Type, returning its parts.
+func readMultipart(t *testing.T, raw []byte, expected string) (map[string]string, []*multipart.Part, [][]byte) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediatype, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if mediatype != expected {
+		t.Fatal(msg.Header.Get("Content-Type"))
+	}
+
+	parts, contents := []*multipart.Part{}, [][]byte{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		content, _ := ioutil.ReadAll(part)
+		parts, contents = append(parts, part), append(contents, content)
+	}
+
+	return params, parts, contents
+}
+
+func testEmail() *ego.Email {
+	e := testutils.TestEmail()
+	e.To = e.To[:2]
+	e.AddAttachment("report.pdf", "application/pdf", strings.NewReader("%PDF"))
+	return e
+}
+
+// TestSignAndEncrypt checks the RFC 3156 structure of signed and encrypted messages.
+func TestSignAndEncrypt(t *testing.T) {
+	e := testEmail()
+	keyring := &testKeyring{keys: map[string]bool{e.To[0].Email.Address: true, e.To[1].Email.Address: true, e.From.Address: true}}
+
+	var sent []byte
+	b := message.NewBackend(message.Chain(capture(&sent), NewMiddleware(Config{Keyring: keyring, Sign: true, Encrypt: true})))
+
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if keyring.signer != e.From.Address {
+		t.Fatal(keyring.signer)
+	}
+
+	if len(keyring.recipients) != 3 || keyring.recipients[2] != e.From.Address {
+		t.Fatal(keyring.recipients)
+	}
+
+	params, parts, contents := readMultipart(t, sent, "multipart/encrypted")
+	if params["protocol"] != "application/pgp-encrypted" || len(parts) != 2 {
+		t.Fatal(params)
+	}
+
+	if parts[0].Header.Get("Content-Type") != "application/pgp-encrypted" || string(contents[0]) != "Version: 1\r\n" {
+		t.Fatal(string(contents[0]))
+	}
+
+	if !strings.HasPrefix(parts[1].Header.Get("Content-Type"), "application/octet-stream") {
+		t.Fatal(parts[1].Header)
+	}
+
+	// what was encrypted is the signed message, attachments and all
+	signed := bytes.TrimPrefix(contents[1], []byte("ENCRYPTED\r\n"))
+	params, parts, contents = readMultipart(t, signed, "multipart/signed")
+
+	if params["protocol"] != "application/pgp-signature" || params["micalg"] != "pgp-sha256" || len(parts) != 2 {
+		t.Fatal(params)
+	}
+
+	if !strings.HasPrefix(parts[1].Header.Get("Content-Type"), "application/pgp-signature") {
+		t.Fatal(parts[1].Header)
+	}
+
+	if !bytes.HasPrefix(keyring.signed, []byte("Content-Type: multipart/mixed;")) ||
+		!bytes.Contains(keyring.signed, []byte("filename=report.pdf")) {
+		t.Fatal(string(keyring.signed))
+	}
+}
+
+// TestMissingKey checks that nothing is sent when a recipient has no key.
+func TestMissingKey(t *testing.T) {
+	e := testEmail()
+	keyring := &testKeyring{keys: map[string]bool{e.To[0].Email.Address: true}}
+
+	var sent []byte
+	b := message.NewBackend(message.Chain(capture(&sent), NewMiddleware(Config{Keyring: keyring, Encrypt: true})))
+
+	_, err := b.SendEmail(context.Background(), e)
+
+	missing := &MissingKeyError{}
+	if !errors.As(err, &missing) || !errors.Is(err, ErrNoKey) || !errors.Is(err, backends.ErrNotSent) {
+		t.Fatal(err)
+	}
+
+	if len(missing.Addresses) != 1 || missing.Addresses[0] != e.To[1].Email.Address || sent != nil {
+		t.Fatal(missing.Addresses)
+	}
+}
+
+// TestGPG round trips a message through the gpg command, when it's installed.
+func TestGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg isn't installed")
+	}
+
+	e := testEmail()
+	e.To = e.To[:1]
+
+	homedir := t.TempDir()
+	t.Cleanup(func() { exec.Command("gpgconf", "--homedir", homedir, "--kill", "gpg-agent").Run() })
+
+	keyring := &GPG{Homedir: homedir}
+	for _, address := range []string{e.From.Address, e.To[0].Email.Address} {
+		if _, err := keyring.run(context.Background(), nil, "--passphrase", "", "--quick-generate-key", address, "future-default", "default", "never"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sent []byte
+	b := message.NewBackend(message.Chain(capture(&sent), NewMiddleware(Config{Keyring: keyring, Sign: true, Encrypt: true})))
+
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, contents := readMultipart(t, sent, "multipart/encrypted")
+
+	signed, err := keyring.run(context.Background(), contents[1], "--decrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the signed part is taken exactly as it appears between the boundaries
+	params, _, contents := readMultipart(t, signed, "multipart/signed")
+	body := signed[bytes.Index(signed, []byte("\r\n\r\n"))+4:]
+	content := strings.Split(string(body), "--"+params["boundary"])[1]
+	content = strings.TrimSuffix(strings.TrimPrefix(content, "\r\n"), "\r\n")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "content"), []byte(content), 0600)
+	os.WriteFile(filepath.Join(dir, "signature.asc"), contents[1], 0600)
+
+	if _, err := keyring.run(context.Background(), nil, "--verify", filepath.Join(dir, "signature.asc"), filepath.Join(dir, "content")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = keyring.Encrypt(context.Background(), []string{"nobody@example.com"}, []byte("hi"))
+	if !errors.Is(err, ErrNoKey) {
+		t.Fatal(err)
+	}
+}
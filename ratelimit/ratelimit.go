@@ -0,0 +1,270 @@
+// Rate limiting middleware
+//
+// Throttles sends on our end with token buckets, so that bursts don't get rejected by
+// the provider with 429s.  Limits can be set on emails and recipients overall, per
+// SubAccount and per recipient domain.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket: Rate tokens are added per second, up to Burst at a time.
+// A zero Rate means no limit.  A zero Burst is taken as 1, which paces sends evenly.
+//
+// An email needing more tokens than Burst, such as one with more recipients, is sent
+// once the bucket is full and leaves it in debt, so that later sends wait for it to be
+// paid off.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Config describes the limits to apply.  Every limit that is set must be satisfied
+// before an email is sent.
+type Config struct {
+	// Emails sent, and recipients sent to, overall.
+	Emails, Recipients Limit
+
+	// Emails sent by each SubAccount.
+	SubAccountEmails Limit
+
+	// Recipients sent to at each domain, eg "gmail.com".
+	DomainRecipients Limit
+
+	// Return a *LimitError straight away instead of waiting for the limit to allow the send.
+	FailFast bool
+}
+
+// LimitError is returned in fail-fast mode when a send would exceed a limit.
+type LimitError struct {
+	// Which limit was hit, eg "emails" or "domain recipients (gmail.com)".
+	Limit string
+
+	// How long until the send would be allowed.
+	RetryAfter time.Duration
+}
+
+func (l *LimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s, retry after %s", l.Limit, l.RetryAfter)
+}
+
+// ErrorClass labels the error for the metrics middleware.
+func (l *LimitError) ErrorClass() string {
+	return "rate_limited"
+}
+
+// Is reports that the email wasn't sent, see backends.ErrNotSent.
+func (l *LimitError) Is(target error) bool {
+	return target == backends.ErrNotSent
+}
+
+// NewMiddleware returns a middleware that limits the rate of sends through the wrapped backend.
+// When not failing fast, sends block until allowed or until their context is done.  Each
+// backend wrapped by the middleware has limits of its own.
+func NewMiddleware(c Config) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		l := newLimiter(c)
+
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if err := l.wait(ctx, e); err != nil {
+				return nil, err
+			}
+			return next.SendEmail(ctx, e)
+		})
+	}
+}
+
+// sweepInterval is how often buckets that have refilled are dropped, so that those of
+// domains and SubAccounts that are no longer sent to don't pile up.
+const sweepInterval = time.Minute
+
+type limiter struct {
+	config Config
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newLimiter(c Config) *limiter {
+	for _, limit := range []*Limit{&c.Emails, &c.Recipients, &c.SubAccountEmails, &c.DomainRecipients} {
+		if limit.Burst <= 0 {
+			limit.Burst = 1
+		}
+	}
+
+	return &limiter{config: c, buckets: make(map[string]*bucket), now: time.Now, lastSweep: time.Now()}
+}
+
+// demand is a number of tokens wanted from a bucket.
+type demand struct {
+	name   string
+	limit  Limit
+	tokens float64
+}
+
+// demands works out what the email needs from each of the configured limits.
+func (l *limiter) demands(e *ego.Email) []demand {
+	demands := []demand{
+		{"emails", l.config.Emails, 1},
+		{"recipients", l.config.Recipients, float64(len(e.To) + len(e.Cc) + len(e.Bcc))},
+	}
+
+	if e.SubAccount != "" {
+		demands = append(demands, demand{"subaccount emails (" + e.SubAccount + ")", l.config.SubAccountEmails, 1})
+	}
+
+	if l.config.DomainRecipients.Rate > 0 {
+		perDomain := make(map[string]float64)
+		order := []string{}
+
+		for _, recipients := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+			for _, recip := range recipients {
+				address := strings.ToLower(recip.Email.Address)
+				domain := address[strings.LastIndex(address, "@")+1:]
+
+				if _, ok := perDomain[domain]; !ok {
+					order = append(order, domain)
+				}
+				perDomain[domain]++
+			}
+		}
+
+		for _, domain := range order {
+			demands = append(demands, demand{"domain recipients (" + domain + ")", l.config.DomainRecipients, perDomain[domain]})
+		}
+	}
+
+	return demands
+}
+
+// wait reserves the tokens the email needs and then waits until they're available.
+func (l *limiter) wait(ctx context.Context, e *ego.Email) error {
+	demands := l.demands(e)
+
+	l.mu.Lock()
+	now := l.now()
+	l.sweep(now)
+
+	// check the buckets first, so that nothing is reserved if we fail fast
+	longest := time.Duration(0)
+	var longestDemand demand
+
+	for _, d := range demands {
+		if d.limit.Rate <= 0 || d.tokens == 0 {
+			continue
+		}
+		if delay := l.bucket(d, now).delay(d.tokens, now); delay > longest {
+			longest, longestDemand = delay, d
+		}
+	}
+
+	if longest > 0 && l.config.FailFast {
+		l.mu.Unlock()
+		return &LimitError{longestDemand.name, longest}
+	}
+
+	for _, d := range demands {
+		if d.limit.Rate > 0 && d.tokens > 0 {
+			l.bucket(d, now).take(d.tokens)
+		}
+	}
+	l.mu.Unlock()
+
+	if longest == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(longest)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// hand back the tokens we never used
+		l.mu.Lock()
+		for _, d := range demands {
+			if d.limit.Rate > 0 && d.tokens > 0 {
+				l.bucket(d, l.now()).give(d.tokens)
+			}
+		}
+		l.mu.Unlock()
+
+		return backends.NotSent(ctx.Err())
+	}
+}
+
+// sweep drops the buckets that are full again, which are no different from the new ones
+// bucket would create, once every sweepInterval.  l.mu must be held.
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for name, b := range l.buckets {
+		if b.delay(float64(b.limit.Burst), now) == 0 {
+			delete(l.buckets, name)
+		}
+	}
+}
+
+// bucket returns the bucket for the demand, creating it full if needed.  l.mu must be held.
+func (l *limiter) bucket(d demand, now time.Time) *bucket {
+	b, ok := l.buckets[d.name]
+	if !ok {
+		b = &bucket{limit: d.limit, tokens: float64(d.limit.Burst), last: now}
+		l.buckets[d.name] = b
+	}
+	return b
+}
+
+// bucket is a token bucket whose balance may go negative, to account for sends
+// that have reserved tokens and are waiting on them.
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// delay refills the bucket up to now, and returns how long until n tokens are available,
+// or until it's full when n is more than it holds.
+func (b *bucket) delay(n float64, now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.limit.Rate
+		if burst := float64(b.limit.Burst); b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+
+	if burst := float64(b.limit.Burst); n > burst {
+		n = burst
+	}
+
+	if deficit := n - b.tokens; deficit > 0 {
+		return time.Duration(deficit / b.limit.Rate * float64(time.Second))
+	}
+	return 0
+}
+
+func (b *bucket) take(n float64) {
+	b.tokens -= n
+}
+
+func (b *bucket) give(n float64) {
+	b.tokens += n
+	if burst := float64(b.limit.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+}
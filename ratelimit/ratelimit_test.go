@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/backends/dummy"
+	"github.com/jarcoal/ego/metrics"
+	"github.com/jarcoal/ego/testutils"
+	"testing"
+	"time"
+)
+
+// TestFailFast checks that a typed error is returned once a limit is used up.
+func TestFailFast(t *testing.T) {
+	b := backends.Chain(dummy.NewBackend(nil), NewMiddleware(Config{
+		Emails:   Limit{Rate: 0.01, Burst: 2},
+		FailFast: true,
+	}))
+
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.SendEmail(ctx, testutils.TestEmail()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := b.SendEmail(ctx, testutils.TestEmail())
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatal(err)
+	}
+
+	if limitErr.Limit != "emails" || limitErr.RetryAfter <= 0 {
+		t.FailNow()
+	}
+
+	if !errors.Is(err, backends.ErrNotSent) || metrics.ErrorClass(err) != "rate_limited" {
+		t.FailNow()
+	}
+}
+
+// TestDomainRecipients checks that recipients are limited per domain.
+func TestDomainRecipients(t *testing.T) {
+	b := backends.Chain(dummy.NewBackend(nil), NewMiddleware(Config{
+		DomainRecipients: Limit{Rate: 0.01, Burst: 1},
+		FailFast:         true,
+	}))
+
+	ctx := context.Background()
+
+	e := ego.NewEmail()
+	e.AddRecipient("", "jane@example.com", nil)
+	if _, err := b.SendEmail(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+
+	// a different domain has its own bucket
+	e = ego.NewEmail()
+	e.AddRecipient("", "jane@example.org", nil)
+	if _, err := b.SendEmail(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+
+	e = ego.NewEmail()
+	e.AddRecipient("", "john@example.com", nil)
+	if _, err := b.SendEmail(ctx, e); err == nil {
+		t.FailNow()
+	}
+}
+
+// TestBlocking checks that sends wait for the limit instead of failing.
+func TestBlocking(t *testing.T) {
+	b := backends.Chain(dummy.NewBackend(nil), NewMiddleware(Config{
+		Recipients: Limit{Rate: 800, Burst: 8},
+	}))
+
+	ctx := context.Background()
+	start := time.Now()
+
+	// the test email has 8 recipients, so the second send has to wait ~10ms
+	for i := 0; i < 2; i++ {
+		if _, err := b.SendEmail(ctx, testutils.TestEmail()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if time.Since(start) < 5*time.Millisecond {
+		t.FailNow()
+	}
+}
+
+// TestCancel checks that a waiting send gives up when its context is done.
+func TestCancel(t *testing.T) {
+	b := backends.Chain(dummy.NewBackend(nil), NewMiddleware(Config{
+		SubAccountEmails: Limit{Rate: 0.01, Burst: 1},
+	}))
+
+	e := testutils.TestEmail()
+	e.SubAccount = "test"
+
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := b.SendEmail(ctx, e); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+}
+
+// TestOversized checks that an email needing more than a limit's burst is sent once the
+// bucket is full, and that the sends after it wait for the debt.
+func TestOversized(t *testing.T) {
+	b := backends.Chain(dummy.NewBackend(nil), NewMiddleware(Config{
+		Recipients: Limit{Rate: 0.01},
+		FailFast:   true,
+	}))
+
+	ctx := context.Background()
+
+	// the test email has 8 recipients, more than the default burst of 1
+	if _, err := b.SendEmail(ctx, testutils.TestEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	var limitErr *LimitError
+	if _, err := b.SendEmail(ctx, testutils.TestEmail()); !errors.As(err, &limitErr) {
+		t.Fatal(err)
+	}
+
+	// 7 tokens in debt plus the one needed, at 0.01 a second
+	if limitErr.RetryAfter < 799*time.Second || limitErr.RetryAfter > 800*time.Second {
+		t.Fatal(limitErr.RetryAfter)
+	}
+}
+
+// TestPerBackend checks that each wrapped backend has limits of its own.
+func TestPerBackend(t *testing.T) {
+	m := NewMiddleware(Config{Emails: Limit{Rate: 0.01, Burst: 1}, FailFast: true})
+	first := backends.Chain(dummy.NewBackend(nil), m)
+	second := backends.Chain(dummy.NewBackend(nil), m)
+
+	ctx := context.Background()
+
+	if _, err := first.SendEmail(ctx, testutils.TestEmail()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.SendEmail(ctx, testutils.TestEmail()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSweep checks that buckets are dropped once they've refilled.
+func TestSweep(t *testing.T) {
+	now := time.Now()
+
+	l := newLimiter(Config{DomainRecipients: Limit{Rate: 1, Burst: 2}})
+	l.now, l.lastSweep = func() time.Time { return now }, now
+
+	for _, address := range []string{"jane@example.com", "john@example.org"} {
+		e := ego.NewEmail()
+		e.AddRecipient("", address, nil)
+		if err := l.wait(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(l.buckets) != 2 {
+		t.FailNow()
+	}
+
+	now = now.Add(sweepInterval)
+	l.sweep(now)
+
+	if len(l.buckets) != 0 {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,181 @@
+// Sandbox middleware
+//
+// Keeps staging and development environments from emailing real people, by redirecting
+// every recipient to a catch-all address and/or dropping recipients that aren't on an
+// allowlist.
+
+package sandbox
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+// OriginalToHeader holds the recipients an email was originally addressed to.
+const OriginalToHeader = "X-Original-To"
+
+// DefaultSubjectPrefix is used when Config.SubjectPrefix is empty.
+const DefaultSubjectPrefix = "[sandbox]"
+
+// Config describes the sandbox.  The zero value disables it.
+type Config struct {
+	// Address that every recipient not on the allowlist is redirected to.
+	RedirectTo string
+
+	// Domains ("example.com") and addresses ("jane@example.com") that may still receive
+	// email.  Without a RedirectTo, any other recipients are dropped.
+	Allow []string
+
+	// Prepended to the subject of redirected emails, along with the original recipients.
+	SubjectPrefix string
+}
+
+// Enabled reports whether the config does anything.
+func (c Config) Enabled() bool {
+	return c.RedirectTo != "" || len(c.Allow) > 0
+}
+
+// ConfigFromEnv reads the sandbox config from the environment:
+//
+//	EGO_SANDBOX_REDIRECT_TO     catch-all address
+//	EGO_SANDBOX_ALLOW           comma-separated domains and addresses
+//	EGO_SANDBOX_SUBJECT_PREFIX  subject prefix
+func ConfigFromEnv() Config {
+	c := Config{
+		RedirectTo:    strings.TrimSpace(os.Getenv("EGO_SANDBOX_REDIRECT_TO")),
+		SubjectPrefix: os.Getenv("EGO_SANDBOX_SUBJECT_PREFIX"),
+	}
+
+	for _, allowed := range strings.Split(os.Getenv("EGO_SANDBOX_ALLOW"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" {
+			c.Allow = append(c.Allow, allowed)
+		}
+	}
+
+	return c
+}
+
+// NewMiddleware returns a middleware that sandboxes every email sent through the wrapped
+// backend.  If the config isn't enabled, emails pass through untouched.
+//
+// Redirected emails go to the catch-all address, with their original recipients listed
+// in the X-Original-To header and the subject.  When only redirecting, backends that can
+// override recipients on the provider's end (see backends.RecipientOverrider) are asked
+// to do so instead, which keeps per-recipient personalization intact.
+//
+// If no recipients are left after filtering, nothing is sent and an empty result is returned.
+func NewMiddleware(c Config) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		if !c.Enabled() {
+			return next
+		}
+
+		var overrider backends.RecipientOverrider
+		native := len(c.Allow) == 0 && backends.As(next, &overrider) && overrider.SupportsRecipientOverride()
+
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if native {
+				e = e.Clone()
+				c.annotate(e, recipientAddresses(e.To, e.Cc, e.Bcc))
+				return next.SendEmail(backends.WithRecipientOverride(ctx, c.RedirectTo), e)
+			}
+
+			e, redirected := c.apply(e)
+
+			if len(e.To)+len(e.Cc)+len(e.Bcc) == 0 {
+				return &backends.Result{}, nil
+			}
+
+			if len(redirected) > 0 {
+				c.annotate(e, redirected)
+			}
+
+			return next.SendEmail(ctx, e)
+		})
+	}
+}
+
+// apply filters the recipients of a copy of the email against the allowlist, redirecting
+// any that aren't allowed to the catch-all address.  It returns the new email and the
+// addresses that were redirected.
+func (c Config) apply(e *ego.Email) (*ego.Email, []string) {
+	e = e.Clone()
+	redirected := []string{}
+
+	filter := func(recipients []*ego.Recipient) []*ego.Recipient {
+		kept := make([]*ego.Recipient, 0, len(recipients))
+
+		for _, recip := range recipients {
+			if c.allowed(recip.Email.Address) {
+				kept = append(kept, recip)
+			} else if c.RedirectTo != "" {
+				redirected = append(redirected, recip.Email.Address)
+			}
+		}
+
+		return kept
+	}
+
+	e.To = filter(e.To)
+	e.Cc = filter(e.Cc)
+	e.Bcc = filter(e.Bcc)
+
+	// everyone redirected is collapsed into a single recipient
+	if len(redirected) > 0 {
+		e.To = append(e.To, &ego.Recipient{
+			Email:           &mail.Address{Address: c.RedirectTo},
+			TemplateContext: map[string]string{},
+		})
+	}
+
+	return e, redirected
+}
+
+// allowed checks the address against the allowlist.
+func (c Config) allowed(address string) bool {
+	address = strings.ToLower(address)
+	domain := address[strings.LastIndex(address, "@")+1:]
+
+	for _, allowed := range c.Allow {
+		allowed = strings.ToLower(allowed)
+
+		if strings.Contains(allowed, "@") {
+			if allowed == address {
+				return true
+			}
+		} else if allowed == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// annotate records the original recipients on the email.
+func (c Config) annotate(e *ego.Email, original []string) {
+	prefix := c.SubjectPrefix
+	if prefix == "" {
+		prefix = DefaultSubjectPrefix
+	}
+
+	joined := strings.Join(original, ", ")
+
+	e.Headers.Set(OriginalToHeader, joined)
+	e.Subject = prefix + " (" + joined + ") " + e.Subject
+}
+
+func recipientAddresses(lists ...[]*ego.Recipient) []string {
+	addresses := []string{}
+
+	for _, recipients := range lists {
+		for _, recip := range recipients {
+			addresses = append(addresses, recip.Email.Address)
+		}
+	}
+
+	return addresses
+}
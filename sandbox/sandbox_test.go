@@ -0,0 +1,126 @@
+package sandbox
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"strings"
+	"testing"
+)
+
+type captureBackend struct {
+	sent     *ego.Email
+	override string
+	native   bool
+}
+
+func (c *captureBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	c.sent = e
+	c.override = backends.RecipientOverride(ctx)
+	return &backends.Result{}, nil
+}
+
+func (c *captureBackend) SupportsRecipientOverride() bool {
+	return c.native
+}
+
+// TestDisabled checks that the zero config leaves emails alone.
+func TestDisabled(t *testing.T) {
+	capture := &captureBackend{}
+	b := backends.Chain(capture, NewMiddleware(Config{}))
+
+	e := testutils.TestEmail()
+	b.SendEmail(context.Background(), e)
+
+	if capture.sent != e {
+		t.FailNow()
+	}
+}
+
+// TestRedirect checks that all recipients are redirected to the catch-all address.
+func TestRedirect(t *testing.T) {
+	capture := &captureBackend{}
+	b := backends.Chain(capture, NewMiddleware(Config{RedirectTo: "qa@example.com"}))
+
+	e := testutils.TestEmail()
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := capture.sent
+
+	if len(sent.To) != 1 || sent.To[0].Email.Address != "qa@example.com" {
+		t.FailNow()
+	}
+
+	if !strings.Contains(sent.Headers.Get(OriginalToHeader), e.To[0].Email.Address) {
+		t.FailNow()
+	}
+
+	if !strings.HasPrefix(sent.Subject, DefaultSubjectPrefix) || !strings.HasSuffix(sent.Subject, e.Subject) {
+		t.FailNow()
+	}
+
+	// the original email shouldn't have been touched
+	if len(e.To) != len(testutils.TestRecipients()) || e.Headers.Get(OriginalToHeader) != "" {
+		t.FailNow()
+	}
+}
+
+// TestAllow checks that only allowlisted recipients are kept.
+func TestAllow(t *testing.T) {
+	capture := &captureBackend{}
+	b := backends.Chain(capture, NewMiddleware(Config{Allow: []string{"orpha.info", "ludwig@paula.co.uk"}}))
+
+	if _, err := b.SendEmail(context.Background(), testutils.TestEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(capture.sent.To) != 2 {
+		t.FailNow()
+	}
+
+	// nothing left to send to
+	capture.sent = nil
+	b = backends.Chain(capture, NewMiddleware(Config{Allow: []string{"example.com"}}))
+
+	if _, err := b.SendEmail(context.Background(), testutils.TestEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	if capture.sent != nil {
+		t.FailNow()
+	}
+}
+
+// TestNativeOverride checks that backends that can override recipients are asked to.
+func TestNativeOverride(t *testing.T) {
+	capture := &captureBackend{native: true}
+	b := backends.Chain(capture, NewMiddleware(Config{RedirectTo: "qa@example.com"}))
+
+	e := testutils.TestEmail()
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if capture.override != "qa@example.com" {
+		t.FailNow()
+	}
+
+	if len(capture.sent.To) != len(e.To) {
+		t.FailNow()
+	}
+}
+
+// TestConfigFromEnv checks that the config can be read from the environment.
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("EGO_SANDBOX_REDIRECT_TO", "qa@example.com")
+	t.Setenv("EGO_SANDBOX_ALLOW", "example.com, jane@smith.com")
+
+	c := ConfigFromEnv()
+
+	if !c.Enabled() || c.RedirectTo != "qa@example.com" || len(c.Allow) != 2 {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,201 @@
+// S/MIME signing and encryption
+//
+// Signs rendered messages with a detached multipart/signed signature, and encrypts them
+// as application/pkcs7-mime enveloped data (RFC 8551), for recipients that require it.
+
+package smime
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/message"
+	"strings"
+)
+
+// ErrNoCertificate is returned by a CertificateStore that has no certificate for an address.
+var ErrNoCertificate = errors.New("no S/MIME certificate")
+
+// CertificateStore looks up the certificates that messages are encrypted to.
+type CertificateStore interface {
+	Certificate(ctx context.Context, address string) (*x509.Certificate, error)
+}
+
+// Certificates is a CertificateStore of certificates by address.
+type Certificates map[string]*x509.Certificate
+
+// Certificate returns the certificate for address, ignoring case.
+func (c Certificates) Certificate(ctx context.Context, address string) (*x509.Certificate, error) {
+	for a, cert := range c {
+		if strings.EqualFold(a, address) {
+			return cert, nil
+		}
+	}
+	return nil, ErrNoCertificate
+}
+
+// MissingCertificateError is returned when some of a message's recipients have no
+// certificate, so the message can't be encrypted to all of them.  Nothing is sent.
+type MissingCertificateError struct {
+	Addresses []string
+}
+
+func (m *MissingCertificateError) Error() string {
+	return "no S/MIME certificate for " + strings.Join(m.Addresses, ", ")
+}
+
+// Unwrap allows errors.Is(err, ErrNoCertificate).
+func (m *MissingCertificateError) Unwrap() error {
+	return ErrNoCertificate
+}
+
+// Config describes how to sign and encrypt messages.  Either or both may be done.
+type Config struct {
+	// Messages are signed when Key is set, with the sender's Certificate and any
+	// intermediate certificates needed to verify it included in the signature.  Key is
+	// an *rsa.PrivateKey or *ecdsa.PrivateKey.
+	Certificate   *x509.Certificate
+	Key           crypto.Signer
+	Intermediates []*x509.Certificate
+
+	// Messages are encrypted when Recipients is set, to the certificate of every
+	// recipient (To, Cc and Bcc) and to the sender's Certificate, so that the sender can
+	// read what they sent.
+	Recipients CertificateStore
+}
+
+// Sign replaces the body of the message with a multipart/signed body holding the
+// original and its detached signature.  The key must be the certificate's.
+func Sign(m *message.Message, cert *x509.Certificate, key crypto.Signer, intermediates []*x509.Certificate) error {
+	if err := checkSigner(cert, key); err != nil {
+		return err
+	}
+
+	signature, err := signDetached(m.Body.Bytes(), cert, key, intermediates)
+	if err != nil {
+		return err
+	}
+
+	signaturePart := message.NewBase64Part(`application/pkcs7-signature; name="smime.p7s"`, signature)
+	signaturePart.Header.Add("Content-Disposition", `attachment; filename="smime.p7s"`)
+
+	body := message.NewMultipart("signed", m.Body, signaturePart)
+	body.SetContentType("multipart/signed", map[string]string{
+		"protocol": "application/pkcs7-signature",
+		"micalg":   "sha-256",
+	})
+	m.Body = body
+
+	return nil
+}
+
+// Encrypt replaces the body of the message with an application/pkcs7-mime body that
+// holds the original encrypted to each of the certificates.
+func Encrypt(m *message.Message, certs []*x509.Certificate) error {
+	encrypted, err := encrypt(m.Body.Bytes(), certs)
+	if err != nil {
+		return err
+	}
+
+	body := message.NewBase64Part(`application/pkcs7-mime; smime-type=enveloped-data; name="smime.p7m"`, encrypted)
+	body.Header.Add("Content-Disposition", `attachment; filename="smime.p7m"`)
+	m.Body = body
+
+	return nil
+}
+
+// checkSigner makes sure that the certificate and key can sign together.
+func checkSigner(cert *x509.Certificate, key crypto.Signer) error {
+	if cert == nil || key == nil {
+		return errors.New("signing S/MIME messages needs both a certificate and a key")
+	}
+
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.PublicKey) {
+		return errors.New("the S/MIME key doesn't belong to the certificate")
+	}
+
+	return nil
+}
+
+// NewMiddleware returns a middleware that signs and/or encrypts every message before it
+// reaches the wrapped sender.  Signing comes first, so the signature is encrypted too.
+// If the Config has a Key without its Certificate, every send fails with an error.  A
+// message that can't be signed or encrypted isn't sent, and its error matches
+// backends.ErrNotSent.
+func NewMiddleware(c Config) message.Middleware {
+	var configErr error
+	if c.Key != nil {
+		configErr = checkSigner(c.Certificate, c.Key)
+	}
+
+	return func(next message.Sender) message.Sender {
+		return message.SenderFunc(func(ctx context.Context, env *message.Envelope, msg []byte) error {
+			if configErr != nil {
+				return backends.NotSent(configErr)
+			}
+
+			protected, err := protect(ctx, c, env, msg)
+			if err != nil {
+				return backends.NotSent(err)
+			}
+
+			return next.SendMessage(ctx, env, protected)
+		})
+	}
+}
+
+// protect signs and/or encrypts the message as the Config says.
+func protect(ctx context.Context, c Config, env *message.Envelope, msg []byte) ([]byte, error) {
+	m, err := message.Parse(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Key != nil {
+		if err := Sign(m, c.Certificate, c.Key, c.Intermediates); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Recipients != nil {
+		certs, err := recipientCertificates(ctx, c.Recipients, env.To)
+		if err != nil {
+			return nil, err
+		}
+		if c.Certificate != nil {
+			certs = append(certs, c.Certificate)
+		}
+
+		if err := Encrypt(m, certs); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.Bytes(), nil
+}
+
+// recipientCertificates looks up the certificate of every address, failing if any are
+// missing.
+func recipientCertificates(ctx context.Context, store CertificateStore, addresses []string) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	missing := []string{}
+
+	for _, address := range addresses {
+		cert, err := store.Certificate(ctx, address)
+		if errors.Is(err, ErrNoCertificate) || err == nil && cert == nil {
+			missing = append(missing, address)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(missing) > 0 {
+		return nil, &MissingCertificateError{missing}
+	}
+
+	return certs, nil
+}
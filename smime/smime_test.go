@@ -0,0 +1,318 @@
+package smime
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/message"
+	"github.com/jarcoal/ego/testutils"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+var ecdsaKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+// newCertificate creates a self-signed certificate for address.
+func newCertificate(t *testing.T, address string, key crypto.Signer) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: address},
+		EmailAddresses: []string{address},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// capture returns a sender that keeps the last message sent through it.
+func capture(sent *[]byte) message.Sender {
+	return message.SenderFunc(func(ctx context.Context, env *message.Envelope, msg []byte) error {
+		*sent = msg
+		return nil
+	})
+}
+
+// readPart returns the decoded content of a part.
+func readPart(t *testing.T, p *multipart.Part) []byte {
+	data, err := ioutil.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+		if data, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return data
+}
+
+// verifySignature checks a detached signature of content, returning the signer's certificate.
+func verifySignature(t *testing.T, content, signature []byte) *x509.Certificate {
+	ci := contentInfo{}
+	if _, err := asn1.Unmarshal(signature, &ci); err != nil || !ci.ContentType.Equal(oidSignedData) {
+		t.Fatal(err)
+	}
+
+	sd := signedData{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(sd.Certificates.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si := sd.SignerInfos[0]
+	if si.SID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatal("signer isn't identified by its certificate")
+	}
+
+	// the attributes hold the digest of the content, and are what's signed
+	attributes := []attribute{}
+	if _, err := asn1.UnmarshalWithParams(si.SignedAttributes.FullBytes, &attributes, "set,tag:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(content)
+	found := false
+	for _, a := range attributes {
+		value := []byte{}
+		if a.Type.Equal(oidAttributeDigest) {
+			asn1.Unmarshal(a.Values.Bytes, &value)
+			found = bytes.Equal(value, digest[:])
+		}
+	}
+	if !found {
+		t.Fatal("content digest doesn't match")
+	}
+
+	signed, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: si.SignedAttributes.Bytes})
+	algorithm := x509.SHA256WithRSA
+	if si.SignatureAlgorithm.Algorithm.Equal(oidECDSAWithSHA256) {
+		algorithm = x509.ECDSAWithSHA256
+	}
+	if err := cert.CheckSignature(algorithm, signed, si.Signature); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// decrypt decrypts enveloped data with the key of the given recipient.
+func decrypt(t *testing.T, data []byte, cert *x509.Certificate, key *rsa.PrivateKey) []byte {
+	ci := contentInfo{}
+	if _, err := asn1.Unmarshal(data, &ci); err != nil || !ci.ContentType.Equal(oidEnvelopedData) {
+		t.Fatal(err)
+	}
+
+	ed := envelopedData{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range ed.RecipientInfos {
+		if r.RID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+
+		contentKey, err := rsa.DecryptPKCS1v15(rand.Reader, key, r.EncryptedKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iv := []byte{}
+		asn1.Unmarshal(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv)
+
+		block, _ := aes.NewCipher(contentKey)
+		content := make([]byte, len(ed.EncryptedContentInfo.EncryptedContent))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, ed.EncryptedContentInfo.EncryptedContent)
+
+		return content[:len(content)-int(content[len(content)-1])]
+	}
+
+	t.Fatal("not encrypted to the certificate")
+	return nil
+}
+
+// TestSign checks that messages get a detached signature of their original body.
+func TestSign(t *testing.T) {
+	for _, key := range []crypto.Signer{rsaKey, ecdsaKey} {
+		cert := newCertificate(t, "jade@austen.name", key)
+
+		var sent []byte
+		b := message.NewBackend(message.Chain(capture(&sent), NewMiddleware(Config{Certificate: cert, Key: key})))
+
+		e := testutils.TestEmail()
+		if _, err := b.SendEmail(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := mail.ReadMessage(bytes.NewReader(sent))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mediatype, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if mediatype != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" || params["micalg"] != "sha-256" {
+			t.Fatal(msg.Header.Get("Content-Type"))
+		}
+
+		// the signed part is taken exactly as it appears between the boundaries
+		body, _ := ioutil.ReadAll(msg.Body)
+		delimiter := "--" + params["boundary"]
+		parts := strings.Split(string(body), delimiter)
+		content := []byte(strings.TrimSuffix(strings.TrimPrefix(parts[1], "\r\n"), "\r\n"))
+
+		if !bytes.Contains(content, []byte("Content-Type: multipart/alternative")) {
+			t.Fatal(string(content))
+		}
+
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		reader.NextPart()
+		signaturePart, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if signer := verifySignature(t, content, readPart(t, signaturePart)); !signer.Equal(cert) {
+			t.Fatal("wrong signer")
+		}
+	}
+}
+
+// TestIncompleteSigner checks that a key without its certificate is refused rather than
+// sent unsigned or panicking.
+func TestIncompleteSigner(t *testing.T) {
+	other := newCertificate(t, "jade@austen.name", ecdsaKey)
+
+	for _, c := range []Config{{Key: rsaKey}, {Key: rsaKey, Certificate: other}} {
+		var sent []byte
+		b := message.NewBackend(message.Chain(capture(&sent), NewMiddleware(c)))
+
+		if _, err := b.SendEmail(context.Background(), testutils.TestEmail()); !errors.Is(err, backends.ErrNotSent) || sent != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := message.New(testutils.TestEmail())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Sign(m, nil, rsaKey, nil); err == nil {
+		t.FailNow()
+	}
+}
+
+// TestEncrypt checks that messages are encrypted to every recipient and the sender.
+func TestEncrypt(t *testing.T) {
+	e := testutils.TestEmail()
+	e.To = e.To[:2]
+
+	keys := map[string]*rsa.PrivateKey{}
+	certs := Certificates{}
+	for _, address := range []string{e.To[0].Email.Address, e.To[1].Email.Address, e.From.Address} {
+		keys[address], _ = rsa.GenerateKey(rand.Reader, 2048)
+		certs[address] = newCertificate(t, address, keys[address])
+	}
+
+	senderCert := certs[e.From.Address]
+	delete(certs, e.From.Address)
+
+	var sent []byte
+	b := message.NewBackend(message.Chain(capture(&sent), NewMiddleware(Config{
+		Certificate: senderCert,
+		Key:         keys[e.From.Address],
+		Recipients:  certs,
+	})))
+
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(sent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediatype, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if mediatype != "application/pkcs7-mime" || params["smime-type"] != "enveloped-data" {
+		t.Fatal(msg.Header.Get("Content-Type"))
+	}
+
+	if msg.Header.Get("Subject") != e.Subject {
+		t.Fatal(msg.Header)
+	}
+
+	body, _ := ioutil.ReadAll(msg.Body)
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for address, key := range keys {
+		cert := certs[address]
+		if cert == nil {
+			cert = senderCert
+		}
+
+		// each can read the signed body
+		content := decrypt(t, data, cert, key)
+		if !bytes.HasPrefix(content, []byte("Content-Type: multipart/signed;")) {
+			t.Fatal(string(content))
+		}
+	}
+}
+
+// TestMissingCertificate checks that nothing is sent when a recipient has no certificate.
+func TestMissingCertificate(t *testing.T) {
+	e := testutils.TestEmail()
+	e.To = e.To[:2]
+
+	certs := Certificates{e.To[0].Email.Address: newCertificate(t, e.To[0].Email.Address, rsaKey)}
+
+	var sent []byte
+	b := message.NewBackend(message.Chain(capture(&sent), NewMiddleware(Config{Recipients: certs})))
+
+	_, err := b.SendEmail(context.Background(), e)
+
+	missing := &MissingCertificateError{}
+	if !errors.As(err, &missing) || !errors.Is(err, ErrNoCertificate) || !errors.Is(err, backends.ErrNotSent) {
+		t.Fatal(err)
+	}
+
+	if len(missing.Addresses) != 1 || missing.Addresses[0] != e.To[1].Email.Address || sent != nil {
+		t.Fatal(missing.Addresses)
+	}
+}
@@ -0,0 +1,183 @@
+package suppression
+
+import (
+	"context"
+	"github.com/jarcoal/ego/events"
+	"sync"
+	"time"
+)
+
+// BounceLog remembers the recent soft bounces of addresses, to count them against a
+// threshold.
+type BounceLog interface {
+	// Add records a soft bounce of the address at t, and returns how many it has had
+	// since the given time, including this one.  A bounce with the same id as one already
+	// recorded is only counted once, as webhooks may be delivered more than once; those
+	// without an id are always counted.
+	Add(ctx context.Context, address, id string, t, since time.Time) (int, error)
+
+	// Clear forgets the address's soft bounces.
+	Clear(ctx context.Context, address string) error
+}
+
+// NewMemoryBounceLog returns a BounceLog that keeps its bounces in memory, for as long as
+// window (the EventConfig's SoftBounceWindow) counts them.  Without a window, they're only
+// dropped when their address bounces again or is cleared.
+func NewMemoryBounceLog(window time.Duration) BounceLog {
+	return &memoryBounceLog{bounces: make(map[string][]bounce), window: window, lastPrune: time.Now()}
+}
+
+// pruneInterval is how often the bounces of every address are pruned, so that those of
+// addresses that never bounce again don't pile up.
+const pruneInterval = time.Hour
+
+type memoryBounceLog struct {
+	mu      sync.Mutex
+	bounces map[string][]bounce
+
+	window    time.Duration
+	lastPrune time.Time
+}
+
+type bounce struct {
+	id string
+	at time.Time
+}
+
+func (m *memoryBounceLog) Add(ctx context.Context, address, id string, t, since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+
+	key := normalize(address)
+
+	// bounces from before the window are of no use any more
+	recent := []bounce{}
+	for _, b := range m.bounces[key] {
+		if !b.at.Before(since) && (id == "" || b.id != id) {
+			recent = append(recent, b)
+		}
+	}
+	if !t.Before(since) {
+		recent = append(recent, bounce{id, t})
+	}
+
+	if len(recent) == 0 {
+		delete(m.bounces, key)
+	} else {
+		m.bounces[key] = recent
+	}
+
+	return len(recent), nil
+}
+
+// prune drops the bounces of every address that are older than the window, once every
+// pruneInterval.  m.mu must be held.
+func (m *memoryBounceLog) prune() {
+	now := time.Now()
+	if m.window <= 0 || now.Sub(m.lastPrune) < pruneInterval {
+		return
+	}
+	m.lastPrune = now
+	since := now.Add(-m.window)
+
+	for key, bounces := range m.bounces {
+		recent := bounces[:0]
+		for _, b := range bounces {
+			if !b.at.Before(since) {
+				recent = append(recent, b)
+			}
+		}
+
+		if len(recent) == 0 {
+			delete(m.bounces, key)
+		} else {
+			m.bounces[key] = recent
+		}
+	}
+}
+
+func (m *memoryBounceLog) Clear(ctx context.Context, address string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.bounces, normalize(address))
+	return nil
+}
+
+// EventConfig describes which delivery events suppress their recipient, and for how long.
+// Hard bounces, spam complaints and unsubscribes always do.
+type EventConfig struct {
+	// Soft bounces suppress an address once it's had SoftBounceLimit of them within
+	// SoftBounceWindow, such as 3 in 7 days.  They never do when SoftBounceLimit is zero.
+	// A delivery to the address starts its count over.
+	SoftBounceLimit  int
+	SoftBounceWindow time.Duration
+
+	// How long each kind of suppression lasts.  Zero never expires.
+	HardBounceExpiry, SoftBounceExpiry, SpamComplaintExpiry time.Duration
+
+	// Bounces counts soft bounces.  Defaults to NewMemoryBounceLog, which is only good for
+	// a single process receiving every webhook.
+	Bounces BounceLog
+}
+
+// NewEventCallback returns a callback for the handlers of the events package that adds
+// addresses to s as their delivery events warrant, so that the middleware from
+// NewMiddleware drops them from later sends.  An address that's already suppressed for
+// longer is left as it is.
+func NewEventCallback(s Suppressor, c EventConfig) events.Callback {
+	if c.Bounces == nil {
+		c.Bounces = NewMemoryBounceLog(c.SoftBounceWindow)
+	}
+
+	return func(ctx context.Context, ev *events.Event) error {
+		at := ev.Timestamp
+		if at.IsZero() {
+			at = time.Now()
+		}
+
+		var reason Reason
+		var expiry time.Duration
+
+		switch ev.Type {
+		case events.HardBounce:
+			reason, expiry = HardBounce, c.HardBounceExpiry
+		case events.SpamComplaint:
+			reason, expiry = SpamComplaint, c.SpamComplaintExpiry
+		case events.Unsubscribed:
+			reason = Unsubscribed
+		case events.SoftBounce:
+			if c.SoftBounceLimit <= 0 {
+				return nil
+			}
+
+			count, err := c.Bounces.Add(ctx, ev.Recipient, ev.ID, at, at.Add(-c.SoftBounceWindow))
+			if err != nil || count < c.SoftBounceLimit {
+				return err
+			}
+			reason, expiry = SoftBounce, c.SoftBounceExpiry
+		case events.Delivered:
+			return c.Bounces.Clear(ctx, ev.Recipient)
+		default:
+			return nil
+		}
+
+		entry := &Entry{Address: ev.Recipient, Reason: reason, CreatedAt: at}
+		if expiry > 0 {
+			entry.ExpiresAt = at.Add(expiry)
+		}
+
+		existing, err := s.Lookup(ctx, ev.Recipient)
+		if err != nil {
+			return err
+		}
+		if existing != nil && (existing.ExpiresAt.IsZero() ||
+			!entry.ExpiresAt.IsZero() && existing.ExpiresAt.After(entry.ExpiresAt)) {
+			return nil
+		}
+
+		return s.Suppress(ctx, entry)
+	}
+}
@@ -0,0 +1,165 @@
+package suppression
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/events"
+	"github.com/jarcoal/ego/testutils"
+	"testing"
+	"time"
+)
+
+var day = 24 * time.Hour
+
+// TestEventCallback checks that hard bounces and complaints suppress their recipient.
+func TestEventCallback(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+	now := time.Now()
+
+	callback := NewEventCallback(s, EventConfig{HardBounceExpiry: 30 * day})
+
+	for _, ev := range []*events.Event{
+		{Type: events.HardBounce, Recipient: "zane@anastacio.co.uk", Timestamp: now},
+		{Type: events.SpamComplaint, Recipient: "retta.ankunding@fletcher.biz", Timestamp: now},
+		{Type: events.Opened, Recipient: "freida@orpha.info", Timestamp: now},
+		{Type: events.SoftBounce, Recipient: "freida@orpha.info", Timestamp: now},
+	} {
+		if err := callback(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry, _ := s.Lookup(ctx, "zane@anastacio.co.uk")
+	if entry == nil || entry.Reason != HardBounce || !entry.ExpiresAt.Equal(now.Add(30*day)) {
+		t.Fatal(entry)
+	}
+
+	entry, _ = s.Lookup(ctx, "retta.ankunding@fletcher.biz")
+	if entry == nil || entry.Reason != SpamComplaint || !entry.ExpiresAt.IsZero() {
+		t.Fatal(entry)
+	}
+
+	// soft bounces are ignored without a limit
+	if entry, _ := s.Lookup(ctx, "freida@orpha.info"); entry != nil {
+		t.Fatal(entry)
+	}
+
+	// and permanent suppressions aren't shortened
+	s.Suppress(ctx, &Entry{Address: "corrine@remington.io", Reason: Manual})
+	callback(ctx, &events.Event{Type: events.HardBounce, Recipient: "corrine@remington.io", Timestamp: now})
+
+	if entry, _ := s.Lookup(ctx, "corrine@remington.io"); entry.Reason != Manual {
+		t.Fatal(entry)
+	}
+}
+
+// TestSoftBounceLimit checks that soft bounces suppress once there are enough of them
+// within the window.
+func TestSoftBounceLimit(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+	start := time.Now().Add(-20 * day)
+
+	callback := NewEventCallback(s, EventConfig{SoftBounceLimit: 3, SoftBounceWindow: 7 * day, SoftBounceExpiry: 5 * day})
+	bounce := func(at time.Time) {
+		ev := &events.Event{Type: events.SoftBounce, ID: at.String(), Recipient: "zane@anastacio.co.uk", Timestamp: at}
+		if err := callback(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	suppressed := func() bool {
+		entry, _ := s.Lookup(ctx, "zane@anastacio.co.uk")
+		return entry != nil
+	}
+
+	// two bounces, then a third after the first has left the window
+	bounce(start)
+	bounce(start.Add(2 * day))
+	bounce(start.Add(8 * day))
+	if suppressed() {
+		t.FailNow()
+	}
+
+	// the same bounce again, from a retried webhook
+	bounce(start.Add(8 * day))
+	if suppressed() {
+		t.FailNow()
+	}
+
+	// a delivery starts the count over
+	callback(ctx, &events.Event{Type: events.Delivered, Recipient: "zane@anastacio.co.uk", Timestamp: start.Add(9 * day)})
+	bounce(start.Add(10 * day))
+	if suppressed() {
+		t.FailNow()
+	}
+
+	bounce(start.Add(11 * day))
+	bounce(start.Add(12 * day))
+	if suppressed() {
+		t.Fatal("suppression should have expired three days ago")
+	}
+
+	// the same three bounces, recently
+	now := time.Now()
+	bounce(now.Add(-2 * day))
+	bounce(now.Add(-day))
+	bounce(now)
+	if !suppressed() {
+		t.FailNow()
+	}
+}
+
+// TestBounceLog checks that bounces are told apart by their id, and pruned whether or not
+// their address bounces again.
+func TestBounceLog(t *testing.T) {
+	ctx := context.Background()
+	log := NewMemoryBounceLog(day).(*memoryBounceLog)
+	now := time.Now()
+
+	for i, id := range []string{"a", "b", "a", "", ""} {
+		count, err := log.Add(ctx, "zane@anastacio.co.uk", id, now, now.Add(-day))
+		if expected := []int{1, 2, 2, 3, 4}[i]; err != nil || count != expected {
+			t.Fatal(id, count, err)
+		}
+	}
+
+	// a bounce from long ago, counted in a window of its own
+	log.Add(ctx, "retta.ankunding@fletcher.biz", "c", now.Add(-2*day), now.Add(-3*day))
+
+	// nothing is pruned until it's time to
+	log.Add(ctx, "freida@orpha.info", "d", now, now.Add(-7*day))
+	if len(log.bounces) != 3 {
+		t.Fatal(log.bounces)
+	}
+
+	// and then against the log's window, not the one of the bounce being added
+	log.lastPrune = now.Add(-2 * pruneInterval)
+	log.Add(ctx, "freida@orpha.info", "e", now, now.Add(-7*day))
+	if len(log.bounces) != 2 || log.bounces["retta.ankunding@fletcher.biz"] != nil {
+		t.Fatal(log.bounces)
+	}
+}
+
+// TestEventsToSends checks that a bounce reported by a webhook stops later sends to the
+// recipient.
+func TestEventsToSends(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	var sent *ego.Email
+	b := backends.Chain(captureBackend(&sent), NewMiddleware(s))
+
+	e := testutils.TestEmail()
+	NewEventCallback(s, EventConfig{})(ctx, &events.Event{Type: events.HardBounce, Recipient: e.To[1].Email.Address})
+
+	result, err := b.SendEmail(ctx, e)
+	if err != nil || len(result.Suppressed) != 1 || result.Suppressed[0].Address != e.To[1].Email.Address {
+		t.Fatal(result, err)
+	}
+
+	if len(sent.To) != len(e.To)-1 {
+		t.FailNow()
+	}
+}
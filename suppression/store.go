@@ -0,0 +1,156 @@
+package suppression
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// NewMemory returns a Suppressor that keeps its entries in memory.
+func NewMemory() Suppressor {
+	return &memoryStore{entries: make(map[string]*Entry)}
+}
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	entries map[string]*Entry
+}
+
+func (m *memoryStore) Lookup(ctx context.Context, address string) (*Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.entries[normalize(address)]
+	if !ok || entry.Expired(time.Now()) {
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+func (m *memoryStore) Suppress(ctx context.Context, entry *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[normalize(entry.Address)] = entry
+	return nil
+}
+
+func (m *memoryStore) Unsuppress(ctx context.Context, address string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, normalize(address))
+	return nil
+}
+
+// NewFile returns a Suppressor backed by a JSON file at path, which is created if it
+// doesn't exist.  Entries are held in memory and the file is rewritten on every change,
+// so it suits lists of up to a few hundred thousand addresses.
+func NewFile(path string) (Suppressor, error) {
+	f := &fileStore{path: path, memoryStore: memoryStore{entries: make(map[string]*Entry)}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entries := []*Entry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		f.entries[normalize(entry.Address)] = entry
+	}
+
+	return f, nil
+}
+
+type fileStore struct {
+	memoryStore
+	path string
+}
+
+func (f *fileStore) Suppress(ctx context.Context, entry *Entry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := normalize(entry.Address)
+	previous, had := f.entries[key]
+
+	f.entries[key] = entry
+	if err := f.save(); err != nil {
+		f.restore(key, previous, had)
+		return err
+	}
+
+	return nil
+}
+
+func (f *fileStore) Unsuppress(ctx context.Context, address string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := normalize(address)
+	previous, had := f.entries[key]
+
+	delete(f.entries, key)
+	if err := f.save(); err != nil {
+		f.restore(key, previous, had)
+		return err
+	}
+
+	return nil
+}
+
+// restore puts back an entry after a change to it couldn't be saved, so that memory
+// doesn't disagree with the file.  f.mu must be held.
+func (f *fileStore) restore(key string, previous *Entry, had bool) {
+	if had {
+		f.entries[key] = previous
+	} else {
+		delete(f.entries, key)
+	}
+}
+
+// save writes the entries to a temporary file and moves it into place, so a crash
+// never leaves a half-written list behind.  Expired entries are dropped.
+func (f *fileStore) save() error {
+	now := time.Now()
+	entries := make([]*Entry, 0, len(f.entries))
+
+	for _, entry := range f.entries {
+		if !entry.Expired(now) {
+			entries = append(entries, entry)
+		}
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,122 @@
+// Suppression lists
+//
+// Keeps track of addresses that must not be emailed, such as hard bounces and
+// unsubscribes, and strips them from outgoing emails.  The list can be kept up to date
+// from providers' delivery events, see NewEventCallback.
+
+package suppression
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Reason records why an address was suppressed.
+type Reason string
+
+const (
+	HardBounce    Reason = "hard_bounce"
+	SoftBounce    Reason = "soft_bounce"
+	SpamComplaint Reason = "spam_complaint"
+	Unsubscribed  Reason = "unsubscribed"
+	Manual        Reason = "manual"
+)
+
+// Entry is a single suppressed address.
+type Entry struct {
+	Address   string    `json:"address"`
+	Reason    Reason    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// The suppression is lifted after this time.  The zero value never expires.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the entry no longer applies at the given time.
+func (e *Entry) Expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// Suppressor is a store of suppressed addresses.  Addresses are compared case-insensitively.
+type Suppressor interface {
+	// Lookup returns the entry for the address, or nil if it isn't suppressed.
+	// Expired entries are treated as not suppressed.
+	Lookup(ctx context.Context, address string) (*Entry, error)
+
+	// Suppress adds or replaces the entry for its address.
+	Suppress(ctx context.Context, entry *Entry) error
+
+	// Unsuppress removes any entry for the address.
+	Unsuppress(ctx context.Context, address string) error
+}
+
+// NewMiddleware returns a middleware that drops suppressed recipients from every email
+// sent through the wrapped backend.  The dropped recipients are listed in the result's
+// Suppressed field.  If every recipient was suppressed, nothing is sent.
+func NewMiddleware(s Suppressor) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			suppressed := []*mail.Address{}
+
+			filter := func(recipients []*ego.Recipient) ([]*ego.Recipient, error) {
+				kept := make([]*ego.Recipient, 0, len(recipients))
+
+				for _, recip := range recipients {
+					entry, err := s.Lookup(ctx, recip.Email.Address)
+					if err != nil {
+						return nil, backends.NotSent(err)
+					}
+
+					if entry != nil {
+						suppressed = append(suppressed, recip.Email)
+					} else {
+						kept = append(kept, recip)
+					}
+				}
+
+				return kept, nil
+			}
+
+			to, err := filter(e.To)
+			if err != nil {
+				return nil, err
+			}
+			cc, err := filter(e.Cc)
+			if err != nil {
+				return nil, err
+			}
+			bcc, err := filter(e.Bcc)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(suppressed) == 0 {
+				return next.SendEmail(ctx, e)
+			}
+
+			if len(to)+len(cc)+len(bcc) == 0 {
+				return &backends.Result{Suppressed: suppressed}, nil
+			}
+
+			e = e.Clone()
+			e.To, e.Cc, e.Bcc = to, cc, bcc
+
+			result, err := next.SendEmail(ctx, e)
+			if result == nil {
+				result = &backends.Result{}
+			}
+			result.Suppressed = append(result.Suppressed, suppressed...)
+
+			return result, err
+		})
+	}
+}
+
+// normalize is how addresses are keyed in the stores.
+func normalize(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
@@ -0,0 +1,173 @@
+package suppression
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func captureBackend(sent **ego.Email) backends.Backend {
+	return backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		*sent = e
+		return &backends.Result{MessageID: "abc123"}, nil
+	})
+}
+
+// TestMiddleware checks that suppressed recipients are removed and reported.
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	e := testutils.TestEmail()
+	s.Suppress(ctx, &Entry{Address: "ZANE@anastacio.co.uk", Reason: HardBounce})
+
+	var sent *ego.Email
+	b := backends.Chain(captureBackend(&sent), NewMiddleware(s))
+
+	result, err := b.SendEmail(ctx, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sent.To) != len(e.To)-1 {
+		t.FailNow()
+	}
+
+	if len(result.Suppressed) != 1 || result.Suppressed[0].Address != e.To[0].Email.Address {
+		t.FailNow()
+	}
+
+	if result.MessageID != "abc123" {
+		t.FailNow()
+	}
+}
+
+// TestMiddlewareNothingLeft checks that nothing is sent when every recipient is suppressed.
+func TestMiddlewareNothingLeft(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	e := testutils.TestEmail()
+	for _, recip := range e.To {
+		s.Suppress(ctx, &Entry{Address: recip.Email.Address, Reason: Unsubscribed})
+	}
+
+	var sent *ego.Email
+	b := backends.Chain(captureBackend(&sent), NewMiddleware(s))
+
+	result, err := b.SendEmail(ctx, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != nil || len(result.Suppressed) != len(e.To) {
+		t.FailNow()
+	}
+}
+
+// failingSuppressor is a store that can't be reached.
+type failingSuppressor struct {
+	Suppressor
+}
+
+func (failingSuppressor) Lookup(ctx context.Context, address string) (*Entry, error) {
+	return nil, errors.New("store is down")
+}
+
+// TestMiddlewareLookupError checks that nothing is sent when the store can't be read.
+func TestMiddlewareLookupError(t *testing.T) {
+	var sent *ego.Email
+	b := backends.Chain(captureBackend(&sent), NewMiddleware(failingSuppressor{}))
+
+	if _, err := b.SendEmail(context.Background(), testutils.TestEmail()); !errors.Is(err, backends.ErrNotSent) || sent != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExpiry checks that expired entries no longer suppress.
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	s.Suppress(ctx, &Entry{Address: "jane@smith.com", ExpiresAt: time.Now().Add(-time.Minute)})
+
+	if entry, err := s.Lookup(ctx, "jane@smith.com"); err != nil || entry != nil {
+		t.FailNow()
+	}
+}
+
+// TestFile checks that the file store persists entries.
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "suppressions.json")
+
+	s, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Suppress(ctx, &Entry{Address: "jane@smith.com", Reason: SpamComplaint}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Suppress(ctx, &Entry{Address: "john@smith.com", Reason: Manual}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unsuppress(ctx, "john@smith.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen it
+	s, err = NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := s.Lookup(ctx, "Jane@Smith.com")
+	if err != nil || entry == nil || entry.Reason != SpamComplaint {
+		t.FailNow()
+	}
+
+	if entry, _ := s.Lookup(ctx, "john@smith.com"); entry != nil {
+		t.FailNow()
+	}
+}
+
+// TestFileSaveError checks that a change that couldn't be saved is undone in memory too.
+func TestFileSaveError(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "suppressions")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFile(filepath.Join(dir, "suppressions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Suppress(ctx, &Entry{Address: "jane@smith.com", Reason: Manual}); err != nil {
+		t.Fatal(err)
+	}
+
+	// nowhere to save to any more
+	os.RemoveAll(dir)
+
+	if err := s.Suppress(ctx, &Entry{Address: "john@smith.com", Reason: Manual}); err == nil {
+		t.FailNow()
+	}
+	if entry, _ := s.Lookup(ctx, "john@smith.com"); entry != nil {
+		t.Fatal(entry)
+	}
+
+	if err := s.Unsuppress(ctx, "jane@smith.com"); err == nil {
+		t.FailNow()
+	}
+	if entry, _ := s.Lookup(ctx, "jane@smith.com"); entry == nil {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,258 @@
+// Local templates
+//
+// Renders an email's TemplateID and TemplateContext into a concrete subject, HTML and text
+// body on our end, for backends whose provider doesn't host templates (or only does so
+// in a limited way).
+
+package templates
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/fanout"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	texttemplate "text/template"
+)
+
+// File names looked up in a template's directory.  Each is optional, but a template
+// must have at least one of them.
+const (
+	SubjectFile = "subject.tmpl"
+	HTMLFile    = "html.tmpl"
+	TextFile    = "text.tmpl"
+)
+
+// ErrNotFound is returned by a Store that has no template with the requested id.
+var ErrNotFound = errors.New("template not found")
+
+// ErrRecipientContext is returned by Render for an email with several recipients, some of
+// whom have their own TemplateContext, which a single rendering can't hold.
+var ErrRecipientContext = errors.New("recipients have their own template context")
+
+// Template is a parsed email template.  Any of the parts may be nil.
+type Template struct {
+	Subject *texttemplate.Template
+	HTML    *htmltemplate.Template
+	Text    *texttemplate.Template
+}
+
+// Store looks up templates by id.
+type Store interface {
+	Load(id string) (*Template, error)
+}
+
+// NewFSStore returns a Store that reads templates from fsys, which may be an embed.FS.
+// Each template is a directory named after its id, holding any of the files
+// subject.tmpl, html.tmpl and text.tmpl.  Templates are parsed once and then cached.
+func NewFSStore(fsys fs.FS) Store {
+	return &fsStore{fsys: fsys, cache: make(map[string]*Template)}
+}
+
+// NewDirStore returns a Store that reads templates from a directory on disk, laid out as
+// for NewFSStore.
+func NewDirStore(dir string) Store {
+	return NewFSStore(os.DirFS(dir))
+}
+
+type fsStore struct {
+	fsys fs.FS
+
+	mu    sync.Mutex
+	cache map[string]*Template
+}
+
+func (f *fsStore) Load(id string) (*Template, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if tmpl, ok := f.cache[id]; ok {
+		return tmpl, nil
+	}
+
+	if !fs.ValidPath(id) {
+		return nil, fmt.Errorf("invalid template id %q", id)
+	}
+
+	tmpl := &Template{}
+	found := false
+
+	for _, part := range []struct {
+		file  string
+		parse func(name, src string) error
+	}{
+		{SubjectFile, func(name, src string) (err error) {
+			tmpl.Subject, err = texttemplate.New(name).Option("missingkey=zero").Parse(src)
+			return
+		}},
+		{HTMLFile, func(name, src string) (err error) {
+			tmpl.HTML, err = htmltemplate.New(name).Option("missingkey=zero").Parse(src)
+			return
+		}},
+		{TextFile, func(name, src string) (err error) {
+			tmpl.Text, err = texttemplate.New(name).Option("missingkey=zero").Parse(src)
+			return
+		}},
+	} {
+		name := path.Join(id, part.file)
+
+		src, err := fs.ReadFile(f.fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err := part.parse(name, string(src)); err != nil {
+			return nil, err
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	f.cache[id] = tmpl
+	return tmpl, nil
+}
+
+// Render executes the template with ctx, filling in the email's subject and bodies.
+// Parts the template doesn't have are left as they are.
+func (t *Template) Render(e *ego.Email, ctx map[string]string) error {
+	buf := &bytes.Buffer{}
+
+	if t.Subject != nil {
+		if err := t.Subject.Execute(buf, ctx); err != nil {
+			return err
+		}
+		e.Subject = buf.String()
+		buf.Reset()
+	}
+
+	if t.HTML != nil {
+		if err := t.HTML.Execute(buf, ctx); err != nil {
+			return err
+		}
+		e.HTMLBody = buf.String()
+		buf.Reset()
+	}
+
+	if t.Text != nil {
+		if err := t.Text.Execute(buf, ctx); err != nil {
+			return err
+		}
+		e.TextBody = buf.String()
+	}
+
+	return nil
+}
+
+// Render returns a copy of the email with its template rendered from the store.  The
+// email's TemplateContext is used, and if the email has a single recipient, that
+// recipient's TemplateContext is layered on top.  With several recipients, none of them
+// may have a TemplateContext of their own, or ErrRecipientContext is returned; split the
+// email up with fanout.Split first.  The copy has no TemplateID, so backends send its
+// bodies as they are.
+func Render(s Store, e *ego.Email) (*ego.Email, error) {
+	tmpl, err := s.Load(e.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if fanout.NeedsSplit(e) {
+		return nil, ErrRecipientContext
+	}
+
+	ctx := make(map[string]string, len(e.TemplateContext))
+	for k, v := range e.TemplateContext {
+		ctx[k] = v
+	}
+
+	// past NeedsSplit, only a single recipient can have a context of their own
+	for _, recipients := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+		for _, recip := range recipients {
+			for k, v := range recip.TemplateContext {
+				ctx[k] = v
+			}
+		}
+	}
+
+	rendered := e.Clone()
+	if err := tmpl.Render(rendered, ctx); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %s", e.TemplateID, err)
+	}
+
+	rendered.TemplateID = ""
+	rendered.TemplateContext = map[string]string{}
+
+	return rendered, nil
+}
+
+// Option configures the middleware.
+type Option func(*options)
+
+type options struct {
+	concurrency int
+}
+
+// Concurrency sets the maximum number of sends in flight at once for an email that's
+// split up, see NewMiddleware.  Defaults to fanout.DefaultConcurrency.
+func Concurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// NewMiddleware returns a middleware that renders templated emails from the store before
+// they reach the wrapped backend.  Emails without a TemplateID pass straight through.
+//
+// An email whose recipients have their own TemplateContext is split up with fanout.Split
+// and rendered for each of them, since the rendered bodies can only hold one recipient's
+// context, and the copies are sent at once (see Concurrency).
+func NewMiddleware(s Store, opts ...Option) backends.Middleware {
+	o := &options{concurrency: fanout.DefaultConcurrency}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.concurrency <= 0 {
+		o.concurrency = fanout.DefaultConcurrency
+	}
+
+	return func(next backends.Backend) backends.Backend {
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if e.TemplateID == "" {
+				return next.SendEmail(ctx, e)
+			}
+
+			emails := []*ego.Email{e}
+			if fanout.NeedsSplit(e) {
+				split, err := fanout.Split(e)
+				if err != nil {
+					return nil, backends.NotSent(err)
+				}
+				emails = split
+			}
+
+			for i, single := range emails {
+				rendered, err := Render(s, single)
+				if err != nil {
+					return nil, backends.NotSent(err)
+				}
+				emails[i] = rendered
+			}
+
+			if len(emails) == 1 {
+				return next.SendEmail(ctx, emails[0])
+			}
+			return backends.SendAll(ctx, next, emails, o.concurrency)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package templates
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+//go:embed testdata
+var testdata embed.FS
+
+func embedStore(t *testing.T) Store {
+	sub, err := fs.Sub(testdata, "testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFSStore(sub)
+}
+
+// TestRender checks that the email is rendered with global and recipient context.
+func TestRender(t *testing.T) {
+	e := ego.NewEmail()
+	e.AddRecipient("Jane Smith", "jane@smith.com", map[string]string{"name": "Jane"})
+	e.TemplateID = "welcome"
+	e.TemplateContext["site"] = "<ego>"
+
+	rendered, err := Render(embedStore(t), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rendered.Subject != "Welcome, Jane!" {
+		t.Fatal(rendered.Subject)
+	}
+
+	if rendered.HTMLBody != "<h1>Welcome to &lt;ego&gt;, Jane</h1>\n" {
+		t.Fatal(rendered.HTMLBody)
+	}
+
+	if rendered.TextBody != "Welcome to <ego>, Jane\n" {
+		t.Fatal(rendered.TextBody)
+	}
+
+	if rendered.TemplateID != "" || e.TemplateID != "welcome" {
+		t.FailNow()
+	}
+}
+
+// TestRenderMissing checks the error for unknown templates.
+func TestRenderMissing(t *testing.T) {
+	e := testutils.TestEmail()
+	e.TemplateID = "missing"
+
+	if _, err := Render(embedStore(t), e); !errors.Is(err, ErrNotFound) {
+		t.Fatal(err)
+	}
+
+	e.TemplateID = "../welcome"
+	if _, err := Render(embedStore(t), e); err == nil {
+		t.FailNow()
+	}
+}
+
+// TestRenderRecipientContext checks that recipients' own context isn't silently dropped.
+func TestRenderRecipientContext(t *testing.T) {
+	e := testutils.TestEmail()
+	e.TemplateID = "welcome"
+
+	if _, err := Render(embedStore(t), e); err != ErrRecipientContext {
+		t.Fatal(err)
+	}
+
+	// without their own context, the recipients can share a rendering
+	for _, recip := range e.To {
+		recip.TemplateContext = nil
+	}
+	if _, err := Render(embedStore(t), e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestPartialTemplate checks that parts missing from a template are left alone.
+func TestPartialTemplate(t *testing.T) {
+	s := NewFSStore(fstest.MapFS{
+		"receipt/text.tmpl": {Data: []byte("Total: {{.total}}")},
+	})
+
+	e := testutils.TestEmail()
+	e.To = e.To[:1]
+	e.TemplateID = "receipt"
+	e.TemplateContext["total"] = "$10"
+
+	rendered, err := Render(s, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rendered.TextBody != "Total: $10" || rendered.Subject != e.Subject || rendered.HTMLBody != e.HTMLBody {
+		t.FailNow()
+	}
+}
+
+// TestMiddleware checks that the backend receives emails rendered for each recipient.
+func TestMiddleware(t *testing.T) {
+	mu := sync.Mutex{}
+	sent := map[string]*ego.Email{}
+	capture := backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent[e.To[0].Email.Address] = e
+		return &backends.Result{}, nil
+	})
+
+	b := backends.Chain(capture, NewMiddleware(embedStore(t)))
+
+	e := testutils.TestEmail()
+	e.TemplateID = "welcome"
+
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sent) != len(e.To) {
+		t.FailNow()
+	}
+
+	for _, recip := range e.To {
+		rendered := sent[recip.Email.Address]
+		if rendered.TemplateID != "" || rendered.Subject != "Welcome, "+recip.TemplateContext["name"]+"!" {
+			t.Fatal(rendered.Subject)
+		}
+	}
+
+	// recipients without their own context are sent a single email
+	sent = map[string]*ego.Email{}
+	for _, recip := range e.To {
+		recip.TemplateContext = nil
+	}
+
+	if _, err := b.SendEmail(context.Background(), e); err != nil || len(sent) != 1 {
+		t.Fatal(err)
+	}
+}
+
+// TestMiddlewareConcurrency checks that split emails are sent with the given concurrency.
+func TestMiddlewareConcurrency(t *testing.T) {
+	mu := sync.Mutex{}
+	inFlight, most := 0, 0
+	capture := backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return &backends.Result{}, nil
+	})
+
+	b := backends.Chain(capture, NewMiddleware(embedStore(t), Concurrency(1)))
+
+	e := testutils.TestEmail()
+	e.TemplateID = "welcome"
+
+	if result, err := b.SendEmail(context.Background(), e); err != nil || len(result.Recipients) != len(e.To) || most != 1 {
+		t.Fatal(result, err, most)
+	}
+}
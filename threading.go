@@ -0,0 +1,73 @@
+package ego
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewMessageID returns a globally unique Message-ID, without angle brackets, in the domain
+// of the from address (or of this host, if there's no from address).
+func NewMessageID(from *mail.Address) string {
+	domain := ""
+	if from != nil {
+		if at := strings.LastIndex(from.Address, "@"); at >= 0 {
+			domain = from.Address[at+1:]
+		}
+	}
+	if domain == "" {
+		if hostname, err := os.Hostname(); err == nil && hostname != "" {
+			domain = hostname
+		} else {
+			domain = "localhost"
+		}
+	}
+
+	random := make([]byte, 12)
+	rand.Read(random)
+
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "." + hex.EncodeToString(random) + "@" + domain
+}
+
+// SetInReplyTo threads the email as a reply to the message with the given Message-ID,
+// whose own References are parentReferences (nil if it started the thread).
+func (e *Email) SetInReplyTo(parentID string, parentReferences []string) {
+	e.InReplyTo = parentID
+	e.References = append(append([]string(nil), parentReferences...), parentID)
+}
+
+// ThreadingHeaders returns the email's Message-ID, or a new one from NewMessageID if it
+// doesn't have one, and the Message-ID, In-Reply-To and References header fields that
+// thread it, formatted as they're written in a message.  The email isn't changed, so
+// set MessageID beforehand to know the ID it'll be sent with.
+func (e *Email) ThreadingHeaders() (string, map[string]string) {
+	messageID := strings.Trim(e.MessageID, "<>")
+	if messageID == "" {
+		messageID = NewMessageID(e.From)
+	}
+
+	headers := map[string]string{"Message-ID": formatMessageID(messageID)}
+
+	if e.InReplyTo != "" {
+		headers["In-Reply-To"] = formatMessageID(e.InReplyTo)
+	}
+
+	if len(e.References) > 0 {
+		references := make([]string, 0, len(e.References))
+		for _, id := range e.References {
+			references = append(references, formatMessageID(id))
+		}
+		headers["References"] = strings.Join(references, " ")
+	}
+
+	return messageID, headers
+}
+
+// formatMessageID puts an ID in angle brackets, unless it already is.
+func formatMessageID(id string) string {
+	return "<" + strings.Trim(id, "<>") + ">"
+}
@@ -0,0 +1,44 @@
+package ego
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// TestNewMessageID checks that generated Message-IDs are unique and in the sender's domain.
+func TestNewMessageID(t *testing.T) {
+	from := &mail.Address{Address: "jade@example.com"}
+
+	first, second := NewMessageID(from), NewMessageID(from)
+	if first == second || !strings.HasSuffix(first, "@example.com") || strings.ContainsAny(first, "<> ") {
+		t.Fatal(first, second)
+	}
+
+	if id := NewMessageID(nil); !strings.Contains(id, "@") || strings.HasSuffix(id, "@") {
+		t.Fatal(id)
+	}
+}
+
+// TestThreadingHeaders checks the threading headers of a reply.
+func TestThreadingHeaders(t *testing.T) {
+	e := NewEmail()
+	e.From = &mail.Address{Address: "jade@example.com"}
+
+	messageID, headers := e.ThreadingHeaders()
+	if !strings.HasSuffix(messageID, "@example.com") || headers["Message-ID"] != "<"+messageID+">" || len(headers) != 1 {
+		t.Fatal(headers)
+	}
+
+	e.MessageID = "reply@example.com"
+	e.SetInReplyTo("second@example.com", []string{"first@example.com"})
+
+	messageID, headers = e.ThreadingHeaders()
+	if messageID != "reply@example.com" || headers["Message-ID"] != "<reply@example.com>" {
+		t.Fatal(headers)
+	}
+
+	if headers["In-Reply-To"] != "<second@example.com>" || headers["References"] != "<first@example.com> <second@example.com>" {
+		t.Fatal(headers)
+	}
+}
@@ -0,0 +1,90 @@
+// Tracing middleware
+//
+// Wraps every send in a span so slow or failing provider calls show up in request traces.
+// Spans are created through the small Tracer interface below, which maps directly onto
+// OpenTelemetry's tracer and span, without ego having to depend on it.
+
+package tracing
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+)
+
+// SpanName is the name given to every span started by the middleware.
+const SpanName = "ego.SendEmail"
+
+// Span attribute keys.
+const (
+	AttrBackend         = "ego.backend"
+	AttrRecipientCount  = "ego.recipient_count"
+	AttrTemplateID      = "ego.template_id"
+	AttrAttachmentsSize = "ego.attachments_size"
+	AttrMessageID       = "ego.message_id"
+	AttrHTTPStatus      = "http.status_code"
+)
+
+// Tracer starts spans.  The returned context should carry the new span, so that
+// anything the backend does with it (eg an instrumented http.Client) becomes a child.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// NewMiddleware returns a middleware that traces every send through the wrapped backend.
+func NewMiddleware(t Tracer) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		name := backends.Name(next)
+
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			ctx, span := t.Start(ctx, SpanName)
+			defer span.End()
+
+			span.SetAttribute(AttrBackend, name)
+			span.SetAttribute(AttrRecipientCount, len(e.To)+len(e.Cc)+len(e.Bcc))
+			span.SetAttribute(AttrAttachmentsSize, attachmentsSize(e))
+
+			if e.TemplateID != "" {
+				span.SetAttribute(AttrTemplateID, e.TemplateID)
+			}
+
+			result, err := next.SendEmail(ctx, e)
+
+			if result != nil {
+				if result.MessageID != "" {
+					span.SetAttribute(AttrMessageID, result.MessageID)
+				}
+				if result.StatusCode != 0 {
+					span.SetAttribute(AttrHTTPStatus, result.StatusCode)
+				}
+			}
+
+			if err != nil {
+				span.RecordError(err)
+			}
+
+			return result, err
+		})
+	}
+}
+
+// attachmentsSize totals the size of any attachments that can be measured without
+// consuming their data.
+func attachmentsSize(e *ego.Email) int64 {
+	size := int64(0)
+
+	for _, attachment := range e.Attachments {
+		if n := attachment.Size(); n > 0 {
+			size += n
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"strings"
+	"testing"
+)
+
+type spanKey struct{}
+
+type testSpan struct {
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *testSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *testSpan) End() {
+	s.ended = true
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &testSpan{attrs: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// TestSpan checks that a span is recorded with the expected attributes.
+func TestSpan(t *testing.T) {
+	tracer := &testTracer{}
+
+	var sawSpan bool
+	backend := backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		_, sawSpan = ctx.Value(spanKey{}).(*testSpan)
+		return &backends.Result{MessageID: "abc123", StatusCode: 200}, nil
+	})
+
+	e := testutils.TestEmail()
+	e.TemplateID = "test-template"
+	e.AddAttachment("test.txt", "text/plain", strings.NewReader("hello"))
+
+	b := backends.Chain(backend, NewMiddleware(tracer))
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if !sawSpan {
+		t.FailNow()
+	}
+
+	if len(tracer.spans) != 1 {
+		t.FailNow()
+	}
+
+	span := tracer.spans[0]
+
+	if !span.ended || span.err != nil {
+		t.FailNow()
+	}
+
+	if span.attrs[AttrRecipientCount] != len(e.To) {
+		t.FailNow()
+	}
+
+	if span.attrs[AttrTemplateID] != e.TemplateID {
+		t.FailNow()
+	}
+
+	if span.attrs[AttrAttachmentsSize] != int64(5) {
+		t.FailNow()
+	}
+
+	if span.attrs[AttrMessageID] != "abc123" || span.attrs[AttrHTTPStatus] != 200 {
+		t.FailNow()
+	}
+}
+
+// TestSpanError checks that send errors are recorded on the span.
+func TestSpanError(t *testing.T) {
+	tracer := &testTracer{}
+
+	backend := backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		return &backends.Result{StatusCode: 500}, errors.New("boom")
+	})
+
+	b := backends.Chain(backend, NewMiddleware(tracer))
+	if _, err := b.SendEmail(context.Background(), testutils.TestEmail()); err == nil {
+		t.FailNow()
+	}
+
+	span := tracer.spans[0]
+
+	if span.err == nil || !span.ended {
+		t.FailNow()
+	}
+
+	if span.attrs[AttrHTTPStatus] != 500 {
+		t.FailNow()
+	}
+}
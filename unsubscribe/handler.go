@@ -0,0 +1,76 @@
+package unsubscribe
+
+import (
+	"fmt"
+	"github.com/jarcoal/ego/suppression"
+	"html"
+	"net/http"
+	"time"
+)
+
+// NewHandler returns a handler for the unsubscribe links described by c, which adds the
+// address a link is for to the suppression list s as suppression.Unsubscribed.  Without
+// a Key, it answers every request with a server error.
+//
+// POST requests unsubscribe right away, as mail clients' one-click unsubscribes do.  GET
+// requests, from following the link, are shown a button that makes the POST, so that
+// link scanners fetching it don't unsubscribe anyone.
+func NewHandler(c Config, s suppression.Suppressor) http.Handler {
+	if c.MaxAge <= 0 {
+		c.MaxAge = DefaultMaxAge
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		address, err := Verify(c.Key, r.FormValue("token"), c.MaxAge)
+		switch err {
+		case nil:
+		case ErrNoKey:
+			http.Error(w, "unsubscribe links aren't configured", http.StatusInternalServerError)
+			return
+		case ErrExpiredToken:
+			http.Error(w, "expired unsubscribe link", http.StatusBadRequest)
+			return
+		default:
+			http.Error(w, "invalid unsubscribe link", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if r.Method == "GET" {
+			fmt.Fprintf(w, confirmPage, html.EscapeString(address))
+			return
+		}
+
+		err = s.Suppress(r.Context(), &suppression.Entry{
+			Address:   address,
+			Reason:    suppression.Unsubscribed,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			http.Error(w, "failed to unsubscribe", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintf(w, donePage, html.EscapeString(address))
+	})
+}
+
+const confirmPage = `<!DOCTYPE html>
+<title>Unsubscribe</title>
+<form method="post">
+<p>Unsubscribe %s?</p>
+<button type="submit">Unsubscribe</button>
+</form>
+`
+
+const donePage = `<!DOCTYPE html>
+<title>Unsubscribed</title>
+<p>%s has been unsubscribed.</p>
+`
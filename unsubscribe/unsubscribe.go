@@ -0,0 +1,226 @@
+// Unsubscribe links
+//
+// Gives every recipient their own signed unsubscribe link, sent in the List-Unsubscribe
+// header, and handles requests to those links by adding the recipient to a suppression list.
+
+package unsubscribe
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/fanout"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens that weren't signed with the key, or are malformed.
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// ErrExpiredToken is returned for tokens that are older than they may be.
+var ErrExpiredToken = errors.New("expired unsubscribe token")
+
+// ErrNoKey is returned when there's no key to sign or verify tokens with.
+var ErrNoKey = errors.New("no unsubscribe key")
+
+// DefaultMaxAge is how long links keep working when Config.MaxAge isn't set.
+const DefaultMaxAge = 90 * 24 * time.Hour
+
+// TokenVar is the name of the TemplateContext variable holding each recipient's token, for
+// backends that fill in the link themselves, see NewMiddleware.
+const TokenVar = "unsubscribe_token"
+
+// Token returns a token for the address, signed with key, that Verify accepts.  It holds
+// the time it was made, so that it expires.
+func Token(key []byte, address string) string {
+	return token(key, address, time.Now())
+}
+
+func token(key []byte, address string, issued time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strings.ToLower(address))) + "." +
+		strconv.FormatInt(issued.Unix(), 36)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(key, payload))
+}
+
+// Verify checks that the token was signed with key and is no older than maxAge, and
+// returns the address it's for.  A zero maxAge accepts any age.
+func Verify(key []byte, token string, maxAge time.Duration) (string, error) {
+	if len(key) == 0 {
+		return "", ErrNoKey
+	}
+
+	dot := strings.LastIndexByte(token, '.')
+	if dot < 0 {
+		return "", ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(token[dot+1:])
+	if err != nil || !hmac.Equal(signature, sign(key, token[:dot])) {
+		return "", ErrInvalidToken
+	}
+
+	parts := strings.Split(token[:dot], ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidToken
+	}
+
+	address, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(address) == 0 {
+		return "", ErrInvalidToken
+	}
+
+	issued, err := strconv.ParseInt(parts[1], 36, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if maxAge > 0 && time.Since(time.Unix(issued, 0)) > maxAge {
+		return "", ErrExpiredToken
+	}
+
+	return string(address), nil
+}
+
+func sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("unsubscribe:" + payload))
+	return mac.Sum(nil)[:16]
+}
+
+// Config describes the unsubscribe links given to recipients.
+type Config struct {
+	// Key signs the tokens in the links, and is required.  It must be kept secret, and be
+	// the same for NewMiddleware and NewHandler.
+	Key []byte
+
+	// URL is where NewHandler's handler is served, as an absolute http or https URL.  It
+	// should be https for mail clients to offer one-click unsubscribes.  Each recipient's token is added to it as the
+	// "token" query parameter.
+	URL string
+
+	// Mailto is an address that unsubscribes whoever emails it, offered alongside the URL.
+	// It's optional.
+	Mailto string
+
+	// MaxAge is how long a link keeps working after the email is sent.  Defaults to
+	// DefaultMaxAge.
+	MaxAge time.Duration
+
+	// Concurrency is passed to fanout.SendEach for emails that are split up.
+	Concurrency int
+}
+
+// Link returns the unsubscribe URL for the address.
+func (c Config) Link(address string) (string, error) {
+	if len(c.Key) == 0 {
+		return "", ErrNoKey
+	}
+	return c.link(Token(c.Key, address))
+}
+
+// link returns the unsubscribe URL with the token, which is added as it is so that it can
+// be a merge tag.  Tokens are safe in URLs as they are.
+func (c Config) link(token string) (string, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid unsubscribe URL: %s", err)
+	}
+
+	// mail clients can only follow a full web address
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("invalid unsubscribe URL %q: it must be an absolute http or https URL", c.URL)
+	}
+
+	query := u.Query()
+	query.Del("token")
+	u.RawQuery = query.Encode()
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += "token=" + token
+
+	return u.String(), nil
+}
+
+// NewMiddleware returns a middleware that gives every email sent through the wrapped
+// backend an unsubscribe link for its recipient, unless it already has an UnsubscribeURL.
+//
+// Each recipient needs a link of their own.  If the backend is a
+// backends.HeaderPersonalizer, the link holds a merge tag for TokenVar, which is added to
+// every recipient's TemplateContext for the provider to fill in.  Otherwise emails to
+// several recipients are split up with fanout.SendEach.
+func NewMiddleware(c Config) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		var personalizer backends.HeaderPersonalizer
+		mergeTag := ""
+		if backends.As(next, &personalizer) {
+			mergeTag = personalizer.HeaderMergeTag(TokenVar)
+		}
+
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if e.UnsubscribeURL != "" {
+				return next.SendEmail(ctx, e)
+			}
+			if len(c.Key) == 0 {
+				return nil, backends.NotSent(ErrNoKey)
+			}
+
+			if mergeTag != "" && len(e.To)+len(e.Cc)+len(e.Bcc) > 1 {
+				personalized, err := c.personalize(e, mergeTag)
+				if err != nil {
+					return nil, backends.NotSent(err)
+				}
+				return next.SendEmail(ctx, personalized)
+			}
+
+			return fanout.SendEach(ctx, next, e, c.Concurrency, func(single *ego.Email, recip *ego.Recipient) error {
+				link, err := c.Link(recip.Email.Address)
+				if err != nil {
+					return err
+				}
+
+				single.UnsubscribeURL = link
+				if single.UnsubscribeMailto == "" {
+					single.UnsubscribeMailto = c.Mailto
+				}
+				return nil
+			})
+		})
+	}
+}
+
+// personalize returns a copy of the email whose link is filled in by the provider from
+// each recipient's TemplateContext.
+func (c Config) personalize(e *ego.Email, mergeTag string) (*ego.Email, error) {
+	link, err := c.link(mergeTag)
+	if err != nil {
+		return nil, err
+	}
+
+	e = e.Clone()
+	e.UnsubscribeURL = link
+	if e.UnsubscribeMailto == "" {
+		e.UnsubscribeMailto = c.Mailto
+	}
+
+	for _, recipients := range [][]*ego.Recipient{e.To, e.Cc, e.Bcc} {
+		for i, recip := range recipients {
+			copied := *recip
+			copied.TemplateContext = make(map[string]string, len(recip.TemplateContext)+1)
+			for k, v := range recip.TemplateContext {
+				copied.TemplateContext[k] = v
+			}
+			copied.TemplateContext[TokenVar] = Token(c.Key, recip.Email.Address)
+
+			recipients[i] = &copied
+		}
+	}
+
+	return e, nil
+}
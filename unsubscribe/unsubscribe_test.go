@@ -0,0 +1,221 @@
+package unsubscribe
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/suppression"
+	"github.com/jarcoal/ego/testutils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var key = []byte("test-key")
+
+// TestToken checks that tokens verify with their key only, and can't be altered.
+func TestToken(t *testing.T) {
+	token := Token(key, "Zane@anastacio.co.uk")
+
+	address, err := Verify(key, token, time.Hour)
+	if err != nil || address != "zane@anastacio.co.uk" {
+		t.Fatal(address, err)
+	}
+
+	if _, err := Verify([]byte("other-key"), token, time.Hour); err != ErrInvalidToken {
+		t.Fatal(err)
+	}
+
+	forged := Token(key, "someone@else.com")
+	tampered := forged[:strings.IndexByte(forged, '.')] + token[strings.IndexByte(token, '.'):]
+	for _, invalid := range []string{"", "abc", tampered, token + "x"} {
+		if _, err := Verify(key, invalid, time.Hour); err != ErrInvalidToken {
+			t.Fatal(invalid, err)
+		}
+	}
+
+	if _, err := Verify(nil, token, time.Hour); err != ErrNoKey {
+		t.Fatal(err)
+	}
+}
+
+// TestTokenExpiry checks that old tokens are refused.
+func TestTokenExpiry(t *testing.T) {
+	old := token(key, "zane@anastacio.co.uk", time.Now().Add(-2*time.Hour))
+
+	if _, err := Verify(key, old, time.Hour); err != ErrExpiredToken {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(key, old, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMiddleware checks that every recipient gets their own link.
+func TestMiddleware(t *testing.T) {
+	mu := sync.Mutex{}
+	sent := []*ego.Email{}
+
+	b := backends.Chain(backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, e)
+		return &backends.Result{}, nil
+	}), NewMiddleware(Config{Key: key, URL: "https://example.com/unsubscribe?list=news", Mailto: "unsubscribe@example.com"}))
+
+	e := testutils.TestEmail()
+	e.To = e.To[:3]
+
+	result, err := b.SendEmail(context.Background(), e)
+	if err != nil || len(result.Recipients) != 3 || len(sent) != 3 {
+		t.Fatal(result, err)
+	}
+
+	for _, single := range sent {
+		link, err := url.Parse(single.UnsubscribeURL)
+		if err != nil || link.Query().Get("list") != "news" {
+			t.Fatal(single.UnsubscribeURL)
+		}
+
+		address, err := Verify(key, link.Query().Get("token"), time.Hour)
+		if err != nil || address != strings.ToLower(single.To[0].Email.Address) {
+			t.Fatal(address, err)
+		}
+
+		if single.ListHeaders()["List-Unsubscribe"] != "<mailto:unsubscribe@example.com>, <"+single.UnsubscribeURL+">" {
+			t.Fatal(single.ListHeaders())
+		}
+	}
+
+	if e.UnsubscribeURL != "" {
+		t.Fatal("the original email was changed")
+	}
+
+	// without a key nothing is sent
+	b = backends.Chain(backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		t.Fatal("sent without a key")
+		return nil, nil
+	}), NewMiddleware(Config{URL: "https://example.com/unsubscribe"}))
+
+	if _, err := b.SendEmail(context.Background(), e); !errors.Is(err, ErrNoKey) {
+		t.Fatal(err)
+	}
+
+	if _, err := (Config{URL: "https://example.com/unsubscribe"}).Link("zane@anastacio.co.uk"); err != ErrNoKey {
+		t.Fatal(err)
+	}
+
+	// nor without a URL mail clients can follow
+	for _, link := range []string{"", "/unsubscribe", "example.com/unsubscribe", "ftp://example.com/unsubscribe", "https:///unsubscribe"} {
+		if _, err := (Config{Key: key, URL: link}).Link("zane@anastacio.co.uk"); err == nil {
+			t.Fatal(link)
+		}
+
+		b = backends.Chain(backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			t.Fatal("sent without a URL")
+			return nil, nil
+		}), NewMiddleware(Config{Key: key, URL: link}))
+
+		if _, err := b.SendEmail(context.Background(), e); !errors.Is(err, backends.ErrNotSent) {
+			t.Fatal(link, err)
+		}
+	}
+}
+
+type personalizingBackend struct {
+	sent *ego.Email
+}
+
+func (p *personalizingBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	p.sent = e
+	return &backends.Result{}, nil
+}
+
+func (p *personalizingBackend) HeaderMergeTag(name string) string {
+	return "*|" + name + "|*"
+}
+
+// TestMiddlewarePersonalized checks that backends that fill in headers get a single email
+// with each recipient's token in their context.
+func TestMiddlewarePersonalized(t *testing.T) {
+	p := &personalizingBackend{}
+	b := backends.Chain(p, NewMiddleware(Config{Key: key, URL: "https://example.com/unsubscribe?list=news"}))
+
+	e := testutils.TestEmail()
+	e.Cc = e.To[3:4]
+	e.To = e.To[:3]
+
+	if _, err := b.SendEmail(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.sent.UnsubscribeURL != "https://example.com/unsubscribe?list=news&token=*|unsubscribe_token|*" ||
+		len(p.sent.To) != 3 || len(p.sent.Cc) != 1 {
+		t.Fatal(p.sent.UnsubscribeURL)
+	}
+
+	for _, recip := range append(p.sent.To, p.sent.Cc...) {
+		address, err := Verify(key, recip.TemplateContext[TokenVar], time.Hour)
+		if err != nil || address != strings.ToLower(recip.Email.Address) || recip.TemplateContext["name"] == "" {
+			t.Fatal(recip.TemplateContext, err)
+		}
+	}
+
+	if _, ok := e.To[0].TemplateContext[TokenVar]; ok {
+		t.Fatal("the original recipients were changed")
+	}
+}
+
+// TestHandler checks that a one-click POST unsubscribes, and that a GET only asks to.
+func TestHandler(t *testing.T) {
+	s := suppression.NewMemory()
+	server := httptest.NewServer(NewHandler(Config{Key: key}, s))
+	defer server.Close()
+
+	link := server.URL + "?token=" + url.QueryEscape(Token(key, "zane@anastacio.co.uk"))
+
+	resp, err := http.Get(link)
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatal(resp, err)
+	}
+
+	if entry, _ := s.Lookup(context.Background(), "zane@anastacio.co.uk"); entry != nil {
+		t.Fatal("unsubscribed by a GET")
+	}
+
+	resp, err = http.Post(link, "application/x-www-form-urlencoded", strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatal(resp, err)
+	}
+
+	entry, _ := s.Lookup(context.Background(), "zane@anastacio.co.uk")
+	if entry == nil || entry.Reason != suppression.Unsubscribed {
+		t.Fatal(entry)
+	}
+
+	resp, err = http.Post(server.URL+"?token=forged", "application/x-www-form-urlencoded", nil)
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatal(resp, err)
+	}
+
+	// expired links, and a handler without a key, don't unsubscribe anyone
+	old := token(key, "zane@anastacio.co.uk", time.Now().Add(-DefaultMaxAge-time.Hour))
+	resp, err = http.Post(server.URL+"?token="+old, "application/x-www-form-urlencoded", nil)
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatal(resp, err)
+	}
+
+	keyless := httptest.NewServer(NewHandler(Config{}, s))
+	defer keyless.Close()
+
+	resp, err = http.Post(keyless.URL+"?token=forged", "application/x-www-form-urlencoded", nil)
+	if err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Fatal(resp, err)
+	}
+}
@@ -0,0 +1,101 @@
+// Variable envelope return paths
+//
+// VERP encodes the recipient of an email in the address its bounces go back to, such as
+// bounces+jane=example.com@mail.ourdomain.com for jane@example.com, so that a bounce says
+// who it's for even when its content doesn't.
+
+package verp
+
+import (
+	"context"
+	"errors"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/fanout"
+	"strings"
+)
+
+// ErrNotVERP is returned when decoding an address that doesn't encode a recipient.
+var ErrNotVERP = errors.New("not a VERP address")
+
+// Encode returns the return path for bounces of the recipient's email, in the mailbox of
+// bounceAddress: bounces@ourdomain.com becomes bounces+jane=example.com@ourdomain.com.
+func Encode(bounceAddress, recipient string) (string, error) {
+	// the first + in a return path starts the recipient
+	at := strings.LastIndex(bounceAddress, "@")
+	if at <= 0 || strings.Contains(bounceAddress[:at], "+") {
+		return "", errors.New("invalid bounce address " + bounceAddress)
+	}
+
+	ra := strings.LastIndex(recipient, "@")
+	if ra <= 0 || ra == len(recipient)-1 {
+		return "", errors.New("invalid recipient address " + recipient)
+	}
+
+	return bounceAddress[:at] + "+" + recipient[:ra] + "=" + recipient[ra+1:] + bounceAddress[at:], nil
+}
+
+// Decode returns the recipient encoded in a return path by Encode.
+func Decode(address string) (string, error) {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return "", ErrNotVERP
+	}
+	local := address[:at]
+
+	plus := strings.IndexByte(local, '+')
+	if plus < 0 {
+		return "", ErrNotVERP
+	}
+	encoded := local[plus+1:]
+
+	// the recipient's domain can't have an =, but its local part can
+	eq := strings.LastIndexByte(encoded, '=')
+	if eq <= 0 || eq == len(encoded)-1 {
+		return "", ErrNotVERP
+	}
+
+	return encoded[:eq] + "@" + encoded[eq+1:], nil
+}
+
+// Config describes the return paths given to emails.
+type Config struct {
+	// BounceAddress is the mailbox bounces are delivered to, such as bounces@ourdomain.com.
+	BounceAddress string
+
+	// Concurrency is passed to fanout.SendEach for emails that are split up.
+	Concurrency int
+}
+
+// NewMiddleware returns a middleware that gives every email sent through the wrapped
+// backend a ReturnPath encoding its recipient, unless it already has one.  As each
+// recipient needs a return path of their own, emails to several recipients are split up
+// with fanout.SendEach.
+//
+// Emails are passed through as they are to backends that aren't a
+// backends.EnvelopeSender, such as Mandrill (which only uses the domain of a return path)
+// and SendGrid (which handles bounces itself), as splitting them up would gain nothing.
+func NewMiddleware(c Config) backends.Middleware {
+	return func(next backends.Backend) backends.Backend {
+		var sender backends.EnvelopeSender
+		if !backends.As(next, &sender) || !sender.SupportsEnvelopeSender() {
+			return next
+		}
+
+		return backends.Wrap(next, func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+			if e.ReturnPath != "" {
+				return next.SendEmail(ctx, e)
+			}
+
+			return fanout.SendEach(ctx, next, e, c.Concurrency, func(single *ego.Email, recip *ego.Recipient) error {
+				returnPath, err := Encode(c.BounceAddress, recip.Email.Address)
+				if err != nil {
+					return err
+				}
+
+				single.ReturnPath = returnPath
+				return nil
+			})
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package verp
+
+import (
+	"context"
+	"github.com/jarcoal/ego"
+	"github.com/jarcoal/ego/backends"
+	"github.com/jarcoal/ego/testutils"
+	"sync"
+	"testing"
+)
+
+// TestEncodeDecode checks that recipients round trip through return paths.
+func TestEncodeDecode(t *testing.T) {
+	for _, recipient := range []string{"jane@example.com", "jane+news=weekly@example.com"} {
+		returnPath, err := Encode("bounces@mail.ourdomain.com", recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := Decode(returnPath)
+		if err != nil || decoded != recipient {
+			t.Fatal(returnPath, decoded, err)
+		}
+	}
+
+	if returnPath, _ := Encode("bounces@mail.ourdomain.com", "jane@example.com"); returnPath != "bounces+jane=example.com@mail.ourdomain.com" {
+		t.Fatal(returnPath)
+	}
+
+	for _, address := range []string{"bounces@ourdomain.com", "bounces+jane@ourdomain.com", "bounces+=example.com@ourdomain.com", "nope"} {
+		if _, err := Decode(address); err != ErrNotVERP {
+			t.Fatal(address, err)
+		}
+	}
+
+	if _, err := Encode("bounces+x@ourdomain.com", "jane@example.com"); err == nil {
+		t.FailNow()
+	}
+}
+
+// envelopeBackend records the emails it's sent, using their whole return path.
+type envelopeBackend struct {
+	mu   sync.Mutex
+	sent []*ego.Email
+}
+
+func (b *envelopeBackend) SendEmail(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent = append(b.sent, e)
+	return &backends.Result{}, nil
+}
+
+func (b *envelopeBackend) SupportsEnvelopeSender() bool {
+	return true
+}
+
+// TestMiddleware checks that every recipient is sent an email with their own return path.
+func TestMiddleware(t *testing.T) {
+	capture := &envelopeBackend{}
+	b := backends.Chain(capture, NewMiddleware(Config{BounceAddress: "bounces@austen.name"}))
+
+	e := testutils.TestEmail()
+	e.To = e.To[:3]
+
+	result, err := b.SendEmail(context.Background(), e)
+	if err != nil || len(result.Recipients) != 3 || len(capture.sent) != 3 {
+		t.Fatal(result, err)
+	}
+
+	for _, single := range capture.sent {
+		if decoded, err := Decode(single.ReturnPath); err != nil || decoded != single.To[0].Email.Address {
+			t.Fatal(single.ReturnPath, err)
+		}
+	}
+
+	if e.ReturnPath != "" {
+		t.Fatal("the original email was changed")
+	}
+}
+
+// TestPassthrough checks that emails aren't split up for backends that don't use the whole
+// return path.
+func TestPassthrough(t *testing.T) {
+	sent := []*ego.Email{}
+	b := backends.Chain(backends.BackendFunc(func(ctx context.Context, e *ego.Email) (*backends.Result, error) {
+		sent = append(sent, e)
+		return &backends.Result{}, nil
+	}), NewMiddleware(Config{BounceAddress: "bounces@austen.name"}))
+
+	e := testutils.TestEmail()
+	e.To = e.To[:3]
+
+	if _, err := b.SendEmail(context.Background(), e); err != nil || len(sent) != 1 || sent[0] != e {
+		t.Fatal(sent, err)
+	}
+}